@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// adjust holds the picture controls. DOOM is dark and terminals crush
+// blacks, so these are applied to every frame through a lookup table.
+type adjust struct {
+	gamma      float64 // > 1 lifts the shadows
+	brightness float64 // added after contrast, -1..1
+	contrast   float64 // scales around mid grey
+	lut        [256]uint8
+	identity   bool
+}
+
+// update rebuilds the lookup table after a setting changed.
+func (a *adjust) update() {
+	a.identity = true
+	for i := range a.lut {
+		v := math.Pow(float64(i)/255, 1/a.gamma)
+		v = (v-0.5)*a.contrast + 0.5 + a.brightness
+		a.lut[i] = clamp8(int(math.Round(v * 255)))
+		if a.lut[i] != uint8(i) {
+			a.identity = false
+		}
+	}
+}
+
+// apply adjusts img in place.
+func (a *adjust) apply(img *image.RGBA) {
+	if a.identity {
+		return
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+		for o := 0; o < len(row); o += 4 {
+			row[o] = a.lut[row[o]]
+			row[o+1] = a.lut[row[o+1]]
+			row[o+2] = a.lut[row[o+2]]
+		}
+	}
+}
+
+// key handles the runtime adjustment keys, pressed with Alt, reporting
+// whether seq was one.
+//
+//	[ ]  brightness   { }  contrast   ( )  gamma   \  reset
+func (a *adjust) key(seq []byte) bool {
+	if len(seq) != 1 {
+		return false
+	}
+	switch seq[0] {
+	case '[':
+		a.brightness = math.Max(a.brightness-0.05, -1)
+	case ']':
+		a.brightness = math.Min(a.brightness+0.05, 1)
+	case '{':
+		a.contrast = math.Max(a.contrast-0.1, 0.1)
+	case '}':
+		a.contrast = math.Min(a.contrast+0.1, 4)
+	case '(':
+		a.gamma = math.Max(a.gamma-0.1, 0.2)
+	case ')':
+		a.gamma = math.Min(a.gamma+0.1, 4)
+	case '\\':
+		a.gamma, a.brightness, a.contrast = 1, 0, 1
+	default:
+		return false
+	}
+	a.update()
+	return true
+}
+
+func (a *adjust) String() string {
+	return fmt.Sprintf("gamma %.1f brightness %+.2f contrast %.1f", a.gamma, a.brightness, a.contrast)
+}
+
+// copyFrame copies src into dst, allocating dst on first use or when the
+// size changes.
+func copyFrame(dst, src *image.RGBA) *image.RGBA {
+	if dst == nil || dst.Rect != src.Rect {
+		dst = image.NewRGBA(src.Rect)
+	}
+	copy(dst.Pix, src.Pix)
+	return dst
+}
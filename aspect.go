@@ -0,0 +1,77 @@
+package main
+
+import "image"
+
+// doomAspect is the shape DOOM's 320×200 frame was meant to be shown at:
+// 4:3 monitors with tall, non-square pixels.
+const doomAspect = 4.0 / 3.0
+
+// defaultCellAspect is a typical terminal cell's width divided by its height.
+const defaultCellAspect = 0.5
+
+// cellAspect returns the width:height ratio of one terminal cell, taken from
+// the pixel size the terminal reports when setting is 0.
+func cellAspect(setting float64) float64 {
+	if setting > 0 {
+		return setting
+	}
+	if w, h, ok := cellPixels(); ok {
+		return float64(w) / float64(h)
+	}
+	return defaultCellAspect
+}
+
+// fitAspect returns the largest cols×rows area within w×h cells that shows
+// a picture of the given shape (4:3 for a whole frame) given the cell
+// aspect.
+func fitAspect(w, h int, aspect, shape float64) (cols, rows int) {
+	if float64(w)*aspect/float64(h) > shape {
+		// wide terminal: full height, bars left and right
+		cols = int(float64(h)*shape/aspect + 0.5)
+		return min(cols, w), h
+	}
+	rows = int(float64(w)*aspect/shape + 0.5)
+	return w, min(max(rows, 1), h)
+}
+
+var scaleModes = map[string]bool{"fit": true, "fill": true, "stretch": true, "integer": true}
+
+// place decides where a srcW×srcH frame, shown at the given shape, goes in
+// a w×h cell terminal for a --scale mode. It returns the cols×rows area to draw, centered by the
+// caller with bars around it, and the part of the frame to show there.
+// pxPerCol is how many image pixels one column holds, which integer mode
+// needs to line pixels up.
+func place(mode string, w, h int, aspect, shape float64, pxPerCol, srcW, srcH int) (cols, rows int, src image.Rectangle) {
+	src = image.Rect(0, 0, srcW, srcH)
+	switch mode {
+	case "stretch":
+		return w, h, src
+	case "fill":
+		// cover the whole terminal and crop what sticks out
+		term := float64(w) * aspect / float64(h)
+		if term > shape {
+			ch := int(float64(srcH) * shape / term)
+			y := (srcH - ch) / 2
+			src = image.Rect(0, y, srcW, y+max(ch, 1))
+		} else {
+			cw := int(float64(srcW) * term / shape)
+			x := (srcW - cw) / 2
+			src = image.Rect(x, 0, x+max(cw, 1), srcH)
+		}
+		return w, h, src
+	}
+	cols, rows = fitAspect(w, h, aspect, shape)
+	if mode != "integer" {
+		return cols, rows, src
+	}
+	// largest whole multiple, or whole fraction, of the frame's width
+	px := cols * pxPerCol
+	var iw int
+	if px >= srcW {
+		iw = px / srcW * srcW
+	} else {
+		iw = srcW / ((srcW + px - 1) / px)
+	}
+	ic := max(iw/pxPerCol, 1)
+	return ic, max(rows*ic/cols, 1), src
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+// automapView redraws the automap with line-drawing characters. The map
+// is thin one-pixel lines on black, which every downscaling renderer
+// thins out to nothing at terminal sizes; tracing the lines per cell
+// keeps each one visible. gore doesn't expose the level geometry, so the
+// lines are traced from the engine's own drawing of the map.
+type automapView struct {
+	text   *textScreens // for the small font the map's title is in
+	titleY int
+	title  string
+	bg     color.RGBA
+}
+
+func newAutomapView(w *wad) (*automapView, error) {
+	s, err := newTextScreens(w)
+	if err != nil {
+		return nil, err
+	}
+	a := &automapView{text: s, titleY: stBarY - 1 - 7}
+	if p := s.font[0]; p != nil {
+		// the engine puts the title's baseline just above the status bar
+		a.titleY = stBarY - 1 - p.h
+	}
+	return a, nil
+}
+
+// read reports whether img is the automap, which is a mostly blank
+// screen with the level's name at the bottom left.
+func (a *automapView) read(img *image.RGBA) bool {
+	if img.Rect.Dx() != 320 || img.Rect.Dy() != 200 {
+		return false
+	}
+	bg := img.Pix[0:3]
+	blank, n := 0, 0
+	for y := 0; y < a.titleY; y += 7 {
+		for x := 0; x < 320; x += 5 {
+			o := y*img.Stride + x*4
+			if img.Pix[o] == bg[0] && img.Pix[o+1] == bg[1] && img.Pix[o+2] == bg[2] {
+				blank++
+			}
+			n++
+		}
+	}
+	if blank*10 < n*8 {
+		return false
+	}
+	// the palette whose black the background is, then the title in it
+	s := a.text
+	for pal := range s.pals {
+		c := s.pals[pal][0]
+		if c.R != bg[0] || c.G != bg[1] || c.B != bg[2] {
+			continue
+		}
+		s.pal = pal
+		if t := s.readText(img, 0, a.titleY); strings.Contains(t, ":") {
+			a.title = t
+			return true
+		}
+	}
+	return false
+}
+
+// boxArms maps which sides of a cell lines leave by (left 1, right 2,
+// up 4, down 8) to the glyph joining them.
+var boxArms = [16]rune{
+	' ', '╴', '╶', '─', '╵', '┘', '└', '┴',
+	'╷', '┐', '┌', '┬', '│', '┤', '├', '┼',
+}
+
+// draw traces the map in img over g, which shows img at one cell per
+// g.w-th of its width and g.h-th of its height. bg is the color of the
+// map's background as img has it.
+func (a *automapView) draw(g *grid, img *image.RGBA, bg color.RGBA) {
+	r := img.Rect
+	a.bg = bg
+	for cy := 0; cy < g.h; cy++ {
+		y0 := r.Min.Y + cy*r.Dy()/g.h
+		y1 := r.Min.Y + (cy+1)*r.Dy()/g.h
+		if (y0+y1)/2 >= stBarY {
+			break
+		}
+		if (y0+y1)/2 >= a.titleY {
+			a.drawTitle(g, cy)
+			continue
+		}
+		y1 = min(y1, a.titleY)
+		for cx := 0; cx < g.w; cx++ {
+			x0 := r.Min.X + cx*r.Dx()/g.w
+			x1 := r.Min.X + (cx+1)*r.Dx()/g.w
+			g.set(cx, cy, a.trace(img, x0, y0, x1, y1))
+		}
+	}
+}
+
+func (a *automapView) drawTitle(g *grid, cy int) {
+	for cx := 0; cx < g.w; cx++ {
+		g.set(cx, cy, cell{ch: ' ', bg: a.bg})
+	}
+	for i, ch := range []rune(a.title) {
+		if i+1 >= g.w {
+			break
+		}
+		g.set(i+1, cy, cell{ch: ch, fg: panelFG, bg: a.bg})
+	}
+}
+
+// trace turns the pixels in [x0,x1)×[y0,y1) into one cell: a box-drawing
+// glyph when they are horizontal and vertical strokes, otherwise Braille
+// dots for whatever is lit, in the color most of the lit pixels have.
+func (a *automapView) trace(img *image.RGBA, x0, y0, x1, y1 int) cell {
+	w, h := x1-x0, y1-y0
+	c := cell{ch: ' ', bg: a.bg}
+	if w <= 0 || h <= 0 {
+		return c
+	}
+	lit := func(x, y int) bool {
+		p := img.Pix[img.PixOffset(x, y):]
+		return p[0] != a.bg.R || p[1] != a.bg.G || p[2] != a.bg.B
+	}
+
+	// count lit pixels per row and column, and vote on the color
+	var rows, cols [16]int
+	var colors [4]color.RGBA
+	var votes [4]int
+	var bits rune
+	n := 0
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			if !lit(x, y) {
+				continue
+			}
+			n++
+			rows[min((y-y0), 15)]++
+			cols[min((x-x0), 15)]++
+			bits |= brailleBits[(y-y0)*4/h][(x-x0)*2/w]
+			p := img.RGBAAt(x, y)
+			for i := range colors {
+				if votes[i] == 0 || colors[i] == p {
+					colors[i] = p
+					votes[i]++
+					break
+				}
+			}
+		}
+	}
+	if n == 0 {
+		return c
+	}
+	best := 0
+	for i := range votes {
+		if votes[i] > votes[best] {
+			best = i
+		}
+	}
+	c.fg = colors[best]
+	c.ch = 0x2800 | bits
+	if w > 16 || h > 16 || n < 3 {
+		return c
+	}
+
+	// the strongest row and column: if everything lit is on one or
+	// the other, they are strokes and the cell gets a box-drawing glyph
+	hr, vc := 0, 0
+	for i := range rows {
+		if rows[i] > rows[hr] {
+			hr = i
+		}
+		if cols[i] > cols[vc] {
+			vc = i
+		}
+	}
+	hr, vc = y0+hr, x0+vc
+	hmin, hmax, vmin, vmax := x1, x0-1, y1, y0-1
+	hasH, hasV := false, false
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			if !lit(x, y) {
+				continue
+			}
+			if y != hr && x != vc {
+				// a diagonal or a shape: leave it to the dots
+				return c
+			}
+			if y == hr {
+				hmin, hmax = min(hmin, x), max(hmax, x)
+				hasH = hasH || x != vc
+			}
+			if x == vc {
+				vmin, vmax = min(vmin, y), max(vmax, y)
+				hasV = hasV || y != hr
+			}
+		}
+	}
+
+	arms := 0
+	switch {
+	case hasH && hasV:
+		// strokes meeting: their arms are the sides they reach
+		if hmin < vc {
+			arms |= 1
+		}
+		if hmax > vc {
+			arms |= 2
+		}
+		if vmin < hr {
+			arms |= 4
+		}
+		if vmax > hr {
+			arms |= 8
+		}
+	case hasH:
+		arms = ends(hmin-x0, hmax-x0, w, 1, 2)
+	case hasV:
+		arms = ends(vmin-y0, vmax-y0, h, 4, 8)
+	default:
+		return c
+	}
+	c.ch = boxArms[arms]
+	return c
+}
+
+// ends gives the arms of a lone stroke from lo to hi across a cell n
+// long: the sides it comes near, or both for a short one in the middle.
+func ends(lo, hi, n, low, high int) int {
+	arms := 0
+	if lo <= n/4 {
+		arms |= low
+	}
+	if hi >= n-1-n/4 {
+		arms |= high
+	}
+	if arms == 0 {
+		arms = low | high
+	}
+	return arms
+}
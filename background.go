@@ -0,0 +1,63 @@
+package main
+
+import (
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// backgrounds are the --background settings: auto asks the terminal,
+// black switches the terminal's background to black while playing.
+var backgrounds = map[string]bool{"auto": true, "dark": true, "light": true, "black": true}
+
+// lightBackground makes renderers that leave the background to the
+// terminal draw dark on light: dots and dense glyphs where the picture
+// is dark rather than where it is bright.
+var lightBackground bool
+
+// parseOSCColor reads a color as terminals report it in OSC replies,
+// rgb:R/G/B with one to four hex digits per channel.
+func parseOSCColor(spec string) (color.RGBA, bool) {
+	rest, ok := strings.CutPrefix(spec, "rgb:")
+	if !ok {
+		return color.RGBA{}, false
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 {
+		return color.RGBA{}, false
+	}
+	var ch [3]uint8
+	for i, p := range parts {
+		v, err := strconv.ParseUint(p, 16, 16)
+		if err != nil || len(p) == 0 || len(p) > 4 {
+			return color.RGBA{}, false
+		}
+		ch[i] = uint8(v * 255 / (1<<(4*len(p)) - 1))
+	}
+	return color.RGBA{ch[0], ch[1], ch[2], 255}, true
+}
+
+// resolveBackground turns --background into dark or light, given the
+// terminal's answer to OSC 11 if any.
+func resolveBackground(setting string, caps termCaps) string {
+	switch setting {
+	case "auto":
+		if caps.bgSpec != "" && luma(caps.bg.R, caps.bg.G, caps.bg.B) > 128 {
+			return "light"
+		}
+		return "dark"
+	case "black":
+		return "dark"
+	}
+	return setting
+}
+
+// restoreBackground is the sequence putting the terminal's background
+// back after --background=black.
+func restoreBackground(caps termCaps) string {
+	if caps.bgSpec != "" {
+		return "\x1b]11;" + caps.bgSpec + "\x1b\\"
+	}
+	// the terminal's configured default
+	return "\x1b]111\x1b\\"
+}
@@ -0,0 +1,33 @@
+package main
+
+import "time"
+
+// bellGap is the least time between bells, so a volley of damage rings
+// once rather than buzzing.
+const bellGap = 500 * time.Millisecond
+
+// bell rings the terminal bell, for --bell, when the status bar shows the
+// player hurt or given a key: the sounds most worth hearing, for a game
+// that has none here.
+type bell struct {
+	last hudState
+	seen bool
+	rang time.Time
+}
+
+// ring takes the status bar as read now, reporting whether to ring.
+func (b *bell) ring(s hudState, now time.Time) bool {
+	last, seen := b.last, b.seen
+	b.last, b.seen = s, true
+	if !seen || now.Sub(b.rang) < bellGap {
+		return false
+	}
+	ring := s.health >= 0 && last.health >= 0 && s.health < last.health
+	for i, k := range s.keys {
+		ring = ring || k > last.keys[i]
+	}
+	if ring {
+		b.rang = now
+	}
+	return ring
+}
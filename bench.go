@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AndreRenaud/gore"
+	"golang.org/x/term"
+)
+
+// benchReport is what termdoom bench found.
+type benchReport struct {
+	Demo     string `json:"demo"`
+	Renderer string `json:"renderer"`
+	Colors   string `json:"colors"`
+	Size     string `json:"size"`
+	// the engine's own timing of the demo
+	GameTics    int     `json:"game_tics"`
+	TicsPerSec  float64 `json:"tics_per_sec"`
+	Seconds     float64 `json:"seconds"`
+	Frames      int     `json:"frames"`
+	FrameAvgMS  float64 `json:"frame_avg_ms"`
+	FrameP95MS  float64 `json:"frame_p95_ms"`
+	BytesPer    float64 `json:"bytes_per_frame"`
+	AllocsPer   float64 `json:"allocs_per_frame"`
+	AllocBytes  float64 `json:"alloc_bytes_per_frame"`
+	TotalAllocs uint64  `json:"total_allocs"`
+}
+
+func (r *benchReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "demo          %s\n", r.Demo)
+	fmt.Fprintf(&b, "renderer      %s, %s colors, %s cells\n", r.Renderer, r.Colors, r.Size)
+	fmt.Fprintf(&b, "game          %d tics in %.2fs, %.1f tics/s\n", r.GameTics, r.Seconds, r.TicsPerSec)
+	fmt.Fprintf(&b, "frames        %d\n", r.Frames)
+	fmt.Fprintf(&b, "frame time    %.3f ms average, %.3f ms 95th percentile\n", r.FrameAvgMS, r.FrameP95MS)
+	fmt.Fprintf(&b, "output        %.0f bytes a frame\n", r.BytesPer)
+	fmt.Fprintf(&b, "allocations   %.1f a frame, %.0f bytes a frame, %d in all\n", r.AllocsPer, r.AllocBytes, r.TotalAllocs)
+	return b.String()
+}
+
+// benchCommand is termdoom bench: it runs a demo as a timedemo, as fast as
+// the engine goes, converting every frame, and reports how long converting
+// took and what it came to. Without --render the frames go nowhere and no
+// terminal is needed.
+func benchCommand(args []string) {
+	fs := flag.NewFlagSet("termdoom bench", flag.ExitOnError)
+	renderer := fs.String("renderer", "halfblock", "frame renderer: "+rendererList())
+	colors := fs.String("colors", "truecolor", "color depth: truecolor, 256, 16, none")
+	size := fs.String("size", "160x50", "terminal size in cells to convert for, without --render")
+	render := fs.Bool("render", false, "draw the frames in this terminal, at its size, and count writing them")
+	diff := fs.Bool("diff", true, "only redraw cells that changed since the last frame")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	demoDir := fs.String("demo-dir", "demos", "where demos named without a directory are looked for")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: termdoom bench [--flags] demo [engine args, e.g. -iwad doom1.wad]\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() < 1 {
+		usageError(fs, "bench needs a demo, such as demo1")
+	}
+	if _, ok := renderers[*renderer]; !ok {
+		usageError(fs, "unknown renderer %q", *renderer)
+	}
+	mode, ok := colorModes[*colors]
+	if !ok {
+		usageError(fs, "unknown color depth %q", *colors)
+	}
+	w, h, err := parseMinimapSize(*size)
+	if err != nil || w < minCols || h < minRows {
+		usageError(fs, "size must be at least %dx%d", minCols, minRows)
+	}
+	demo, lump, err := findDemo(*demoDir, fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "termdoom:", err)
+		os.Exit(1)
+	}
+	engineArgs := chooseIWAD(append([]string{"-timedemo", lump}, fs.Args()[1:]...))
+
+	out := &countingWriter{w: io.Discard}
+	td := benchDoom(*renderer, mode, w, h, out, *diff)
+	stdout := quietEngine()
+	if *render {
+		fd := int(os.Stdin.Fd())
+		oldState, err := term.MakeRaw(fd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "terminal raw mode:", err)
+			os.Exit(1)
+		}
+		fmt.Fprint(stdout, "\x1b[?1049h\x1b[2J\x1b[H\x1b[?25l")
+		out.w = stdout
+		td.sizeValid, td.watching = false, false
+		td.cellAspect = cellAspect(0)
+		td.keys = keyReader(os.Stdin)
+		report, err := runBench(td, out, demo, engineArgs)
+		fmt.Fprint(stdout, "\x1b[0m\x1b[2J\x1b[H\x1b[?25h\x1b[?1049l")
+		term.Restore(fd, oldState)
+		w, h = td.w, td.h+1
+		finishBench(stdout, report, err, fs.Arg(0), *renderer, *colors, w, h, *asJSON)
+		return
+	}
+	report, err := runBench(td, out, demo, engineArgs)
+	finishBench(stdout, report, err, fs.Arg(0), *renderer, *colors, w, h, *asJSON)
+}
+
+func finishBench(stdout *os.File, r *benchReport, err error, demo, renderer, colors string, w, h int, asJSON bool) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "termdoom:", err)
+		os.Exit(1)
+	}
+	r.Demo, r.Renderer, r.Colors, r.Size = demo, renderer, colors, fmt.Sprintf("%dx%d", w, h)
+	if asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(r)
+		return
+	}
+	fmt.Fprint(stdout, r)
+}
+
+// benchDoom is a termDoom drawing w×h cells with the named renderer into
+// out, with nothing but the picture.
+func benchDoom(renderer string, mode colorMode, w, h int, out io.Writer, diff bool) *termDoom {
+	td := &termDoom{
+		renderer:   renderers[renderer],
+		colors:     mode,
+		dither:     ditherer{kind: "fs"},
+		adjust:     adjust{gamma: 1, contrast: 1},
+		cellAspect: defaultCellAspect,
+		scale:      "fit",
+		diff:       diff,
+		conv:       converter{rows: true},
+		pace:       newPacer(out, 0, false),
+		settings:   newSettingsMenu(options{renderer: renderer}, termCaps{}),
+		resized:    make(chan os.Signal, 1),
+		w:          w,
+		h:          h - 1,
+		sizeValid:  true,
+		watching:   true,
+	}
+	td.adjust.update()
+	return td
+}
+
+// quietEngine sends the engine's startup chatter, and its log, nowhere, as
+// they would only get in the way of a report, returning the real stdout.
+func quietEngine() *os.File {
+	log.SetOutput(io.Discard)
+	stdout := os.Stdout
+	if null, err := os.Open(os.DevNull); err == nil {
+		os.Stdout = null
+	}
+	return stdout
+}
+
+// runTimedemo runs the engine on engineArgs, a timedemo, until it reports
+// its timing, which it returns, or until the frontend stops it.
+func runTimedemo(f gore.DoomFrontend, demo []byte, engineArgs []string) (string, error) {
+	engineArgs, files, err := engineFiles(engineArgs)
+	if err != nil {
+		return "", err
+	}
+	fatal, restoreStderr := watchEngineErrors()
+	defer restoreStderr()
+	gore.SetVirtualFileSystem(hostFS{files: files, demo: demo})
+	done := make(chan struct{})
+	go func() {
+		gore.Run(f, engineArgs)
+		close(done)
+	}()
+	select {
+	case <-done:
+		// stopped by the frontend
+		return "", nil
+	case msg := <-fatal:
+		if ok, _ := demoFinished(msg); !ok {
+			return "", fmt.Errorf("%s", msg)
+		}
+		return msg, nil
+	}
+}
+
+// runBench plays the timedemo in engineArgs through td and measures it.
+func runBench(td *termDoom, out *countingWriter, demo []byte, engineArgs []string) (*benchReport, error) {
+	b := &benchFrontend{termDoom: td, out: out}
+	start := time.Now()
+	msg, err := runTimedemo(b, demo, engineArgs)
+	if err != nil {
+		return nil, err
+	}
+	if msg == "" {
+		return nil, fmt.Errorf("bench stopped before the demo ended")
+	}
+	elapsed := time.Since(start)
+	r := &benchReport{Seconds: elapsed.Seconds(), Frames: len(b.times)}
+	var realtics int
+	fmt.Sscanf(msg, "timed %d gametics in %d realtics", &r.GameTics, &realtics)
+	if realtics > 0 {
+		r.TicsPerSec = float64(r.GameTics) * 35 / float64(realtics)
+	}
+	if r.Frames == 0 {
+		return r, nil
+	}
+	r.FrameAvgMS, r.FrameP95MS = frameTimes(b.times)
+	n := float64(r.Frames)
+	r.BytesPer = float64(b.bytes) / n
+	r.AllocsPer = float64(b.allocs) / n
+	r.AllocBytes = float64(b.allocBytes) / n
+	r.TotalAllocs = b.allocs
+	return r, nil
+}
+
+// frameTimes are the average and 95th percentile of times, in
+// milliseconds; times ends up sorted.
+func frameTimes(times []time.Duration) (avg, p95 float64) {
+	var total time.Duration
+	for _, d := range times {
+		total += d
+	}
+	slices.Sort(times)
+	avg = float64(total.Microseconds()) / 1000 / float64(len(times))
+	p95 = float64(times[len(times)*95/100].Microseconds()) / 1000
+	return avg, p95
+}
+
+// benchFrontend measures each frame termDoom draws. Input is only looked
+// at for q or Ctrl+C, which stop the bench.
+type benchFrontend struct {
+	*termDoom
+	out           *countingWriter
+	times         []time.Duration
+	bytes         int64
+	allocs        uint64
+	allocBytes    uint64
+	before, after runtime.MemStats
+	input         inputParser
+	stopped       bool
+}
+
+func (b *benchFrontend) DrawFrame(img *image.RGBA) {
+	runtime.ReadMemStats(&b.before)
+	n := b.out.n
+	start := time.Now()
+	b.termDoom.DrawFrame(img)
+	d := time.Since(start)
+	runtime.ReadMemStats(&b.after)
+	b.times = append(b.times, d)
+	b.bytes += b.out.n - n
+	b.allocs += b.after.Mallocs - b.before.Mallocs
+	b.allocBytes += b.after.TotalAlloc - b.before.TotalAlloc
+}
+
+func (b *benchFrontend) SetTitle(string) {}
+
+func (b *benchFrontend) GetEvent(*gore.DoomEvent) bool {
+	for seq := b.input.next(b.keys, time.Now()); seq != nil && !b.stopped; seq = b.input.next(b.keys, time.Now()) {
+		if s := string(seq); s == "q" || s == "\x03" {
+			b.stopped = true
+			gore.Stop()
+		}
+	}
+	return false
+}
+
+// countingWriter counts what goes through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return c.w.Write(p)
+}
+
+// Frames kept from a demo for --bench-renderers: every nth, up to a
+// number, a few seconds' worth of play spread over half a minute.
+const (
+	compareEvery  = 5
+	compareFrames = 200
+)
+
+// frameCapture keeps a sample of the engine's frames.
+type frameCapture struct {
+	frames []*image.RGBA
+	n      int
+}
+
+func (c *frameCapture) DrawFrame(img *image.RGBA) {
+	c.n++
+	if len(c.frames) == compareFrames || (c.n-1)%compareEvery != 0 {
+		return
+	}
+	c.frames = append(c.frames, copyFrame(nil, img))
+	if len(c.frames) == compareFrames {
+		gore.Stop()
+	}
+}
+
+func (c *frameCapture) SetTitle(string)               {}
+func (c *frameCapture) GetEvent(*gore.DoomEvent) bool { return false }
+
+// benchRenderers is --bench-renderers: it runs the same frames from a demo
+// through every renderer, text ones at each color depth, at the terminal's
+// size, and prints how long each took a frame and how much it would send,
+// for picking what suits the connection.
+func benchRenderers(opts options, args []string) {
+	name := "demo1"
+	if opts.timeDemo != "" {
+		name = opts.timeDemo
+	} else if opts.playDemo != "" {
+		name = opts.playDemo
+	}
+	demo, lump, err := findDemo(opts.demoDir, name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "termdoom:", err)
+		os.Exit(1)
+	}
+	w, h, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		w, h = 160, 50
+	}
+	stdout := quietEngine()
+	c := &frameCapture{}
+	if _, err := runTimedemo(c, demo, append([]string{"-timedemo", lump}, args...)); err != nil {
+		fmt.Fprintln(os.Stderr, "termdoom:", err)
+		os.Exit(1)
+	}
+	if len(c.frames) == 0 {
+		fmt.Fprintln(os.Stderr, "termdoom: the demo drew nothing")
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(stdout, "%d frames of %s at %dx%d cells\n\n", len(c.frames), name, w, h)
+	fmt.Fprintf(stdout, "%-14s %-10s %8s %8s %12s %12s\n", "renderer", "colors", "avg ms", "p95 ms", "bytes/frame", "at 35 fps")
+	for _, r := range rendererNames {
+		depths := []string{"truecolor", "256", "16"}
+		if renderers[r].encode != nil {
+			// pictures carry their own colors
+			depths = []string{"-"}
+		}
+		for _, depth := range depths {
+			out := &countingWriter{w: io.Discard}
+			td := benchDoom(r, colorModes[depth], w, h, out, opts.diff)
+			times := make([]time.Duration, len(c.frames))
+			for i, f := range c.frames {
+				start := time.Now()
+				td.DrawFrame(f)
+				times[i] = time.Since(start)
+			}
+			if cleanup := td.renderer.cleanup; cleanup != nil {
+				cleanup(io.Discard)
+			}
+			avg, p95 := frameTimes(times)
+			per := float64(out.n) / float64(len(c.frames))
+			fmt.Fprintf(stdout, "%-14s %-10s %8.3f %8.3f %12.0f %10.0f KB/s\n", r, depth, avg, p95, per, per*35/1000)
+		}
+	}
+}
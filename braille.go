@@ -0,0 +1,84 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// brailleBits maps a dot at (x, y) within a 2×4 cell to its bit in the
+// U+2800 block.
+var brailleBits = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// brailleMonoThreshold is the luma above which a dot is lit in mono mode.
+// It sits low because DOOM's palette is mostly dark.
+const brailleMonoThreshold = 48
+
+// toBraille packs 2×4 pixels per cell. Dots brighter than the cell's mean
+// luma are lit, or darker ones on a light background, and drawn in the
+// average color of those dots, which keeps edges visible even in
+// uniformly dark areas. img must be 2g.w×4g.h.
+func toBraille(g *grid, img *image.RGBA) {
+	for y := 0; y < g.h; y++ {
+		for x := 0; x < g.w; x++ {
+			var l [4][2]int
+			sum := 0
+			for dy := 0; dy < 4; dy++ {
+				o := (y*4+dy)*img.Stride + x*2*4
+				for dx := 0; dx < 2; dx++ {
+					p := img.Pix[o+dx*4 : o+dx*4+3]
+					l[dy][dx] = luma(p[0], p[1], p[2])
+					sum += l[dy][dx]
+				}
+			}
+			mean := sum / 8
+
+			var bits rune
+			var r, gr, b, n int
+			for dy := 0; dy < 4; dy++ {
+				o := (y*4+dy)*img.Stride + x*2*4
+				for dx := 0; dx < 2; dx++ {
+					if l[dy][dx] <= mean != lightBackground {
+						continue
+					}
+					bits |= brailleBits[dy][dx]
+					p := img.Pix[o+dx*4 : o+dx*4+3]
+					r += int(p[0])
+					gr += int(p[1])
+					b += int(p[2])
+					n++
+				}
+			}
+			c := cell{ch: 0x2800 | bits}
+			if n > 0 {
+				c.fg = color.RGBA{uint8(r / n), uint8(gr / n), uint8(b / n), 255}
+			}
+			g.set(x, y, c)
+		}
+	}
+}
+
+// toBrailleMono lights every dot above a fixed brightness threshold (below
+// it on a light background) and leaves coloring to the terminal. img must
+// be 2g.w×4g.h.
+func toBrailleMono(g *grid, img *image.RGBA) {
+	for y := 0; y < g.h; y++ {
+		for x := 0; x < g.w; x++ {
+			var bits rune
+			for dy := 0; dy < 4; dy++ {
+				o := (y*4+dy)*img.Stride + x*2*4
+				for dx := 0; dx < 2; dx++ {
+					p := img.Pix[o+dx*4 : o+dx*4+3]
+					if luma(p[0], p[1], p[2]) > brailleMonoThreshold != lightBackground {
+						bits |= brailleBits[dy][dx]
+					}
+				}
+			}
+			g.set(x, y, cell{ch: 0x2800 | bits})
+		}
+	}
+}
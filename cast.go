@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// sessionRecorder writes what is drawn to the --record file.
+type sessionRecorder interface {
+	io.Writer
+	// resize records the terminal changing size
+	resize(cols, rows int)
+	close() error
+}
+
+// recordFormats are the --record-format choices, given the file and the
+// terminal size to start with. They return a nil interface on failure,
+// not a nil writer in one.
+var recordFormats = map[string]func(path string, cols, rows int) (sessionRecorder, error){
+	"cast": func(path string, cols, rows int) (sessionRecorder, error) {
+		c, err := newCastWriter(path, cols, rows)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	},
+	"ttyrec": func(path string, cols, rows int) (sessionRecorder, error) {
+		r, err := newTtyrecWriter(path)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	},
+}
+
+// castWriter records what is drawn, written through it, as an asciinema
+// v2 cast: a JSON header line, then a line per write of the seconds since
+// the start, "o" and the text, and "r" and the new size on resizes.
+type castWriter struct {
+	mu         sync.Mutex // frames may be written from the pacer's goroutine
+	f          *os.File
+	w          *bufio.Writer
+	start      time.Time
+	cols, rows int
+}
+
+func newCastWriter(path string, cols, rows int) (*castWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &castWriter{f: f, w: bufio.NewWriter(f), start: time.Now(), cols: cols, rows: rows}
+	header := map[string]any{
+		"version":   2,
+		"width":     cols,
+		"height":    rows,
+		"timestamp": c.start.Unix(),
+		"env":       map[string]string{"TERM": os.Getenv("TERM")},
+	}
+	c.line(header)
+	// the cursor is hidden before the first frame, where the cast starts
+	c.event("o", "\x1b[?25l")
+	return c, nil
+}
+
+func (c *castWriter) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.event("o", string(b))
+	return len(b), nil
+}
+
+func (c *castWriter) resize(cols, rows int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cols == c.cols && rows == c.rows {
+		return
+	}
+	c.cols, c.rows = cols, rows
+	c.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+func (c *castWriter) event(kind, data string) {
+	t := float64(time.Since(c.start).Microseconds()) / 1e6
+	c.line([]any{t, kind, data})
+}
+
+func (c *castWriter) line(v any) {
+	b, _ := json.Marshal(v)
+	c.w.Write(b)
+	c.w.WriteByte('\n')
+}
+
+func (c *castWriter) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.w.Flush(); err != nil {
+		c.f.Close()
+		return err
+	}
+	return c.f.Close()
+}
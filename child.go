@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// serverFlags are the flags that make termdoom a server for remote
+// players, which its games for them are started without.
+var serverFlags = []string{"ssh-listen", "ssh-host-key", "ssh-max-sessions", "telnet-listen", "telnet-max-sessions", "spectate-listen", "frame-listen", "metrics-listen", "map-rotation", "web-max-sessions"}
+
+// hostFlags are the flags naming files and ports of our own game, which
+// its games for remote players are started without: they would all write
+// the same files and listen on the same ports.
+var hostFlags = []string{"record", "record-input", "record-demo", "export-gif", "export-video", "dump-frames", "stats-json", "cpuprofile", "memprofile", "pprof", "input-listen"}
+
+// pathFlags are the flags and engine arguments naming files that a game
+// for a remote player reads.
+var pathFlags = []string{"file", "palette", "replay-input", "play-demo", "playdemo", "timedemo", "demo-dir"}
+
+// childEnv are the variables a game for a remote player keeps from ours;
+// the rest, TERM and the like, describe our terminal, not theirs, and
+// HOME and the like are remoteGames.home.
+var childEnv = []string{"PATH", "USER", "LOGNAME", "DOOMWADDIR"}
+
+// remoteGames is how runServers has games for remote players started: all
+// on the IWAD it found, with its config to read but, being --remote, not
+// to write, and in a home of their own, so that nothing else of ours is
+// theirs to see or change.
+var remoteGames struct {
+	iwad, config, home string
+}
+
+// maxCols and maxRows bound the terminal a remote player can say they
+// have, as the game's grids are sized from it.
+const maxCols, maxRows = 1000, 500
+
+// maxCellPx bounds the cells, in pixels, a remote player can say their
+// terminal has, as graphics renderers size their images from them.
+const maxCellPx = 64
+
+// remoteSize is a terminal size a remote player gave, within maxCols and
+// maxRows, and its size in pixels, or 0×0 if that makes cells bigger than
+// maxCellPx; not ok if either of w and h is 0, or negative.
+func remoteSize(w, h, xpx, ypx int) (cols, rows, wpx, hpx int, ok bool) {
+	cols, rows, ok = min(w, maxCols), min(h, maxRows), w > 0 && h > 0
+	if ok && xpx > 0 && ypx > 0 && xpx/cols <= maxCellPx && ypx/rows <= maxCellPx {
+		wpx, hpx = xpx, ypx
+	}
+	return cols, rows, wpx, hpx, ok
+}
+
+// gameProcess is a game for one remote player: termdoom run again on a
+// pseudo-terminal of its own, as the engine is one game to a process.
+type gameProcess struct {
+	cmd *exec.Cmd
+	pty *os.File // the master end
+}
+
+// startGame starts a game with our own arguments, less the server and
+// host flags, on a w×h terminal of type term, on the next map of any
+// rotation. env adds to its environment, as the player's LANG or
+// COLORTERM.
+func startGame(term string, w, h, xpx, ypx int, env []string) (*gameProcess, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	master, slave, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+	defer slave.Close()
+	if err := setPTYSize(master, w, h, xpx, ypx); err != nil {
+		master.Close()
+		return nil, err
+	}
+	args := gameArgs(os.Args[1:])
+	if rotation != nil {
+		args = append(args, rotation.warp()...)
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = remoteGames.home
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr = ptyAttr()
+	for _, k := range childEnv {
+		if v, ok := os.LookupEnv(k); ok {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
+	home := remoteGames.home
+	cmd.Env = append(cmd.Env, "HOME="+home, "XDG_CONFIG_HOME="+filepath.Join(home, ".config"),
+		"XDG_DATA_HOME="+filepath.Join(home, ".local", "share"), "APPDATA="+home)
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Env = append(cmd.Env, "TERM="+term)
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, err
+	}
+	return &gameProcess{cmd: cmd, pty: master}, nil
+}
+
+// resize tells the game its terminal changed size.
+func (g *gameProcess) resize(w, h, xpx, ypx int) {
+	setPTYSize(g.pty, w, h, xpx, ypx)
+}
+
+// wait waits for the game to end, returning its exit status.
+func (g *gameProcess) wait() int {
+	g.cmd.Wait()
+	g.pty.Close()
+	return g.cmd.ProcessState.ExitCode()
+}
+
+// kill ends the game, its player having gone.
+func (g *gameProcess) kill() {
+	g.cmd.Process.Kill()
+}
+
+// gameArgs are args without termdoom server, the server flags or the
+// host flags, and with them switched off in case the config file has
+// them. Files named by pathFlags are made absolute, as the game runs in
+// remoteGames.home. The game is --remote, on remoteGames' IWAD and config.
+func gameArgs(args []string) []string {
+	if len(args) > 0 && args[0] == "server" {
+		args = args[1:]
+	}
+	args = withoutEngineArgs(args, "-iwad")
+	var out []string
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		switch {
+		case !strings.HasPrefix(args[i], "-"):
+			out = append(out, args[i])
+		case slices.Contains(serverFlags, name) || slices.Contains(hostFlags, name):
+			if !hasValue && i+1 < len(args) {
+				i++ // and its value
+			}
+		case slices.Contains(pathFlags, name) && hasValue:
+			paths := strings.Split(value, ",")
+			for j := range paths {
+				paths[j] = hostPath(paths[j])
+			}
+			out = append(out, args[i][:len(args[i])-len(value)]+strings.Join(paths, ","))
+		case slices.Contains(pathFlags, name):
+			out = append(out, args[i])
+			// -file and --file take any number
+			for i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				i++
+				out = append(out, hostPath(args[i]))
+			}
+		default:
+			out = append(out, args[i])
+		}
+	}
+	out = append(out, "--ssh-listen=", "--telnet-listen=", "--spectate-listen=", "--frame-listen=", "--metrics-listen=")
+	for _, f := range hostFlags {
+		out = append(out, "--"+f+"=")
+	}
+	return append(out, "--remote", "--config="+remoteGames.config, "-iwad", remoteGames.iwad)
+}
+
+// hostPath is path made absolute if it is a file here, so that a game in
+// remoteGames.home finds it; demos named as lumps and the like are left.
+func hostPath(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		return path
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestRemoteSize(t *testing.T) {
+	tests := []struct {
+		w, h, xpx, ypx       int
+		cols, rows, wpx, hpx int
+		ok                   bool
+	}{
+		{80, 24, 0, 0, 80, 24, 0, 0, true},
+		{80, 24, 640, 384, 80, 24, 640, 384, true},
+		{100000, 100000, 0, 0, maxCols, maxRows, 0, 0, true},
+		{80, 24, 65535, 384, 80, 24, 0, 0, true},
+		{80, 24, 640, 65535, 80, 24, 0, 0, true},
+		{100000, 24, 64000, 384, maxCols, 24, 64000, 384, true},
+		{100000, 24, 65535 * 2, 384, maxCols, 24, 0, 0, true},
+		{0, 24, 0, 0, 0, 24, 0, 0, false},
+		{80, -1, 0, 0, 80, -1, 0, 0, false},
+	}
+	for _, tt := range tests {
+		cols, rows, wpx, hpx, ok := remoteSize(tt.w, tt.h, tt.xpx, tt.ypx)
+		if ok != tt.ok || ok && (cols != tt.cols || rows != tt.rows || wpx != tt.wpx || hpx != tt.hpx) {
+			t.Errorf("remoteSize(%d, %d, %d, %d) = %d, %d, %d, %d, %v, want %d, %d, %d, %d, %v",
+				tt.w, tt.h, tt.xpx, tt.ypx, cols, rows, wpx, hpx, ok, tt.cols, tt.rows, tt.wpx, tt.hpx, tt.ok)
+		}
+	}
+}
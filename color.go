@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// colorMode is the color depth we emit escapes for.
+type colorMode int
+
+const (
+	colorTrue colorMode = iota
+	color256
+	color16
+	// colorNone emits no color escapes at all.
+	colorNone
+)
+
+var colorModes = map[string]colorMode{
+	"truecolor": colorTrue,
+	"256":       color256,
+	"16":        color16,
+	"none":      colorNone,
+}
+
+// legacyTerms only understand the basic ANSI colors.
+var legacyTerms = map[string]bool{
+	"linux": true, "vt100": true, "vt102": true, "vt220": true,
+	"ansi": true, "cons25": true, "sun": true, "pcansi": true,
+}
+
+// trueColorTerms are TERM values, with any "xterm-" prefix dropped, of
+// terminals known to do 24-bit color whatever their terminfo says.
+var trueColorTerms = map[string]bool{
+	"kitty": true, "alacritty": true, "foot": true, "wezterm": true,
+	"ghostty": true, "contour": true, "iterm2": true, "konsole-direct": true,
+}
+
+// trueColorPrograms are TERM_PROGRAM values of true color terminals.
+var trueColorPrograms = map[string]bool{
+	"iTerm.app": true, "WezTerm": true, "vscode": true, "ghostty": true, "Hyper": true,
+}
+
+// detectColorMode works out the color depth the terminal supports:
+// COLORTERM first, which true color terminals set, then TERM values and
+// programs known either way, then the terminfo entry's RGB or Tc flags
+// and color count. Terminals that say nothing get 256 colors rather than
+// a stream of 24-bit escapes an old xterm would garble.
+func detectColorMode() colorMode {
+	switch os.Getenv("COLORTERM") {
+	case "truecolor", "24bit":
+		return colorTrue
+	}
+	name := os.Getenv("TERM")
+	if legacyTerms[name] {
+		return color16
+	}
+	// Windows Terminal sets no TERM, only WT_SESSION
+	if trueColorTerms[strings.TrimPrefix(name, "xterm-")] || strings.HasSuffix(name, "-direct") ||
+		trueColorPrograms[os.Getenv("TERM_PROGRAM")] || os.Getenv("WT_SESSION") != "" {
+		return colorTrue
+	}
+	if ti, err := loadTerminfo(name); err == nil {
+		switch {
+		case ti.ext["RGB"] || ti.ext["Tc"] || ti.colors >= 1<<24:
+			return colorTrue
+		case ti.colors >= 256:
+			return color256
+		case ti.colors > 0:
+			return color16
+		}
+	}
+	return color256
+}
+
+// colorKey identifies c as it will appear in mode as a foreground (base 38)
+// or background (base 48), so that runs of colors that quantize alike share
+// one escape. Default colors (A == 0) are -1.
+func colorKey(base int, c color.RGBA, mode colorMode) int {
+	if c.A == 0 {
+		return -1
+	}
+	switch mode {
+	case color256:
+		return int(xterm256(c))
+	case color16:
+		if base == 48 {
+			return int(ansi16(c, 8))
+		}
+		return int(ansi16(c, 16))
+	}
+	return int(c.R)<<16 | int(c.G)<<8 | int(c.B)
+}
+
+// writeColor emits the SGR for c as a foreground (base 38) or background
+// (base 48) color. Escapes come from prebuilt tables so the frame path
+// never formats numbers.
+func writeColor(b *bytes.Buffer, base int, c color.RGBA, mode colorMode) {
+	escOnce.Do(buildEscapes)
+	layer := 0
+	if base == 48 {
+		layer = 1
+	}
+	if c.A == 0 {
+		b.WriteString(escDefault[mode][layer])
+		return
+	}
+	switch mode {
+	case color256:
+		b.WriteString(esc256[layer][xterm256(c)])
+	case color16:
+		if layer == 1 {
+			// no bright backgrounds on the terminals that need this mode
+			b.WriteString(esc16[1][ansi16(c, 8)])
+			return
+		}
+		b.WriteString(esc16[0][ansi16(c, 16)])
+	default:
+		if layer == 1 {
+			b.WriteString("\x1b[48;2;")
+		} else {
+			b.WriteString("\x1b[38;2;")
+		}
+		b.WriteString(decimal[c.R])
+		b.WriteByte(';')
+		b.WriteString(decimal[c.G])
+		b.WriteByte(';')
+		b.WriteString(decimal[c.B])
+		b.WriteByte('m')
+	}
+}
+
+var (
+	escOnce sync.Once
+	// decimal holds the text of 0..255
+	decimal [256]string
+	// fg and bg escapes per palette entry
+	esc256 [2][256]string
+	esc16  [2][16]string
+	// escDefault resets to the default fg or bg in each mode
+	escDefault [colorNone + 1][2]string
+)
+
+func buildEscapes() {
+	for i := range decimal {
+		decimal[i] = strconv.Itoa(i)
+	}
+	for i := range 256 {
+		esc256[0][i] = "\x1b[38;5;" + decimal[i] + "m"
+		esc256[1][i] = "\x1b[48;5;" + decimal[i] + "m"
+	}
+	for i := range 8 {
+		esc16[0][i] = "\x1b[22;" + decimal[30+i] + "m"
+		// bold is how legacy terminals brighten
+		esc16[0][i+8] = "\x1b[1;" + decimal[30+i] + "m"
+		esc16[1][i] = "\x1b[" + decimal[40+i] + "m"
+	}
+	for m := range escDefault {
+		escDefault[m] = [2]string{"\x1b[39m", "\x1b[49m"}
+	}
+	// dropping bold too, since it brightened the previous color
+	escDefault[color16][0] = "\x1b[22;39m"
+}
+
+// writeInt writes n in decimal without allocating.
+func writeInt(b *bytes.Buffer, n int) {
+	if n >= 0 && n < len(decimal) {
+		escOnce.Do(buildEscapes)
+		b.WriteString(decimal[n])
+		return
+	}
+	var buf [20]byte
+	b.Write(strconv.AppendInt(buf[:0], int64(n), 10))
+}
+
+// cubeLevels are the channel values of the xterm 6×6×6 color cube.
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+var (
+	xterm256Once sync.Once
+	// xterm256LUT maps 5-bit-per-channel RGB to the nearest palette index.
+	xterm256LUT [1 << 15]uint8
+)
+
+// xterm256 returns the closest xterm palette index for c. The 16 system
+// colors are skipped since every terminal theme redefines them.
+func xterm256(c color.RGBA) uint8 {
+	xterm256Once.Do(buildXterm256)
+	return xterm256LUT[int(c.R>>3)<<10|int(c.G>>3)<<5|int(c.B>>3)]
+}
+
+func buildXterm256() {
+	for i := range xterm256LUT {
+		// sample the middle of each 5-bit bucket
+		r := (i>>10)<<3 | 4
+		g := (i>>5&31)<<3 | 4
+		b := (i&31)<<3 | 4
+		best, bestDist := 16, -1
+		for n := 16; n < 256; n++ {
+			pr, pg, pb := paletteRGB(n)
+			d := (r-pr)*(r-pr)*3 + (g-pg)*(g-pg)*6 + (b-pb)*(b-pb)
+			if bestDist < 0 || d < bestDist {
+				best, bestDist = n, d
+			}
+		}
+		xterm256LUT[i] = uint8(best)
+	}
+}
+
+// paletteRGB returns the color of xterm palette entry n for n >= 16.
+func paletteRGB(n int) (r, g, b int) {
+	if n >= 232 {
+		v := 8 + (n-232)*10
+		return v, v, v
+	}
+	n -= 16
+	return cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6]
+}
+
+// ansiColors is the classic VGA rendition of the 16 ANSI colors; 8-15 are
+// the bold/bright variants.
+var ansiColors = [16][3]int{
+	{0, 0, 0}, {170, 0, 0}, {0, 170, 0}, {170, 85, 0},
+	{0, 0, 170}, {170, 0, 170}, {0, 170, 170}, {170, 170, 170},
+	{85, 85, 85}, {255, 85, 85}, {85, 255, 85}, {255, 255, 85},
+	{85, 85, 255}, {255, 85, 255}, {85, 255, 255}, {255, 255, 255},
+}
+
+var (
+	ansiOnce sync.Once
+	// ansiLUT holds the nearest of the first 8 and of all 16 colors for
+	// 5-bit-per-channel RGB.
+	ansiLUT [2][1 << 15]uint8
+)
+
+// ansi16 returns the closest of the first n (8 or 16) ANSI colors to c.
+func ansi16(c color.RGBA, n int) uint8 {
+	ansiOnce.Do(buildANSI)
+	return ansiLUT[n/16][int(c.R>>3)<<10|int(c.G>>3)<<5|int(c.B>>3)]
+}
+
+func buildANSI() {
+	for i := range ansiLUT[0] {
+		r := (i>>10)<<3 | 4
+		g := (i>>5&31)<<3 | 4
+		b := (i&31)<<3 | 4
+		for t, n := range [2]int{8, 16} {
+			best, bestDist := 0, -1
+			for j, p := range ansiColors[:n] {
+				d := (r-p[0])*(r-p[0])*3 + (g-p[1])*(g-p[1])*6 + (b-p[2])*(b-p[2])
+				if bestDist < 0 || d < bestDist {
+					best, bestDist = j, d
+				}
+			}
+			ansiLUT[t][i] = uint8(best)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// config is a parsed config file: "key = value" lines, grouped under
+// optional "[section]" headers. Keys before the first header set flags.
+type config struct {
+	path     string
+	sections map[string][]configEntry
+}
+
+type configEntry struct {
+	key, value string
+	line       int
+}
+
+// defaultConfigPath is ~/.config/termdoom/config or the platform equivalent.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "termdoom", "config")
+}
+
+// loadConfig reads path. A missing file yields an empty config.
+func loadConfig(path string) (*config, error) {
+	cfg := &config{path: path, sections: make(map[string][]configEntry)}
+	if path == "" {
+		return cfg, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	section := ""
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		value = strings.TrimSpace(value)
+		// quotes allow leading/trailing spaces, e.g. ramp = " .oO@"
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		cfg.sections[section] = append(cfg.sections[section], configEntry{strings.TrimSpace(key), value, n})
+	}
+	return cfg, sc.Err()
+}
+
+// updateConfig sets keys of section, "" for the top-level ones, in the
+// config file at path, keeping everything else in it as it was: existing
+// keys are rewritten in place and new ones added at the end of the
+// section, which is added at the end of the file if it isn't there.
+func updateConfig(path, section string, set []configEntry) error {
+	if path == "" {
+		return errors.New("no config file")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	format := func(e configEntry) string {
+		v := e.value
+		if strings.TrimSpace(v) != v {
+			v = `"` + v + `"`
+		}
+		return e.key + " = " + v
+	}
+
+	// the section's lines are from start up to end
+	start, end := 0, len(lines)
+	if section != "" {
+		start = -1
+		for i, line := range lines {
+			if strings.TrimSpace(line) == "["+section+"]" {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+				lines = append(lines, "")
+			}
+			lines = append(lines, "["+section+"]")
+			start, end = len(lines), len(lines)
+		}
+	}
+	done := make(map[string]bool)
+	for i := start; i < end; i++ {
+		t := strings.TrimSpace(lines[i])
+		if t != "" && t[0] == '[' {
+			end = i
+			break
+		}
+		key, _, ok := strings.Cut(t, "=")
+		if !ok || t[0] == '#' || t[0] == ';' {
+			continue
+		}
+		for _, e := range set {
+			if strings.TrimSpace(key) == e.key {
+				lines[i] = format(e)
+				done[e.key] = true
+			}
+		}
+	}
+	// after the last setting, not after the blank line ending the section
+	for end > start && strings.TrimSpace(lines[end-1]) == "" && end < len(lines) {
+		end--
+	}
+	var add []string
+	for _, e := range set {
+		if !done[e.key] {
+			add = append(add, format(e))
+		}
+	}
+	lines = append(lines[:end], append(add, lines[end:]...)...)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// write a copy and move it over, so a failed write can't truncate it
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
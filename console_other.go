@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// consoleInput is for the Windows console; other terminals are read as
+// bytes.
+func consoleInput(f *os.File, mouse bool) (keys <-chan byte, ok bool) {
+	return nil, false
+}
+
+// enableVT has nothing to do outside Windows, where terminals take escape
+// sequences as they are.
+func enableVT(f *os.File) (restore func(), err error) {
+	return func() {}, nil
+}
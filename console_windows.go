@@ -0,0 +1,273 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procReadConsoleInput = windows.NewLazySystemDLL("kernel32.dll").NewProc("ReadConsoleInputW")
+
+// inputRecord is the console's INPUT_RECORD: an event type and, after
+// padding, the event.
+type inputRecord struct {
+	eventType uint16
+	_         uint16
+	event     [16]byte
+}
+
+// keyEventRecord is KEY_EVENT_RECORD.
+type keyEventRecord struct {
+	keyDown         int32
+	repeatCount     uint16
+	virtualKeyCode  uint16
+	virtualScanCode uint16
+	unicodeChar     uint16
+	controlKeyState uint32
+}
+
+// mouseEventRecord is MOUSE_EVENT_RECORD.
+type mouseEventRecord struct {
+	mousePosition   windows.Coord
+	buttonState     uint32
+	controlKeyState uint32
+	eventFlags      uint32
+}
+
+// consoleInput reads f through the Console API rather than as bytes when
+// it is a Windows console, for the key releases VT input mode doesn't
+// give. Key events come out as kitty keyboard protocol reports, mouse
+// events as SGR reports and focus changes as focusIn and focusOut, so the
+// rest decodes them as it does from terminals that send those. Characters
+// put in the input rather than typed, as the console's replies to our
+// queries are, pass through as they are. Mouse events are only asked for
+// with mouse, since they take the mouse away from selecting text.
+//
+// ok is false when f is no console, as under mintty or ssh, where it is
+// read like a terminal anywhere else. The console mode is left for
+// term.Restore to put back.
+func consoleInput(f *os.File, mouse bool) (keys <-chan byte, ok bool) {
+	h := windows.Handle(f.Fd())
+	var mode uint32
+	if windows.GetConsoleMode(h, &mode) != nil {
+		return nil, false
+	}
+	// no line editing, echo, Ctrl+C handling, VT input or quick edit
+	mode = windows.ENABLE_WINDOW_INPUT | windows.ENABLE_EXTENDED_FLAGS
+	if mouse {
+		mode |= windows.ENABLE_MOUSE_INPUT
+	}
+	if windows.SetConsoleMode(h, mode) != nil {
+		return nil, false
+	}
+	ch := make(chan byte, 128)
+	c := &consoleReader{out: ch, down: make(map[uint16]string)}
+	go func() {
+		defer close(ch)
+		var recs [32]inputRecord
+		for {
+			var n uint32
+			r, _, _ := procReadConsoleInput.Call(uintptr(h), uintptr(unsafe.Pointer(&recs[0])), uintptr(len(recs)), uintptr(unsafe.Pointer(&n)))
+			if r == 0 {
+				return
+			}
+			for i := range recs[:n] {
+				c.record(&recs[i])
+			}
+		}
+	}()
+	return ch, true
+}
+
+// consoleReader turns console input records into bytes.
+type consoleReader struct {
+	out chan<- byte
+	// down is the report, less its modifiers and event type, of each key
+	// down, by virtual key, so it is let go as what it was pressed as
+	down    map[uint16]string
+	buttons uint32
+}
+
+func (c *consoleReader) send(s string) {
+	for i := 0; i < len(s); i++ {
+		c.out <- s[i]
+	}
+}
+
+func (c *consoleReader) record(r *inputRecord) {
+	switch r.eventType {
+	case windows.KEY_EVENT:
+		c.key((*keyEventRecord)(unsafe.Pointer(&r.event)))
+	case windows.MOUSE_EVENT:
+		c.mouse((*mouseEventRecord)(unsafe.Pointer(&r.event)))
+	case windows.FOCUS_EVENT:
+		if *(*int32)(unsafe.Pointer(&r.event)) != 0 {
+			c.send(focusIn)
+		} else {
+			c.send(focusOut)
+		}
+	}
+}
+
+func (c *consoleReader) key(k *keyEventRecord) {
+	if k.virtualKeyCode == 0 && k.virtualScanCode == 0 {
+		// not typed: pass the character on
+		if k.keyDown != 0 && k.unicodeChar != 0 {
+			c.send(string(utf16.Decode([]uint16{k.unicodeChar})))
+		}
+		return
+	}
+	report, held := c.down[k.virtualKeyCode]
+	event := keyRelease
+	switch {
+	case k.keyDown == 0 && !held:
+		return
+	case k.keyDown == 0:
+		delete(c.down, k.virtualKeyCode)
+	case held:
+		event = keyRepeat
+	default:
+		if report = consoleKey(k); report == "" {
+			return
+		}
+		c.down[k.virtualKeyCode] = report
+		event = keyPress
+	}
+	code, final := report[:len(report)-1], report[len(report)-1]
+	c.send(fmt.Sprintf("\x1b[%s;%d:%d%c", code, consoleMods(k.controlKeyState)+1, event, final))
+}
+
+// consoleFunctionKeys are the numbers of F5 to F12 in their ~ reports.
+var consoleFunctionKeys = [...]string{"15", "17", "18", "19", "20", "21", "23", "24"}
+
+// consoleKey is the kitty report, its number and final byte, for the key
+// in k, or "" for one we have no use for.
+func consoleKey(k *keyEventRecord) string {
+	vk := k.virtualKeyCode
+	enhanced := k.controlKeyState&windows.ENHANCED_KEY != 0
+	switch {
+	case vk == windows.VK_UP:
+		return "1A"
+	case vk == windows.VK_DOWN:
+		return "1B"
+	case vk == windows.VK_RIGHT:
+		return "1C"
+	case vk == windows.VK_LEFT:
+		return "1D"
+	case vk == windows.VK_HOME:
+		return "1H"
+	case vk == windows.VK_END:
+		return "1F"
+	case vk == windows.VK_INSERT:
+		return "2~"
+	case vk == windows.VK_DELETE:
+		return "3~"
+	case vk == windows.VK_PRIOR:
+		return "5~"
+	case vk == windows.VK_NEXT:
+		return "6~"
+	case vk >= windows.VK_F1 && vk <= windows.VK_F4:
+		return "1" + string(rune('P'+vk-windows.VK_F1))
+	case vk >= windows.VK_F5 && vk <= windows.VK_F12:
+		return consoleFunctionKeys[vk-windows.VK_F5] + "~"
+	case vk == windows.VK_RETURN:
+		return "13u"
+	case vk == windows.VK_TAB:
+		return "9u"
+	case vk == windows.VK_ESCAPE:
+		return "27u"
+	case vk == windows.VK_BACK:
+		return "127u"
+	case vk == windows.VK_SHIFT && k.virtualScanCode == 0x36:
+		return "57447u"
+	case vk == windows.VK_SHIFT:
+		return "57441u"
+	case vk == windows.VK_CONTROL && enhanced:
+		return "57448u"
+	case vk == windows.VK_CONTROL:
+		return "57442u"
+	case vk == windows.VK_MENU && enhanced:
+		return "57449u"
+	case vk == windows.VK_MENU:
+		return "57443u"
+	case vk == windows.VK_CAPITAL:
+		return "57358u"
+	case vk >= 'A' && vk <= 'Z':
+		// the key, not the character Shift or Ctrl make of it
+		return fmt.Sprintf("%du", vk+'a'-'A')
+	case vk >= '0' && vk <= '9':
+		return fmt.Sprintf("%du", vk)
+	case k.unicodeChar >= 0x20:
+		return fmt.Sprintf("%du", k.unicodeChar)
+	}
+	return ""
+}
+
+func consoleMods(state uint32) int {
+	mods := 0
+	if state&windows.SHIFT_PRESSED != 0 {
+		mods |= modShift
+	}
+	if state&(windows.LEFT_ALT_PRESSED|windows.RIGHT_ALT_PRESSED) != 0 {
+		mods |= modAlt
+	}
+	if state&(windows.LEFT_CTRL_PRESSED|windows.RIGHT_CTRL_PRESSED) != 0 {
+		mods |= modCtrl
+	}
+	return mods
+}
+
+// consoleButtons are the console's bits for the SGR report's buttons:
+// left, middle and right.
+var consoleButtons = [mouseButtons]uint32{
+	windows.FROM_LEFT_1ST_BUTTON_PRESSED,
+	windows.FROM_LEFT_2ND_BUTTON_PRESSED,
+	windows.RIGHTMOST_BUTTON_PRESSED,
+}
+
+func (c *consoleReader) mouse(m *mouseEventRecord) {
+	// cells are numbered from 1 in reports, 0 here
+	x, y := int(m.mousePosition.X)+1, int(m.mousePosition.Y)+1
+	switch {
+	case m.eventFlags&windows.MOUSE_WHEELED != 0:
+		button := 64
+		if int32(m.buttonState) < 0 {
+			button = 65
+		}
+		c.send(fmt.Sprintf("\x1b[<%d;%d;%dM", button, x, y))
+		return
+	case m.eventFlags&windows.MOUSE_MOVED != 0:
+		c.send(fmt.Sprintf("\x1b[<35;%d;%dM", x, y))
+	}
+	for b, bit := range consoleButtons {
+		switch was, is := c.buttons&bit != 0, m.buttonState&bit != 0; {
+		case is && !was:
+			c.send(fmt.Sprintf("\x1b[<%d;%d;%dM", b, x, y))
+		case was && !is:
+			c.send(fmt.Sprintf("\x1b[<%d;%d;%dm", b, x, y))
+		}
+	}
+	c.buttons = m.buttonState
+}
+
+// enableVT turns on escape sequence processing for f, returning what puts
+// the mode back. f not being a console is fine, since whatever it is reads
+// the sequences itself; a console from before Windows 10 can't do them,
+// and is an error.
+func enableVT(f *os.File) (restore func(), err error) {
+	h := windows.Handle(f.Fd())
+	var mode uint32
+	if windows.GetConsoleMode(h, &mode) != nil {
+		return func() {}, nil
+	}
+	vt := mode | windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING | windows.DISABLE_NEWLINE_AUTO_RETURN
+	if err := windows.SetConsoleMode(h, vt); err != nil {
+		return nil, err
+	}
+	return func() { windows.SetConsoleMode(h, mode) }, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// demoLump is what a demo file being played is called in the IWAD, where
+// hostFS adds it: the engine can't load a lone .lmp file itself.
+const demoLump = "TERMDEMO"
+
+// demoArgs are the engine arguments for --record-demo, --play-demo and
+// --timedemo, and the demo file to play, if it is one. Names without a
+// directory are kept in --demo-dir; the engine adds .lmp itself when
+// recording, so a name given with it is trimmed.
+func demoArgs(opts options) (args []string, demo []byte, err error) {
+	switch {
+	case opts.recordDemo != "":
+		name := strings.TrimSuffix(opts.recordDemo, ".lmp")
+		if !strings.ContainsAny(name, `/\`) {
+			name = filepath.Join(opts.demoDir, name)
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return nil, nil, err
+		}
+		return []string{"-record", name}, nil, nil
+	case opts.playDemo != "":
+		demo, name, err := findDemo(opts.demoDir, opts.playDemo)
+		return []string{"-playdemo", name}, demo, err
+	case opts.timeDemo != "":
+		demo, name, err := findDemo(opts.demoDir, opts.timeDemo)
+		return []string{"-timedemo", name}, demo, err
+	}
+	return nil, nil, nil
+}
+
+// findDemo reads the demo called name: the file itself, with or without
+// .lmp, or one in dir, to be played as demoLump. Anything else is left for
+// the engine to look for among the WAD's lumps, as demo1 is.
+func findDemo(dir, name string) (demo []byte, lump string, err error) {
+	for _, p := range []string{name, filepath.Join(dir, name)} {
+		for _, f := range []string{p, p + ".lmp"} {
+			if fi, err := os.Stat(f); err == nil && !fi.IsDir() {
+				demo, err := os.ReadFile(f)
+				return demo, demoLump, err
+			}
+		}
+	}
+	return nil, name, nil
+}
+
+// demoFinished is whether msg, an engine error, is really the end of
+// recording or timing a demo, which the engine reports the same way, and
+// the file for a recording.
+func demoFinished(msg string) (ok bool, recorded string) {
+	if name, ok := strings.CutPrefix(msg, "Demo "); ok && strings.HasSuffix(name, " recorded") {
+		return true, strings.TrimSuffix(name, " recorded")
+	}
+	return strings.HasPrefix(msg, "timed "), ""
+}
+
+// repairDemo fixes up a demo the engine has just recorded. Its header is
+// missing which of the four players are in the game, written over each
+// other in one place; the game is single player, so it is player one.
+func repairDemo(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(data) < 9 {
+		return fmt.Errorf("%s: too short for a demo", path)
+	}
+	fixed := append(append(data[:9:9], 1, 0, 0, 0), data[9:]...)
+	return os.WriteFile(path, fixed, 0o644)
+}
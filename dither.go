@@ -0,0 +1,114 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// ditherer reduces banding when frames are quantized to a small palette.
+// It works on the scaled frame in place, snapping every pixel to the
+// palette color the output will use.
+type ditherer struct {
+	kind string // "none", "fs" (Floyd–Steinberg) or "bayer"
+	// error rows for error diffusion, three channels per pixel with a
+	// pixel of padding on either side
+	cur, next []int
+}
+
+var ditherKinds = map[string]bool{"none": true, "fs": true, "bayer": true}
+
+// apply dithers img for mode. True color and colorless output need no
+// dithering and are left untouched.
+func (d *ditherer) apply(img *image.RGBA, mode colorMode) {
+	if mode != color256 && mode != color16 {
+		return
+	}
+	switch d.kind {
+	case "fs":
+		d.floydSteinberg(img, mode)
+	case "bayer":
+		bayer(img, mode)
+	}
+}
+
+// bayer4 is the 4×4 ordered dither threshold matrix.
+var bayer4 = [4][4]int{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// bayer applies ordered dithering. Unlike error diffusion the pattern is
+// fixed to screen position, so it doesn't crawl between frames.
+func bayer(img *image.RGBA, mode colorMode) {
+	// roughly the distance between neighbouring palette levels
+	spread := 40
+	if mode == color16 {
+		spread = 96
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			o := x * 4
+			off := (bayer4[y&3][x&3]*2 - 15) * spread / 32
+			q := quantizeRGB(color.RGBA{
+				clamp8(int(row[o]) + off),
+				clamp8(int(row[o+1]) + off),
+				clamp8(int(row[o+2]) + off),
+				255,
+			}, mode)
+			row[o], row[o+1], row[o+2] = q.R, q.G, q.B
+		}
+	}
+}
+
+func (d *ditherer) floydSteinberg(img *image.RGBA, mode colorMode) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	n := (w + 2) * 3
+	if cap(d.cur) < n {
+		d.cur, d.next = make([]int, n), make([]int, n)
+	}
+	cur, next := d.cur[:n], d.next[:n]
+	clear(cur)
+	for y := 0; y < h; y++ {
+		clear(next)
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < w; x++ {
+			o := x * 4
+			e := (x + 1) * 3
+			want := [3]int{
+				int(row[o]) + cur[e]/16,
+				int(row[o+1]) + cur[e+1]/16,
+				int(row[o+2]) + cur[e+2]/16,
+			}
+			q := quantizeRGB(color.RGBA{clamp8(want[0]), clamp8(want[1]), clamp8(want[2]), 255}, mode)
+			row[o], row[o+1], row[o+2] = q.R, q.G, q.B
+			got := [3]int{int(q.R), int(q.G), int(q.B)}
+			for c := 0; c < 3; c++ {
+				err := want[c] - got[c]
+				cur[e+3+c] += err * 7
+				next[e-3+c] += err * 3
+				next[e+c] += err * 5
+				next[e+3+c] += err
+			}
+		}
+		cur, next = next, cur
+	}
+}
+
+// quantizeRGB returns the palette color c is shown as in mode.
+func quantizeRGB(c color.RGBA, mode colorMode) color.RGBA {
+	var r, g, b int
+	switch mode {
+	case color256:
+		r, g, b = paletteRGB(int(xterm256(c)))
+	case color16:
+		p := ansiColors[ansi16(c, 16)]
+		r, g, b = p[0], p[1], p[2]
+	default:
+		return c
+	}
+	return color.RGBA{uint8(r), uint8(g), uint8(b), 255}
+}
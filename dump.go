@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+)
+
+// frameDumper writes every nth of the engine's frames into a directory as
+// numbered PNGs, for --dump-frames.
+type frameDumper struct {
+	dir   string
+	every int
+	n     int // frames seen
+}
+
+func newFrameDumper(dir string, every int) (*frameDumper, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &frameDumper{dir: dir, every: every}, nil
+}
+
+// frame takes the engine's next frame, writing it if it is one of the nth.
+func (d *frameDumper) frame(img *image.RGBA) error {
+	d.n++
+	if (d.n-1)%d.every != 0 {
+		return nil
+	}
+	return writePNG(filepath.Join(d.dir, fmt.Sprintf("frame-%06d.png", d.n)), img)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// edgeThreshold is the Sobel gradient magnitude (|gx|+|gy| over luma) above
+// which a pixel is drawn as a line instead of a ramp character.
+const edgeThreshold = 160
+
+// toEdges works like toASCII but draws strong edges with a directional
+// glyph, libcaca style, which gives walls and doorframes crisp outlines.
+func toEdges(g *grid, img *image.RGBA) {
+	toASCII(g, img)
+	if runeWidth(ramp[0]) != 1 {
+		return
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	l := func(x, y int) int {
+		x = min(max(x, 0), w-1)
+		y = min(max(y, 0), h-1)
+		o := y*img.Stride + x*4
+		return luma(img.Pix[o], img.Pix[o+1], img.Pix[o+2])
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx := l(x+1, y-1) + 2*l(x+1, y) + l(x+1, y+1) - l(x-1, y-1) - 2*l(x-1, y) - l(x-1, y+1)
+			gy := l(x-1, y+1) + 2*l(x, y+1) + l(x+1, y+1) - l(x-1, y-1) - 2*l(x, y-1) - l(x+1, y-1)
+			ax, ay := abs(gx), abs(gy)
+			if ax+ay < edgeThreshold {
+				continue
+			}
+			var ch rune
+			switch {
+			case ax > 2*ay:
+				ch = '|'
+			case ay > 2*ax && gy < 0:
+				// brighter above: the edge sits at the bottom of this cell
+				ch = '_'
+			case ay > 2*ax:
+				ch = '-'
+			case (gx > 0) == (gy > 0):
+				ch = '/'
+			default:
+				ch = '\\'
+			}
+			o := y*img.Stride + x*4
+			g.set(x, y, cell{ch: ch, fg: color.RGBA{img.Pix[o], img.Pix[o+1], img.Pix[o+2], 255}})
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
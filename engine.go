@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// watchEngineErrors passes the engine's standard error through a pipe to
+// catch its fatal errors. The engine reports them, with a stack trace, and
+// then spins forever rather than exiting, which would leave the terminal
+// in raw mode; the message comes out on the channel instead, for the game
+// to be shut down around it. restore puts standard error back.
+func watchEngineErrors() (fatal <-chan string, restore func()) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, func() {}
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	ch := make(chan string, 1)
+	go func() {
+		var recent []byte // what came before, for the message
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+			b := buf[:n]
+			// the stack trace comes in one write after the message
+			if i := bytes.Index(b, []byte("goroutine ")); i >= 0 && bytes.Contains(b[i:], []byte(".i_Error(")) {
+				recent = append(recent, b[:i]...)
+				msg := strings.TrimSpace(string(recent))
+				if j := strings.LastIndex(msg, "\n\n"); j >= 0 {
+					msg = strings.TrimSpace(msg[j:])
+				}
+				ch <- msg
+				return
+			}
+			stderr.Write(b)
+			recent = append(recent, b...)
+			if len(recent) > 4096 {
+				recent = recent[len(recent)-4096:]
+			}
+		}
+	}()
+	return ch, func() {
+		os.Stderr = stderr
+		w.Close()
+	}
+}
+
+// hostFS opens files as the OS does. The engine's own file system only
+// takes paths inside the working directory, which rules out absolute ones.
+// The IWAD is given with the lumps of files, as engineFiles takes them,
+// and a demo added, as addLumps does.
+type hostFS struct {
+	files []string
+	demo  []byte
+}
+
+func (h hostFS) Open(name string) (fs.File, error) {
+	f, err := os.Open(name)
+	if err != nil || (h.files == nil && h.demo == nil) || !strings.EqualFold(filepath.Ext(name), ".wad") {
+		return f, err
+	}
+	var lumps []wadLump
+	for _, file := range h.files {
+		l, err := readLumpFile(file)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		lumps = append(lumps, l...)
+	}
+	if h.demo != nil {
+		lumps = append(lumps, wadLump{demoLump, h.demo})
+	}
+	w, err := addLumps(f, lumps)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+)
+
+// filters are optional effects selected with --filter, applied in the
+// order given to the picture at output resolution, just before it is
+// turned into cells (or to the frame for graphics renderers).
+var filters = map[string]func(img *image.RGBA){
+	"crt":   crt,
+	"green": phosphor(0.25, 1, 0.35),
+	"amber": phosphor(1, 0.7, 0.1),
+
+	"protanopia": daltonize(mat3{
+		{0.152286, 1.052583, -0.204868},
+		{0.114503, 0.786281, 0.099216},
+		{-0.003882, -0.048116, 1.051998},
+	}, redGreenShift),
+	"deuteranopia": daltonize(mat3{
+		{0.367322, 0.860646, -0.227968},
+		{0.280085, 0.672501, 0.047413},
+		{-0.011820, 0.042940, 0.968881},
+	}, redGreenShift),
+	"tritanopia": daltonize(mat3{
+		{1.255528, -0.076749, -0.178779},
+		{-0.078411, 0.930809, 0.147602},
+		{0.004733, 0.691367, 0.303900},
+	}, blueYellowShift),
+}
+
+// parseFilters turns a comma-separated --filter value into its filters.
+func parseFilters(s string) ([]func(*image.RGBA), error) {
+	var fs []func(*image.RGBA)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		f, ok := filters[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter %q (have %s)", name, filterList())
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+func filterList() string {
+	names := make([]string, 0, len(filters))
+	for n := range filters {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// crt imitates a tube: each pixel bleeds a little into the one to its
+// right and every other line is darkened like the gaps between scanlines.
+func crt(img *image.RGBA) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+		// right to left so each pixel mixes with its original neighbour
+		for o := len(row) - 4; o >= 4; o -= 4 {
+			row[o] = uint8((int(row[o])*3 + int(row[o-4])) / 4)
+			row[o+1] = uint8((int(row[o+1])*3 + int(row[o-3])) / 4)
+			row[o+2] = uint8((int(row[o+2])*3 + int(row[o-2])) / 4)
+		}
+		if y%2 == 1 {
+			for o := 0; o < len(row); o += 4 {
+				row[o] = uint8(int(row[o]) * 3 / 5)
+				row[o+1] = uint8(int(row[o+1]) * 3 / 5)
+				row[o+2] = uint8(int(row[o+2]) * 3 / 5)
+			}
+		}
+	}
+}
+
+// phosphor returns a filter showing brightness alone in one tint, like a
+// monochrome monitor.
+func phosphor(r, g, b float64) func(*image.RGBA) {
+	var lut [3][256]uint8
+	for i := range 256 {
+		lut[0][i] = uint8(float64(i) * r)
+		lut[1][i] = uint8(float64(i) * g)
+		lut[2][i] = uint8(float64(i) * b)
+	}
+	return func(img *image.RGBA) {
+		w, h := img.Rect.Dx(), img.Rect.Dy()
+		for y := 0; y < h; y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+			for o := 0; o < len(row); o += 4 {
+				l := luma(row[o], row[o+1], row[o+2])
+				row[o], row[o+1], row[o+2] = lut[0][l], lut[1][l], lut[2][l]
+			}
+		}
+	}
+}
+
+type mat3 [3][3]float64
+
+var (
+	// where the color difference a viewer can't see is moved to: red-green
+	// loss into green and blue, blue-yellow loss into red and green
+	redGreenShift   = mat3{{0, 0, 0}, {0.7, 1, 0}, {0.7, 0, 1}}
+	blueYellowShift = mat3{{1, 0, 0.7}, {0, 1, 0.7}, {0, 0, 0}}
+)
+
+// daltonize returns a filter for a kind of color blindness, given the
+// matrix simulating it (Machado et al., 2009). The part of each color lost
+// to the viewer is added back through shift into channels they can tell
+// apart, so reds and greens such as keycards and health stay distinct.
+// The whole correction is one fixed-point matrix per pixel.
+func daltonize(sim, shift mat3) func(*image.RGBA) {
+	// m = I + shift·(I − sim)
+	var m [3][3]int
+	for i := range 3 {
+		for j := range 3 {
+			v := 0.0
+			if i == j {
+				v = 1
+			}
+			for k := range 3 {
+				d := -sim[k][j]
+				if k == j {
+					d++
+				}
+				v += shift[i][k] * d
+			}
+			m[i][j] = int(v * 1024)
+		}
+	}
+	return func(img *image.RGBA) {
+		w, h := img.Rect.Dx(), img.Rect.Dy()
+		for y := 0; y < h; y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+			for o := 0; o < len(row); o += 4 {
+				r, g, b := int(row[o]), int(row[o+1]), int(row[o+2])
+				row[o] = clamp8((m[0][0]*r + m[0][1]*g + m[0][2]*b) >> 10)
+				row[o+1] = clamp8((m[1][0]*r + m[1][1]*g + m[1][2]*b) >> 10)
+				row[o+2] = clamp8((m[2][0]*r + m[2][1]*g + m[2][2]*b) >> 10)
+			}
+		}
+	}
+}
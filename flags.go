@@ -0,0 +1,437 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"strings"
+	"time"
+)
+
+// options holds the frontend's own command line settings.
+type options struct {
+	renderer string
+	colors   string
+	mono     bool
+	invert   bool
+	dither   string
+	filter   string
+	palette  string
+	ramp     string
+	config   string
+
+	gamma, brightness, contrast float64
+	cellAspect                  float64
+	diff                        bool
+	sync                        bool
+	scaler                      string
+	scale                       string
+	workers                     int
+	fps                         int
+	vsync                       bool
+	adaptive                    bool
+	colorTolerance              int
+	reduceFlashing              bool
+	textHUD                     bool
+	textScreens                 bool
+	automap                     string
+	minimap                     string
+	minimapCorner               string
+	background                  string
+	binds                       bindings
+	kittyKeys                   bool
+	otherKeys                   bool
+	keyupDelay                  time.Duration
+	gamepad                     string
+	gamepadDeadzone             float64
+	mouse                       bool
+	mouseSensitivity            float64
+	focusPause                  bool
+	alwaysRun                   bool
+	recordInput, replayInput    string
+	inputListen                 string
+	keys                        string
+	bell                        bool
+	record, recordFormat        string
+	exportGIF, exportVideo      string
+	videoFPS                    int
+	screenshotDir               string
+	dumpFrames                  string
+	dumpEvery                   int
+	gifFPS                      int
+	gifDuration                 time.Duration
+	recordDemo, playDemo        string
+	timeDemo, demoDir           string
+	benchRenderers              bool
+	listen                      string
+	sshListen, sshHostKey       string
+	sshMaxSessions              int
+	telnetListen                string
+	telnetMaxSessions           int
+	webMaxSessions              int
+	mapRotation                 string
+	spectateListen              string
+	frameListen                 string
+	metricsListen               string
+	pprof                       string
+	cpuProfile                  string
+	memProfile                  string
+	statsJSON                   string
+	files                       pathList
+	mod                         string
+	launcher                    bool
+	remote                      bool
+
+	// remap is the loaded --palette
+	remap func(*image.RGBA)
+	// replay is the loaded --replay-input
+	replay *inputReplay
+	// keymap is the default keys with the config's and --bind's on top
+	keymap keymap
+	// plain means no escape sequences at all, for TERM=dumb
+	plain  bool
+	notice string
+}
+
+// parseFlags reads our double-dash flags out of args and returns the rest,
+// which are the engine's own single-dash arguments (-iwad, -warp, ...).
+func parseFlags(args []string) (options, []string) {
+	var opts options
+	fs := flag.NewFlagSet("termdoom", flag.ExitOnError)
+	fs.StringVar(&opts.renderer, "renderer", "auto", "frame renderer: auto, "+rendererList())
+	fs.StringVar(&opts.colors, "colors", "auto", "color depth: auto, truecolor, 256, 16, none")
+	fs.IntVar(&opts.colorTolerance, "color-tolerance", 0, "true color only: keep the current color for cells within this distance (0-255) of it, sending far fewer escapes")
+	fs.StringVar(&opts.dither, "dither", "fs", "dithering for 256 and 16 color output: fs (Floyd–Steinberg), bayer (ordered), none")
+	fs.StringVar(&opts.filter, "filter", "", "comma-separated effects: "+filterList())
+	fs.StringVar(&opts.palette, "palette", "", "remap colors through a palette file (.pal, .gpl, or PNG; a 256×1 PNG maps brightness to color)")
+	fs.BoolVar(&opts.reduceFlashing, "reduce-flashing", false, "limit how fast overall brightness and tint can change, softening weapon flashes and damage/pickup screen flashes")
+	fs.BoolVar(&opts.textHUD, "text-hud", false, "show health, armor, ammo and keys as a line of text instead of the status bar picture")
+	fs.BoolVar(&opts.textScreens, "text-screens", false, "show menus, level stats and story text as readable text instead of shrunken pictures")
+	fs.StringVar(&opts.automap, "automap", "lines", "automap drawing for text renderers: lines (traced with line-drawing characters) or picture (like the rest of the game)")
+	fs.StringVar(&opts.minimap, "minimap", "", "size in cells, e.g. 24x10, of a corner minimap toggled with F10; it shows the automap as last opened (text renderers only)")
+	fs.StringVar(&opts.minimapCorner, "minimap-corner", "top-right", "minimap position: top-left, top-right, bottom-left, bottom-right")
+	fs.BoolVar(&opts.mono, "mono", false, "no color at all, just the ASCII ramp (same as --colors=none)")
+	fs.StringVar(&opts.background, "background", "auto", "terminal background: auto (ask the terminal), dark, light (dark-on-light glyphs, as --invert), or black (switch the terminal to black until exit)")
+	fs.BoolVar(&opts.invert, "invert", false, "reverse the brightness ramp for light backgrounds")
+	fs.StringVar(&opts.ramp, "ramp", string(ramp), "ASCII renderer characters from dark to bright, e.g. \" ░▒▓█\"")
+	fs.Float64Var(&opts.gamma, "gamma", 1, "gamma correction; above 1 lifts dark areas (keys Alt+( and Alt+))")
+	fs.Float64Var(&opts.brightness, "brightness", 0, "brightness offset from -1 to 1 (keys Alt+[ and Alt+])")
+	fs.Float64Var(&opts.contrast, "contrast", 1, "contrast multiplier (keys Alt+{ and Alt+}, Alt+\\ resets all three)")
+	fs.Float64Var(&opts.cellAspect, "cell-aspect", 0, "terminal cell width divided by height; 0 asks the terminal, falling back to 0.5")
+	fs.StringVar(&opts.scale, "scale", "fit", "picture sizing: fit (4:3 with bars), fill (4:3, cropped to cover), stretch (whole terminal), integer (fit at a whole multiple or fraction of 320 pixels)")
+	fs.StringVar(&opts.scaler, "scaler", "nearest", "frame scaling: nearest (fast, crisp) or box (averaged, smoother when shrinking)")
+	fs.IntVar(&opts.workers, "workers", 0, "goroutines converting each frame; 0 uses GOMAXPROCS")
+	fs.IntVar(&opts.fps, "fps", 35, "frame rate cap, 0 for none; the engine runs at 35 tics per second")
+	fs.BoolVar(&opts.vsync, "vsync", false, "write frames in the background and drop new ones while the terminal is still busy")
+	fs.BoolVar(&opts.adaptive, "adaptive", true, "lower resolution and color detail while the terminal can't keep up")
+	fs.StringVar(&opts.keys, "keys", "wasd", "letters to move with, besides the arrows: "+strings.Join(keyPresetNames, ", ")+"; --bind and the config's [keys] go on top")
+	fs.Var(&opts.binds, "bind", "bind a key to a game action, e.g. --bind q=strafeleft or --bind f5=quicksave; repeatable, and the config's [keys] section takes the same key = action lines")
+	fs.BoolVar(&opts.kittyKeys, "kitty-keys", true, "use the kitty keyboard protocol where the terminal has it, for real key releases instead of guessing")
+	fs.BoolVar(&opts.otherKeys, "modify-other-keys", true, "without the kitty protocol, use xterm's modifyOtherKeys where the terminal has it, so Shift and Ctrl can be bound")
+	fs.DurationVar(&opts.keyupDelay, "keyup-delay", 60*time.Millisecond, "how long a key counts as held after the terminal last sent it; set it above your key repeat interval so held keys don't stutter (unused with the kitty protocol)")
+	fs.BoolVar(&opts.alwaysRun, "always-run", false, "run without holding the run key; the settings menu (F8) and Caps Lock, where the terminal reports it, switch it")
+	fs.BoolVar(&opts.mouse, "mouse", true, "turn with the mouse and fire and use with its buttons, where the terminal reports the mouse")
+	fs.Float64Var(&opts.mouseSensitivity, "mouse-sensitivity", 1, "how far moving the mouse turns")
+	fs.BoolVar(&opts.focusPause, "focus-pause", true, "pause while the terminal window is in the background, where the terminal reports focus")
+	fs.StringVar(&opts.gamepad, "gamepad", "", "play with a gamepad (Linux): auto for the first one found, or its /dev/input/event* device; needs read access, usually the input group")
+	fs.Float64Var(&opts.gamepadDeadzone, "gamepad-deadzone", 0.25, "how far, from 0 to 1, sticks must tilt before they count")
+	fs.StringVar(&opts.recordInput, "record-input", "", "write the game's key and mouse events, with their times, to this file")
+	fs.StringVar(&opts.replayInput, "replay-input", "", "play key and mouse events back from a file written by --record-input, or by hand")
+	fs.StringVar(&opts.inputListen, "input-listen", "", "also take keys over TCP at this address, e.g. localhost:6666: lines of down, up or press and a key as --bind spells actions, for bots and other programs")
+	fs.StringVar(&opts.record, "record", "", "write everything drawn, with its timing, to this file, for asciinema play or upload, or ttyplay")
+	fs.StringVar(&opts.recordFormat, "record-format", "cast", "--record file format: cast (asciinema v2) or ttyrec")
+	fs.StringVar(&opts.exportGIF, "export-gif", "", "also save the game's frames, before they become text, as an animated GIF in this file")
+	fs.IntVar(&opts.gifFPS, "gif-fps", 15, "frames a second in the --export-gif GIF, 1 to 35")
+	fs.DurationVar(&opts.gifDuration, "gif-duration", 30*time.Second, "how much of the game --export-gif keeps, from the start")
+	fs.StringVar(&opts.exportVideo, "export-video", "", "also save the game's frames as a video in this file, e.g. out.mp4, through ffmpeg, which must be installed")
+	fs.IntVar(&opts.videoFPS, "video-fps", 35, "frames a second in the --export-video video")
+	fs.StringVar(&opts.screenshotDir, "screenshot-dir", "screenshots", "where F12 saves screenshots: a PNG of the game's picture and, for text renderers, the screen as ANSI text")
+	fs.StringVar(&opts.dumpFrames, "dump-frames", "", "write the game's frames into this directory as numbered PNGs")
+	fs.IntVar(&opts.dumpEvery, "dump-every", 35, "with --dump-frames, write only every nth frame; the game draws 35 a second")
+	fs.StringVar(&opts.recordDemo, "record-demo", "", "record a vanilla demo of a new game as this .lmp file; press q, or quit, to finish it")
+	fs.StringVar(&opts.playDemo, "play-demo", "", "play back a .lmp demo, or one in the WAD such as demo1, then exit")
+	fs.StringVar(&opts.timeDemo, "timedemo", "", "play back a demo as fast as possible and report the frame rate")
+	fs.StringVar(&opts.demoDir, "demo-dir", "demos", "where demos named without a directory are recorded, and looked for")
+	fs.BoolVar(&opts.benchRenderers, "bench-renderers", false, "run the frames of a demo, --timedemo's or --play-demo's or else demo1, through every renderer at this terminal's size, report each one's speed and output size, and exit")
+	fs.StringVar(&opts.listen, "listen", "localhost:8080", "address termdoom serve waits for a browser on, and termdoom server takes browsers on; the game is played in the page it serves")
+	fs.IntVar(&opts.webMaxSessions, "web-max-sessions", 8, "how many games termdoom server runs for browsers at once")
+	fs.StringVar(&opts.mapRotation, "map-rotation", "", "start the games of termdoom server, --ssh-listen and --telnet-listen on each of these maps in turn, e.g. E1M1,E1M2,E1M3")
+	fs.StringVar(&opts.sshListen, "ssh-listen", "", "be an SSH server on this address, e.g. :2222, where everyone connecting plays a game of their own in their terminal")
+	fs.StringVar(&opts.sshHostKey, "ssh-host-key", defaultHostKeyPath(), "--ssh-listen's host key file, made the first time")
+	fs.IntVar(&opts.sshMaxSessions, "ssh-max-sessions", 8, "how many games --ssh-listen runs at once")
+	fs.StringVar(&opts.telnetListen, "telnet-listen", "", "be a telnet server on this address, e.g. :2323, where everyone connecting plays a game of their own; nothing is encrypted")
+	fs.IntVar(&opts.telnetMaxSessions, "telnet-max-sessions", 8, "how many games --telnet-listen runs at once")
+	fs.StringVar(&opts.spectateListen, "spectate-listen", "", "let others watch the game, read-only, by connecting to this TCP address, e.g. :7777, or unix:path for a socket: watch with stty raw -echo; nc host 7777")
+	fs.StringVar(&opts.frameListen, "frame-listen", "", "serve the engine's frames over HTTP at this address, e.g. localhost:8081: /frame.png is the latest, /stream.mjpeg a motion JPEG stream, for OBS or a dashboard")
+	fs.StringVar(&opts.metricsListen, "metrics-listen", "", "serve Prometheus metrics at /metrics on this address, e.g. :9090: frames, conversion times, bytes written, input events")
+	fs.StringVar(&opts.pprof, "pprof", "", "serve net/http/pprof at /debug/pprof/ on this address, e.g. localhost:6060, for profiling a game as it is played")
+	fs.StringVar(&opts.cpuProfile, "cpuprofile", "", "write a CPU profile of the game to this file")
+	fs.StringVar(&opts.memProfile, "memprofile", "", "write a memory profile to this file when the game ends")
+	fs.StringVar(&opts.statsJSON, "stats-json", "", "also write the summary shown when the game ends to this file, as JSON")
+	fs.Var(&opts.files, "file", "load these PWADs after the IWAD, in order, e.g. --file maps.wad music.wad; names not found here are looked for where IWADs are")
+	fs.StringVar(&opts.mod, "mod", "", "play the config's [mod.name] profile: its iwad and file keys say what to load, and its other keys are flags, for the command line to override")
+	fs.BoolVar(&opts.launcher, "launcher", false, "before the game, show a menu to pick the IWAD, PWADs or mod profile, skill, map, renderer and colors instead of giving engine arguments; the last pick is remembered in the config's [launcher] section")
+	fs.BoolVar(&opts.remote, "remote", false, "a game for a remote player, as termdoom server and --ssh-listen and --telnet-listen start them: the config is read but not written, and there are no screenshots, launcher or questions")
+	fs.BoolVar(&opts.bell, "bell", false, "ring the terminal bell when hurt or given a key, for some sound from a game without any")
+	fs.BoolVar(&opts.diff, "diff", true, "only redraw cells that changed since the last frame")
+	fs.BoolVar(&opts.sync, "sync", true, "wrap frames in synchronized output (DEC mode 2026) to avoid tearing")
+	fs.StringVar(&opts.config, "config", defaultConfigPath(), "config file; its top-level keys are flag names")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: termdoom [--flags] [engine args, e.g. -iwad doom1.wad]\n")
+		fmt.Fprintf(os.Stderr, "       termdoom replay [--speed n] recording\n")
+		fmt.Fprintf(os.Stderr, "       termdoom serve [--listen addr] [--flags] [engine args]\n")
+		fmt.Fprintf(os.Stderr, "       termdoom server [--listen addr] [--ssh-listen addr] [--telnet-listen addr] [--flags] [engine args]\n")
+		fmt.Fprintf(os.Stderr, "       termdoom bench [--flags] demo\n\n")
+		fs.PrintDefaults()
+	}
+
+	own, engine := splitArgs(fs, args)
+	_ = fs.Parse(own)
+
+	// the config file fills in whatever the command line left unset
+	cfg, err := loadConfig(opts.config)
+	if err != nil {
+		usageError(fs, "config: %v", err)
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, e := range cfg.sections[""] {
+		if set[e.key] {
+			continue
+		}
+		if err := fs.Set(e.key, e.value); err != nil {
+			usageError(fs, "%s:%d: %s: %v", cfg.path, e.line, e.key, err)
+		}
+	}
+	// and a mod profile goes over the rest of the config
+	var modIWAD string
+	if opts.mod != "" {
+		entries, ok := cfg.sections["mod."+opts.mod]
+		if !ok {
+			usageError(fs, "mod: no [mod.%s] in %s", opts.mod, cfg.path)
+		}
+		for _, e := range entries {
+			switch {
+			case e.key == "iwad":
+				modIWAD = e.value
+			case set[e.key]:
+			case e.key == "mod":
+				usageError(fs, "%s:%d: a mod profile can't name another", cfg.path, e.line)
+			default:
+				if err := fs.Set(e.key, e.value); err != nil {
+					usageError(fs, "%s:%d: %s: %v", cfg.path, e.line, e.key, err)
+				}
+			}
+		}
+	}
+
+	// keys: defaults and the preset, then the config's, then the command
+	// line's
+	if _, ok := keyPresets[opts.keys]; !ok {
+		usageError(fs, "unknown keys preset %q", opts.keys)
+	}
+	opts.keymap = defaultKeymap(opts.keys)
+	for _, e := range cfg.sections["keys"] {
+		if err := opts.keymap.bind(e.key, e.value); err != nil {
+			usageError(fs, "%s:%d: %v", cfg.path, e.line, err)
+		}
+	}
+	for _, b := range opts.binds {
+		key, action, _ := strings.Cut(b, "=")
+		if err := opts.keymap.bind(strings.TrimSpace(key), strings.TrimSpace(action)); err != nil {
+			usageError(fs, "bind: %v", err)
+		}
+	}
+
+	// honour NO_COLOR (https://no-color.org) and dumb terminals unless the
+	// command line or config asked for something specific
+	if os.Getenv("TERM") == "dumb" {
+		opts.plain = true
+		opts.notice = "TERM=dumb: plain monochrome ASCII, one frame after another"
+		opts.mono = opts.mono || opts.colors == "auto"
+	} else if os.Getenv("NO_COLOR") != "" && opts.colors == "auto" {
+		opts.notice = "NO_COLOR is set: monochrome output (--colors overrides)"
+		opts.mono = true
+	}
+	if opts.mono {
+		opts.colors = "none"
+		if opts.renderer == "auto" {
+			opts.renderer = "ascii"
+		}
+	}
+	if _, ok := renderers[opts.renderer]; !ok && opts.renderer != "auto" {
+		usageError(fs, "unknown renderer %q", opts.renderer)
+	}
+	if !ditherKinds[opts.dither] {
+		usageError(fs, "unknown dither mode %q", opts.dither)
+	}
+	if opts.automap != "lines" && opts.automap != "picture" {
+		usageError(fs, "unknown automap mode %q", opts.automap)
+	}
+	if opts.minimap != "" {
+		if _, _, err := parseMinimapSize(opts.minimap); err != nil {
+			usageError(fs, "minimap: %v", err)
+		}
+	}
+	if !minimapCorners[opts.minimapCorner] {
+		usageError(fs, "unknown minimap corner %q", opts.minimapCorner)
+	}
+	if opts.gifFPS < 1 || opts.gifFPS > 35 {
+		usageError(fs, "gif-fps must be within 1..35")
+	}
+	if opts.dumpEvery < 1 {
+		usageError(fs, "dump-every must be positive")
+	}
+	if opts.videoFPS < 1 {
+		usageError(fs, "video-fps must be positive")
+	}
+	if opts.gifDuration <= 0 {
+		usageError(fs, "gif-duration must be positive")
+	}
+	demos := 0
+	for _, d := range []string{opts.recordDemo, opts.playDemo, opts.timeDemo} {
+		if d != "" {
+			demos++
+		}
+	}
+	if demos > 1 {
+		usageError(fs, "only one of record-demo, play-demo and timedemo can be given")
+	}
+	if opts.sshMaxSessions < 1 || opts.telnetMaxSessions < 1 || opts.webMaxSessions < 1 {
+		usageError(fs, "ssh-max-sessions, telnet-max-sessions and web-max-sessions must be positive")
+	}
+	if opts.mapRotation != "" {
+		if _, err := parseMapRotation(opts.mapRotation); err != nil {
+			usageError(fs, "map-rotation: %v", err)
+		}
+	}
+	if recordFormats[opts.recordFormat] == nil {
+		usageError(fs, "unknown record format %q", opts.recordFormat)
+	}
+	if !backgrounds[opts.background] {
+		usageError(fs, "unknown background %q", opts.background)
+	}
+	if _, err := parseFilters(opts.filter); err != nil {
+		usageError(fs, "filter: %v", err)
+	}
+	if opts.palette != "" {
+		var err error
+		if opts.remap, err = loadPalette(opts.palette); err != nil {
+			usageError(fs, "palette: %v", err)
+		}
+	}
+	if opts.replayInput != "" {
+		var err error
+		if opts.replay, err = loadInputReplay(opts.replayInput); err != nil {
+			usageError(fs, "replay-input: %v", err)
+		}
+	}
+	if _, ok := colorModes[opts.colors]; !ok && opts.colors != "auto" {
+		usageError(fs, "unknown color depth %q", opts.colors)
+	}
+	if !scaleModes[opts.scale] {
+		usageError(fs, "unknown scale mode %q", opts.scale)
+	}
+	if opts.scaler != "nearest" && opts.scaler != "box" {
+		usageError(fs, "unknown scaler %q", opts.scaler)
+	}
+	if opts.colorTolerance < 0 || opts.colorTolerance > 255 {
+		usageError(fs, "color-tolerance must be within 0..255")
+	}
+	if opts.fps < 0 {
+		usageError(fs, "fps must not be negative")
+	}
+	if opts.keyupDelay <= 0 {
+		usageError(fs, "keyup-delay must be positive")
+	}
+	if opts.mouseSensitivity <= 0 {
+		usageError(fs, "mouse-sensitivity must be positive")
+	}
+	if opts.gamepadDeadzone < 0 || opts.gamepadDeadzone >= 1 {
+		usageError(fs, "gamepad-deadzone must be within 0..1")
+	}
+	if opts.cellAspect < 0 {
+		usageError(fs, "cell-aspect must be positive")
+	}
+	if opts.gamma <= 0 || opts.contrast < 0 || opts.brightness < -1 || opts.brightness > 1 {
+		usageError(fs, "gamma must be positive, contrast non-negative and brightness within -1..1")
+	}
+	if err := checkRamp([]rune(opts.ramp)); err != nil {
+		usageError(fs, "ramp: %v", err)
+	}
+	if opts.remote {
+		// nothing on the host for a remote player to write to, or to be
+		// shown and asked about
+		opts.launcher = false
+		opts.screenshotDir = ""
+	}
+
+	// what to load, found and checked now rather than by the engine, which
+	// exits on a missing file with the terminal taken over
+	if modIWAD != "" && !hasIWAD(engine) {
+		path, err := findModFile(modIWAD)
+		if err == nil {
+			err = checkWAD(path)
+		}
+		if err != nil {
+			usageError(fs, "mod: iwad: %v", err)
+		}
+		engine = append(engine, "-iwad", path)
+	}
+	var files []string
+	for _, p := range opts.files {
+		path, err := findModFile(p)
+		if err == nil {
+			err = checkWAD(path)
+		}
+		if err != nil {
+			usageError(fs, "file: %v", err)
+		}
+		files = append(files, path)
+	}
+	engine = addEngineFiles(engine, files)
+	return opts, engine
+}
+
+// usageError reports a bad setting and exits like flag parsing errors do.
+func usageError(fs *flag.FlagSet, format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	fs.Usage()
+	os.Exit(2)
+}
+
+// splitArgs separates flags registered in fs, which must be spelled with two
+// dashes, from everything else so the engine's arguments pass through as-is.
+func splitArgs(fs *flag.FlagSet, args []string) (own, engine []string) {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if !strings.HasPrefix(a, "--") || len(a) == 2 {
+			engine = append(engine, a)
+			continue
+		}
+		own = append(own, a)
+		name := a[2:]
+		if strings.Contains(name, "=") {
+			continue
+		}
+		// "--name value" form for non-boolean flags
+		if f := fs.Lookup(name); f != nil && !isBoolFlag(f) && i+1 < len(args) {
+			i++
+			own = append(own, args[i])
+			// and "--file a.wad b.wad", up to the next dash argument
+			if _, ok := f.Value.(*pathList); ok {
+				for i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+					i++
+					own = append(own, a, args[i])
+				}
+			}
+		}
+	}
+	return own, engine
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
@@ -0,0 +1,70 @@
+package main
+
+import "image"
+
+// flashStep is how far the mean of each color channel may move per frame
+// with --reduce-flashing: about 140 levels a second at 35 fps, so fades
+// and lighting changes pass but flashes become slow swells.
+const flashStep = 4
+
+// flashGuard limits how fast the picture's overall brightness and tint can
+// change, for photosensitive players. Muzzle flashes, and the red, gold and
+// green palette shifts DOOM uses for damage, pickups and the radiation
+// suit, change the whole screen at once; every frame is scaled per channel
+// so its mean stays within flashStep of the previous frame's.
+type flashGuard struct {
+	mean  [3]float64 // per-channel mean of the last frame shown
+	valid bool
+	lut   [3][256]uint8
+}
+
+func (f *flashGuard) apply(img *image.RGBA) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	// every other pixel of every other row is plenty for a mean
+	var sum [3]int
+	n := 0
+	for y := 0; y < h; y += 2 {
+		row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+		for o := 0; o < len(row); o += 8 {
+			sum[0] += int(row[o])
+			sum[1] += int(row[o+1])
+			sum[2] += int(row[o+2])
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	var gain [3]float64
+	identity := true
+	for c := range 3 {
+		m := float64(sum[c]) / float64(n)
+		target := m
+		if f.valid {
+			target = min(max(m, f.mean[c]-flashStep), f.mean[c]+flashStep)
+		}
+		gain[c] = 1
+		if m >= 1 {
+			gain[c] = min(max(target/m, 0.25), 4)
+		}
+		f.mean[c] = m * gain[c]
+		identity = identity && gain[c] == 1
+	}
+	f.valid = true
+	if identity {
+		return
+	}
+	for c := range 3 {
+		for i := range 256 {
+			f.lut[c][i] = clamp8(int(float64(i)*gain[c] + 0.5))
+		}
+	}
+	for y := 0; y < h; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+		for o := 0; o < len(row); o += 4 {
+			row[o] = f.lut[0][row[o]]
+			row[o+1] = f.lut[1][row[o+1]]
+			row[o+2] = f.lut[2][row[o+2]]
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// streamInterval is how often /stream.mjpeg sends a frame, if there is a
+// new one: the engine's 35 a second.
+const streamInterval = time.Second / 35
+
+// frameServer serves the engine's latest frame over HTTP for
+// --frame-listen: /frame.png as a snapshot and /stream.mjpeg as motion
+// JPEG, for OBS and dashboards. Frames are the engine's own 320×200,
+// before any conversion for the terminal.
+type frameServer struct {
+	mu    sync.Mutex
+	frame *image.RGBA // nil until the first
+	seq   uint64      // counts frames, so a stream sends each once
+}
+
+func listenFrames(addr string) (*frameServer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &frameServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/frame.png", s.servePNG)
+	mux.HandleFunc("/stream.mjpeg", s.serveMJPEG)
+	go http.Serve(l, mux)
+	return s, nil
+}
+
+// put keeps a copy of img as the latest frame.
+func (s *frameServer) put(img *image.RGBA) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.frame == nil || s.frame.Bounds() != img.Bounds() {
+		s.frame = image.NewRGBA(img.Bounds())
+	}
+	copy(s.frame.Pix, img.Pix)
+	s.seq++
+}
+
+// latest is a copy of the latest frame and its number, nil before the
+// first.
+func (s *frameServer) latest() (*image.RGBA, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.frame == nil {
+		return nil, 0
+	}
+	img := image.NewRGBA(s.frame.Bounds())
+	copy(img.Pix, s.frame.Pix)
+	return img, s.seq
+}
+
+func (s *frameServer) servePNG(w http.ResponseWriter, r *http.Request) {
+	img, _ := s.latest()
+	if img == nil {
+		http.Error(w, "no frame yet", http.StatusServiceUnavailable)
+		return
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(b.Bytes())
+}
+
+// serveMJPEG sends each new frame as a JPEG, each replacing the last,
+// until the client goes.
+func (s *frameServer) serveMJPEG(w http.ResponseWriter, r *http.Request) {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
+	w.Header().Set("Cache-Control", "no-store")
+	flusher, _ := w.(http.Flusher)
+	tick := time.NewTicker(streamInterval)
+	defer tick.Stop()
+	var sent uint64
+	var b bytes.Buffer
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-tick.C:
+		}
+		img, seq := s.latest()
+		if img == nil || seq == sent {
+			continue
+		}
+		sent = seq
+		b.Reset()
+		if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 85}); err != nil {
+			return
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":   {"image/jpeg"},
+			"Content-Length": {strconv.Itoa(b.Len())},
+		})
+		if err != nil {
+			return
+		}
+		if _, err := part.Write(b.Bytes()); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import "time"
+
+// Gamepad controls. Buttons, triggers and stick directions bind like keys,
+// through sequences no terminal sends: a private-use character each.
+const (
+	padA = iota
+	padB
+	padX
+	padY
+	padLB
+	padRB
+	padLT
+	padRT
+	padBack
+	padStart
+	padGuide
+	padLThumb
+	padRThumb
+	padUp // d-pad
+	padDown
+	padLeft
+	padRight
+	padLUp // left stick
+	padLDown
+	padLLeft
+	padLRight
+	padRUp // right stick; sideways turns smoothly instead
+	padRDown
+	padControls
+)
+
+var padNames = [padControls]string{
+	"pad-a", "pad-b", "pad-x", "pad-y", "pad-lb", "pad-rb", "pad-lt", "pad-rt",
+	"pad-back", "pad-start", "pad-guide", "pad-lthumb", "pad-rthumb",
+	"pad-up", "pad-down", "pad-left", "pad-right",
+	"pad-lstick-up", "pad-lstick-down", "pad-lstick-left", "pad-lstick-right",
+	"pad-rstick-up", "pad-rstick-down",
+}
+
+// Gamepad axes, as the device layer reports them: sticks and the d-pad
+// hat from -1 to 1, triggers from 0 to 1.
+const (
+	axisLX = iota
+	axisLY
+	axisRX
+	axisRY
+	axisLT
+	axisRT
+	axisHatX
+	axisHatY
+)
+
+// padTurnRate is how far full right stick turns, in the emulated mouse
+// position per second; about one and a half times turning with run held.
+const padTurnRate = 0.25
+
+func padKey(c int) string { return string(rune(0xf0000 + c)) }
+
+func init() {
+	for c, name := range padNames {
+		keyNames[name] = padKey(c)
+	}
+}
+
+// padInput is a button (value 0 or 1) or axis change from the device.
+type padInput struct {
+	axis    bool
+	control int
+	value   float64
+}
+
+// gamepad turns device input into presses and releases of its controls,
+// and the right stick into turning.
+type gamepad struct {
+	input    <-chan padInput
+	deadzone float64
+	down     [padControls]bool
+	// turn is the right stick's sideways tilt beyond the dead zone,
+	// scaled back to -1..1, which turns like the mouse
+	turn     float64
+	lastTurn time.Time
+}
+
+func newGamepad(input <-chan padInput, deadzone float64) *gamepad {
+	return &gamepad{input: input, deadzone: deadzone}
+}
+
+// poll reads whatever input has arrived, calling press for each control
+// that went down or up. A gamepad that goes away lets go of everything.
+func (p *gamepad) poll(press func(control int, down bool)) {
+	for {
+		select {
+		case in, ok := <-p.input:
+			if !ok {
+				p.input = nil
+				p.turn = 0
+				for c := range p.down {
+					p.set(c, false, press)
+				}
+				return
+			}
+			p.update(in, press)
+		default:
+			return
+		}
+	}
+}
+
+func (p *gamepad) update(in padInput, press func(int, bool)) {
+	if !in.axis {
+		p.set(in.control, in.value != 0, press)
+		return
+	}
+	v, dz := in.value, p.deadzone
+	switch in.control {
+	case axisLX:
+		p.set(padLLeft, v < -dz, press)
+		p.set(padLRight, v > dz, press)
+	case axisLY:
+		p.set(padLUp, v < -dz, press)
+		p.set(padLDown, v > dz, press)
+	case axisRX:
+		switch {
+		case v > dz:
+			p.turn = (v - dz) / (1 - dz)
+		case v < -dz:
+			p.turn = (v + dz) / (1 - dz)
+		default:
+			p.turn = 0
+		}
+	case axisRY:
+		p.set(padRUp, v < -dz, press)
+		p.set(padRDown, v > dz, press)
+	case axisLT:
+		p.set(padLT, v > 0.5, press)
+	case axisRT:
+		p.set(padRT, v > 0.5, press)
+	case axisHatX:
+		p.set(padLeft, v < 0, press)
+		p.set(padRight, v > 0, press)
+	case axisHatY:
+		p.set(padUp, v < 0, press)
+		p.set(padDown, v > 0, press)
+	}
+}
+
+func (p *gamepad) set(c int, down bool, press func(int, bool)) {
+	if p.down[c] != down {
+		p.down[c] = down
+		press(c, down)
+	}
+}
+
+// turning returns how far the right stick has turned since it was last
+// asked, in the engine's mouse position, at most once a tic since the
+// engine only keeps the last mouse movement it is given.
+func (p *gamepad) turning(now time.Time) float64 {
+	const tic = time.Second / 35
+	dt := now.Sub(p.lastTurn)
+	if p.turn == 0 || dt < tic {
+		return 0
+	}
+	if dt > 4*tic {
+		// the stick was just tilted
+		dt = tic
+	}
+	p.lastTurn = now
+	return p.turn * padTurnRate * dt.Seconds()
+}
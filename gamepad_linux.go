@@ -0,0 +1,141 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// evdev event types, and the ioctls reading a device's buttons and axis
+// ranges
+const (
+	evKey      = 0x01
+	evAbs      = 0x03
+	btnGamepad = 0x130 // BTN_SOUTH, which every gamepad has
+
+	eviocgbitKey = 2<<30 | 96<<16 | 'E'<<8 | 0x20 + evKey // 96 bytes covers KEY_MAX
+	eviocgabs    = 2<<30 | 24<<16 | 'E'<<8 | 0x40         // + the axis
+)
+
+// padButtons maps evdev button codes to controls, including the digital
+// triggers and d-pad some gamepads have instead of axes.
+var padButtons = map[uint16]int{
+	0x130: padA, 0x131: padB, 0x133: padX, 0x134: padY,
+	0x136: padLB, 0x137: padRB, 0x138: padLT, 0x139: padRT,
+	0x13a: padBack, 0x13b: padStart, 0x13c: padGuide, 0x13d: padLThumb, 0x13e: padRThumb,
+	0x220: padUp, 0x221: padDown, 0x222: padLeft, 0x223: padRight,
+}
+
+// padAxes maps evdev axis codes to axes; gas and brake are triggers on
+// some gamepads.
+var padAxes = map[uint16]int{
+	0x00: axisLX, 0x01: axisLY, 0x02: axisLT, 0x03: axisRX, 0x04: axisRY, 0x05: axisRT,
+	0x09: axisRT, 0x0a: axisLT, 0x10: axisHatX, 0x11: axisHatY,
+}
+
+// absInfo is struct input_absinfo.
+type absInfo struct {
+	value, min, max, fuzz, flat, resolution int32
+}
+
+// openGamepad opens the evdev device at path, or with "auto" the first
+// one with gamepad buttons, and reads it in the background.
+func openGamepad(path string) (<-chan padInput, error) {
+	paths := []string{path}
+	if path == "auto" {
+		paths, _ = filepath.Glob("/dev/input/event*")
+	}
+	var firstErr error
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		ranges, err := gamepadAxes(f)
+		if err != nil {
+			f.Close()
+			if path != "auto" {
+				return nil, fmt.Errorf("%s: %v", p, err)
+			}
+			continue
+		}
+		ch := make(chan padInput, 64)
+		go readGamepad(f, ranges, ch)
+		return ch, nil
+	}
+	if firstErr != nil {
+		// most likely not in the input group
+		return nil, firstErr
+	}
+	return nil, errors.New("no gamepad found")
+}
+
+// gamepadAxes checks f is a gamepad and returns the range of each axis.
+func gamepadAxes(f *os.File) (map[uint16]absInfo, error) {
+	fd := f.Fd()
+	var keys [96]byte
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, eviocgbitKey, uintptr(unsafe.Pointer(&keys[0]))); errno != 0 {
+		return nil, errno
+	}
+	if keys[btnGamepad/8]&(1<<(btnGamepad%8)) == 0 {
+		return nil, errors.New("not a gamepad")
+	}
+	ranges := make(map[uint16]absInfo)
+	for code := range padAxes {
+		var info absInfo
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, eviocgabs+uintptr(code), uintptr(unsafe.Pointer(&info)))
+		if errno == 0 && info.max > info.min {
+			ranges[code] = info
+		}
+	}
+	return ranges, nil
+}
+
+// readGamepad sends f's button and axis changes on ch until it fails,
+// when the gamepad is unplugged, and then closes ch.
+func readGamepad(f *os.File, ranges map[uint16]absInfo, ch chan<- padInput) {
+	defer close(ch)
+	defer f.Close()
+	// struct input_event: a timeval, then type, code and value
+	size := int(unsafe.Sizeof(unix.Timeval{})) + 8
+	buf := make([]byte, 64*size)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			return
+		}
+		for o := 0; o+size <= n; o += size {
+			e := buf[o+size-8 : o+size]
+			typ := binary.NativeEndian.Uint16(e)
+			code := binary.NativeEndian.Uint16(e[2:])
+			value := int32(binary.NativeEndian.Uint32(e[4:]))
+			switch typ {
+			case evKey:
+				if c, ok := padButtons[code]; ok {
+					ch <- padInput{control: c, value: float64(min(value, 1))}
+				}
+			case evAbs:
+				a, ok := padAxes[code]
+				info, known := ranges[code]
+				if !ok || !known {
+					continue
+				}
+				v := float64(value-info.min) / float64(info.max-info.min)
+				if a != axisLT && a != axisRT {
+					v = v*2 - 1
+				}
+				ch <- padInput{axis: true, control: a, value: v}
+			}
+		}
+	}
+}
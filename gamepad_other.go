@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func openGamepad(path string) (<-chan padInput, error) {
+	return nil, errors.New("gamepads are only supported on Linux")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"os"
+	"time"
+)
+
+// gifRecorder collects the engine's frames for --export-gif, at most fps
+// a second and up to a time limit, and writes them out as an animated GIF
+// when the game ends. Frames are the engine's own 320×200, before any
+// conversion for the terminal.
+type gifRecorder struct {
+	f        *os.File
+	interval time.Duration
+	limit    time.Duration
+	// first and last are when the first and last frames were taken, and
+	// next when the next is due
+	first, last, next time.Time
+	anim              gif.GIF
+}
+
+func newGIFRecorder(path string, fps int, limit time.Duration) (*gifRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &gifRecorder{f: f, interval: time.Second / time.Duration(fps), limit: limit}, nil
+}
+
+// frame takes img if a frame is due.
+func (g *gifRecorder) frame(img *image.RGBA, now time.Time) {
+	if now.Before(g.next) || !g.first.IsZero() && now.Sub(g.first) >= g.limit {
+		return
+	}
+	// due on the beat, not from whenever the engine drew, so the frame
+	// rate holds; a stall starts it afresh
+	g.next = g.next.Add(g.interval)
+	if g.next.Before(now) {
+		g.next = now.Add(g.interval)
+	}
+	if g.first.IsZero() {
+		g.first = now
+	}
+	if n := len(g.anim.Delay); n > 0 {
+		// the last frame lasted until this one, however the engine kept
+		// time
+		g.anim.Delay[n-1] = centiseconds(now.Sub(g.last))
+	}
+	g.last = now
+	g.anim.Image = append(g.anim.Image, paletted(img))
+	g.anim.Delay = append(g.anim.Delay, centiseconds(g.interval))
+}
+
+func centiseconds(d time.Duration) int {
+	return max(int((d+5*time.Millisecond)/(10*time.Millisecond)), 1)
+}
+
+// paletted converts img to a paletted image of its own colors, which for
+// the engine's frames are at most the 256 of its palette. Anything with
+// more is dithered to a standard palette.
+func paletted(img *image.RGBA) *image.Paletted {
+	b := img.Bounds()
+	p := image.NewPaletted(b, make(color.Palette, 0, 256))
+	index := make(map[color.RGBA]uint8, 256)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			i, ok := index[c]
+			if !ok {
+				if len(p.Palette) == 256 {
+					p = image.NewPaletted(b, palette.Plan9)
+					draw.FloydSteinberg.Draw(p, b, img, b.Min)
+					return p
+				}
+				i = uint8(len(p.Palette))
+				index[c] = i
+				p.Palette = append(p.Palette, c)
+			}
+			p.Pix[p.PixOffset(x, y)] = i
+		}
+	}
+	return p
+}
+
+// close writes the GIF.
+func (g *gifRecorder) close() error {
+	if len(g.anim.Image) == 0 {
+		return g.f.Close()
+	}
+	if err := gif.EncodeAll(g.f, &g.anim); err != nil {
+		g.f.Close()
+		return err
+	}
+	return g.f.Close()
+}
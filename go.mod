@@ -6,8 +6,7 @@ toolchain go1.24.9
 
 require (
 	github.com/AndreRenaud/gore v0.0.0-20251013171446-ab1a5c716031
-	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
+	golang.org/x/crypto v0.43.0
+	golang.org/x/sys v0.37.0
 	golang.org/x/term v0.36.0
 )
-
-require golang.org/x/sys v0.37.0 // indirect
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"unicode"
+	"unicode/utf8"
+)
+
+// cell is one terminal character with its colors. A zero ch marks the
+// second column of a double-width glyph, which is never written itself.
+type cell struct {
+	ch rune
+	// fg with A == 0 uses the terminal's default foreground.
+	fg color.RGBA
+	// bg with A == 0 leaves the terminal's own background showing.
+	bg color.RGBA
+}
+
+// grid is the character-cell image a renderer produces for one frame.
+type grid struct {
+	w, h  int
+	cells []cell
+	// top is the screen row of the first row, for views made by rows
+	top int
+}
+
+// resize sets the grid dimensions, reusing the backing array when possible.
+func (g *grid) resize(w, h int) {
+	if cap(g.cells) < w*h {
+		g.cells = make([]cell, w*h)
+	}
+	g.cells = g.cells[:w*h]
+	g.w, g.h = w, h
+}
+
+func (g *grid) set(x, y int, c cell) {
+	g.cells[y*g.w+x] = c
+}
+
+// rows returns a view of rows [y0, y1) sharing g's cells.
+func (g *grid) rows(y0, y1 int) grid {
+	return grid{w: g.w, h: y1 - y0, cells: g.cells[y0*g.w : y1*g.w], top: g.top + y0}
+}
+
+func (g *grid) fill(c cell) {
+	for i := range g.cells {
+		g.cells[i] = c
+	}
+}
+
+// blit copies src into g with its top-left corner at (x, y).
+func (g *grid) blit(src *grid, x, y int) {
+	for sy := 0; sy < src.h; sy++ {
+		copy(g.cells[(y+sy)*g.w+x:], src.cells[sy*src.w:(sy+1)*src.w])
+	}
+}
+
+// writeGrid encodes the grid as ANSI text in the given color mode, emitting
+// colors only on change. In true color mode a tol above zero keeps the
+// current color for cells within tol of it; those cells are updated to
+// the color actually shown.
+func writeGrid(b *bytes.Buffer, g *grid, mode colorMode, tol int) {
+	for y := 0; y < g.h; y++ {
+		writeRow(b, g.cells[y*g.w:(y+1)*g.w], mode, tol)
+		b.WriteString("\r\n")
+	}
+}
+
+// writeGridRows is writeGrid for only the rows not marked in skip, each
+// placed with a cursor move, so rows the screen already shows cost
+// nothing.
+func writeGridRows(b *bytes.Buffer, g *grid, mode colorMode, tol int, skip []bool) {
+	for y := 0; y < g.h; y++ {
+		if skip[y] {
+			continue
+		}
+		b.WriteString("\x1b[")
+		writeInt(b, g.top+y+1)
+		b.WriteString(";1H")
+		writeRow(b, g.cells[y*g.w:(y+1)*g.w], mode, tol)
+	}
+}
+
+// writeRow writes one row of cells, leaving attributes reset after it.
+func writeRow(b *bytes.Buffer, row []cell, mode colorMode, tol int) {
+	var buf [utf8.UTFMax]byte
+	if mode == colorNone {
+		for _, c := range row {
+			if c.ch == 0 {
+				continue
+			}
+			n := utf8.EncodeRune(buf[:], c.ch)
+			b.Write(buf[:n])
+		}
+		return
+	}
+	// -1 is the default color, which is what a line starts with
+	fg, bg := -1, -1
+	var fgc, bgc color.RGBA
+	for x, c := range row {
+		if c.ch == 0 {
+			continue
+		}
+		if tol > 0 && mode == colorTrue {
+			c.fg, c.bg = settle(fgc, c.fg, tol), settle(bgc, c.bg, tol)
+			fgc, bgc = c.fg, c.bg
+			row[x] = c
+		}
+		if k := colorKey(38, c.fg, mode); k != fg {
+			writeColor(b, 38, c.fg, mode)
+			fg = k
+		}
+		if k := colorKey(48, c.bg, mode); k != bg {
+			writeColor(b, 48, c.bg, mode)
+			bg = k
+		}
+		n := utf8.EncodeRune(buf[:], c.ch)
+		b.Write(buf[:n])
+	}
+	// reset at EOL
+	b.WriteString("\x1b[0m")
+}
+
+// hashRow fingerprints a row of cells (FNV-1a), to tell cheaply whether
+// it is the same as last frame's.
+func hashRow(row []cell) uint64 {
+	h := uint64(14695981039346656037)
+	mix := func(v uint32) {
+		h = (h ^ uint64(v)) * 1099511628211
+	}
+	for _, c := range row {
+		mix(uint32(c.ch))
+		mix(uint32(c.fg.R)<<24 | uint32(c.fg.G)<<16 | uint32(c.fg.B)<<8 | uint32(c.fg.A))
+		mix(uint32(c.bg.R)<<24 | uint32(c.bg.G)<<16 | uint32(c.bg.B)<<8 | uint32(c.bg.A))
+	}
+	return h
+}
+
+// writeGridDiff emits only the cells that differ from prev, which must be
+// what the screen currently shows at the same size, moving the cursor over
+// unchanged runs. On a mostly static picture this is a fraction of the
+// bytes of a full redraw.
+func writeGridDiff(b *bytes.Buffer, g, prev *grid, mode colorMode, tol int) {
+	var buf [utf8.UTFMax]byte
+	// start from known attributes; the cursor position is unknown
+	b.WriteString("\x1b[0m")
+	fg, bg := -1, -1
+	var fgc, bgc color.RGBA
+	cx, cy := -1, -1
+	for y := 0; y < g.h; y++ {
+		row := g.cells[y*g.w : (y+1)*g.w]
+		old := prev.cells[y*g.w : (y+1)*g.w]
+		for x, c := range row {
+			if c.ch == 0 || sameCell(c, old[x], mode) {
+				continue
+			}
+			if x != cx || y != cy {
+				b.WriteString("\x1b[")
+				writeInt(b, g.top+y+1)
+				b.WriteByte(';')
+				writeInt(b, x+1)
+				b.WriteByte('H')
+			}
+			if tol > 0 && mode == colorTrue {
+				c.fg, c.bg = settle(fgc, c.fg, tol), settle(bgc, c.bg, tol)
+				fgc, bgc = c.fg, c.bg
+				row[x] = c
+			}
+			if mode != colorNone {
+				if k := colorKey(38, c.fg, mode); k != fg {
+					writeColor(b, 38, c.fg, mode)
+					fg = k
+				}
+				if k := colorKey(48, c.bg, mode); k != bg {
+					writeColor(b, 48, c.bg, mode)
+					bg = k
+				}
+			}
+			n := utf8.EncodeRune(buf[:], c.ch)
+			b.Write(buf[:n])
+			cx, cy = x+runeWidth(c.ch), y
+		}
+	}
+	b.WriteString("\x1b[0m")
+}
+
+// settle returns cur when c is within tol of it, so that no color change
+// needs to be sent, and c otherwise. Distance is the "redmean" weighted
+// RGB approximation of perceptual difference, scaled to channel units.
+func settle(cur, c color.RGBA, tol int) color.RGBA {
+	if cur.A == 0 || c.A == 0 {
+		return c
+	}
+	rm := (int(cur.R) + int(c.R)) / 2
+	dr := int(cur.R) - int(c.R)
+	dg := int(cur.G) - int(c.G)
+	db := int(cur.B) - int(c.B)
+	// weights sum to 9 (times 256), hence the 9 on the other side
+	d := ((512+rm)*dr*dr + 1024*dg*dg + (767-rm)*db*db) >> 8
+	if d <= 9*tol*tol {
+		return cur
+	}
+	return c
+}
+
+// sameCell reports whether a and b look identical in mode.
+func sameCell(a, b cell, mode colorMode) bool {
+	if a.ch != b.ch {
+		return false
+	}
+	if mode == colorNone {
+		return true
+	}
+	if a == b {
+		return true
+	}
+	return colorKey(38, a.fg, mode) == colorKey(38, b.fg, mode) &&
+		colorKey(48, a.bg, mode) == colorKey(48, b.bg, mode)
+}
+
+// copyGrid makes dst an exact copy of src.
+func copyGrid(dst, src *grid) {
+	dst.resize(src.w, src.h)
+	copy(dst.cells, src.cells)
+}
+
+// runeWidth returns how many terminal columns r occupies: 0 for combining
+// and format characters, 2 for East Asian wide glyphs and emoji, else 1.
+func runeWidth(r rune) int {
+	switch {
+	case r < 0x20 || r == 0x7f:
+		return 0
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0xa4cf && r != 0x303f,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f,
+		r >= 0x1f900 && r <= 0x1f9ff,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// toHalfBlock packs two vertically stacked pixels into each cell using the
+// upper half block: the foreground paints the top pixel, the background the
+// bottom one. img must be g.w×2g.h.
+func toHalfBlock(g *grid, img *image.RGBA) {
+	for y := 0; y < g.h; y++ {
+		top := y * 2 * img.Stride
+		bot := top + img.Stride
+		for x := 0; x < g.w; x++ {
+			o := x * 4
+			g.set(x, y, cell{
+				ch: '▀',
+				fg: color.RGBA{img.Pix[top+o], img.Pix[top+o+1], img.Pix[top+o+2], 255},
+				bg: color.RGBA{img.Pix[bot+o], img.Pix[bot+o+1], img.Pix[bot+o+2], 255},
+			})
+		}
+	}
+}
+
+// toPixels paints every cell as a space on a background of the pixel's
+// color: solid blocks instead of textured characters. img must be g.w×g.h.
+func toPixels(g *grid, img *image.RGBA) {
+	for y := 0; y < g.h; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < g.w; x++ {
+			o := x * 4
+			g.set(x, y, cell{ch: ' ', bg: color.RGBA{row[o], row[o+1], row[o+2], 255}})
+		}
+	}
+}
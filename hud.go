@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+)
+
+// The status bar as the engine lays it out on its 320×200 screen.
+const (
+	stBarY   = 168 // top of the bar, where the 3D view ends
+	stNumY   = 171
+	stAmmoX  = 44 // right edges of the big numbers
+	stHealX  = 90
+	stArmorX = 221
+	stKeyX   = 239
+	stArmsX  = 111 // the weapon numbers, three to a row
+	stArmsY  = 172
+)
+
+// hudReader reads the player's status back out of the status bar in a
+// frame, by matching it against the bar's own graphics from the WAD: the
+// engine doesn't expose the numbers, but it draws them pixel exact.
+type hudReader struct {
+	pals  [][256]color.RGBA
+	bar   *patch
+	nums  [10]*patch
+	keys  [6]*patch    // blue, yellow, red card, then skulls
+	arms  [6][2]*patch // weapons 2 to 7, grey and yellow once owned
+	pal   int          // palette of the last frame, checked first
+	state hudState
+}
+
+// hudState is what the status bar showed. Numbers are -1 where blank.
+type hudState struct {
+	health, armor, ammo int
+	// keys holds 0 for none, 1 for a keycard, 2 for a skull key, in the
+	// order blue, yellow, red
+	keys [3]int
+	// arms holds which of weapons 2 to 7 are owned, once armsRead
+	arms     [6]bool
+	armsRead bool
+}
+
+func newHUDReader(w *wad) (*hudReader, error) {
+	var err error
+	h := &hudReader{}
+	if h.pals, err = w.palettes(); err != nil {
+		return nil, err
+	}
+	if h.bar, err = w.patch("STBAR"); err != nil {
+		return nil, err
+	}
+	// the bar is read as far as the screen's edges
+	if h.bar.w < 320 || h.bar.h < 200-stBarY {
+		return nil, fmt.Errorf("STBAR is %dx%d, smaller than the screen's bar", h.bar.w, h.bar.h)
+	}
+	for i := range h.nums {
+		if h.nums[i], err = w.patch(fmt.Sprintf("STTNUM%d", i)); err != nil {
+			return nil, err
+		}
+	}
+	for i := range h.keys {
+		if h.keys[i], err = w.patch(fmt.Sprintf("STKEYS%d", i)); err != nil {
+			return nil, err
+		}
+	}
+	for i := range h.arms {
+		for c, prefix := range [2]string{"STGNUM", "STYSNUM"} {
+			if h.arms[i][c], err = w.patch(fmt.Sprintf("%s%d", prefix, i+2)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return h, nil
+}
+
+// read updates the state from img, reporting whether a status bar is
+// showing at all (it isn't on menus, intermissions or full screen view).
+func (h *hudReader) read(img *image.RGBA) bool {
+	if img.Rect.Dx() != 320 || img.Rect.Dy() != 200 {
+		return false
+	}
+	if !h.findPalette(img) {
+		return false
+	}
+	h.state.ammo = h.number(img, stAmmoX, 3)
+	h.state.health = h.number(img, stHealX, 3)
+	h.state.armor = h.number(img, stArmorX, 3)
+	for i := range h.state.keys {
+		y := stNumY + i*10
+		h.state.keys[i] = h.best(img, stKeyX, y, h.keys[i].w, h.keys[i].h, h.keys[i], h.keys[i+3])
+	}
+	for i, a := range h.arms {
+		x, y := stArmsX+i%3*12, stArmsY+i/3*10
+		h.state.arms[i] = h.best(img, x, y, a[0].w, a[0].h, a[0], a[1]) == 2
+	}
+	h.state.armsRead = true
+	return true
+}
+
+// findPalette works out which palette the frame was drawn with from the
+// left end of the bar, which nothing draws over. It fails when that part
+// of the frame isn't the bar.
+func (h *hudReader) findPalette(img *image.RGBA) bool {
+	try := func(p int) bool {
+		match, n := 0, 0
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 104; x += 2 {
+				i := h.bar.pix[y*h.bar.w+x]
+				if i < 0 {
+					continue
+				}
+				n++
+				if h.at(img, x, stBarY+y) == h.pals[p][i] {
+					match++
+				}
+			}
+		}
+		return n > 0 && match*10 >= n*9
+	}
+	if try(h.pal) {
+		return true
+	}
+	for p := range h.pals {
+		if try(p) {
+			h.pal = p
+			return true
+		}
+	}
+	return false
+}
+
+func (h *hudReader) at(img *image.RGBA, x, y int) color.RGBA {
+	o := y*img.Stride + x*4
+	return color.RGBA{img.Pix[o], img.Pix[o+1], img.Pix[o+2], 255}
+}
+
+// number reads a right-aligned number of up to digits digits ending at x.
+func (h *hudReader) number(img *image.RGBA, x, digits int) int {
+	w := h.nums[0].w
+	n, seen := 0, false
+	scale := 1
+	for k := 0; k < digits; k++ {
+		c := h.best(img, x-(k+1)*w, stNumY, w, h.nums[0].h, h.nums[:]...)
+		if c == 0 {
+			break
+		}
+		n += (c - 1) * scale
+		scale *= 10
+		seen = true
+	}
+	if !seen {
+		return -1
+	}
+	return n
+}
+
+// best returns which of the candidates, drawn the way the engine does at
+// (x, y) over the bar, matches the w×h area there: 0 for none of them
+// (just the bar), else the candidate's index plus one.
+func (h *hudReader) best(img *image.RGBA, x, y, w, hh int, cands ...*patch) int {
+	pal := &h.pals[h.pal]
+	score := func(p *patch) int {
+		s := 0
+		for yy := y; yy < y+hh; yy++ {
+			for xx := x; xx < x+w; xx++ {
+				i := h.bar.pix[(yy-stBarY)*h.bar.w+xx]
+				if p != nil {
+					px, py := xx-x+p.left, yy-y+p.top
+					if px >= 0 && py >= 0 && px < p.w && py < p.h && p.pix[py*p.w+px] >= 0 {
+						i = p.pix[py*p.w+px]
+					}
+				}
+				if i >= 0 && h.at(img, xx, yy) == pal[i] {
+					s++
+				}
+			}
+		}
+		return s
+	}
+	best, bestScore := 0, score(nil)
+	for c, p := range cands {
+		if s := score(p); s > bestScore {
+			best, bestScore = c+1, s
+		}
+	}
+	return best
+}
+
+// hudColors for the text status line.
+var (
+	hudLabel  = color.RGBA{170, 170, 170, 255}
+	hudRed    = color.RGBA{230, 40, 40, 255}
+	hudGold   = color.RGBA{240, 200, 40, 255}
+	hudGreen  = color.RGBA{60, 220, 60, 255}
+	hudBlue   = color.RGBA{70, 110, 255, 255}
+	keyColors = [3]color.RGBA{hudBlue, hudGold, hudRed}
+)
+
+// line draws the state as one row of colored text, centered in g's row y.
+func (s *hudState) line(g *grid, y int) {
+	var cells []cell
+	add := func(text string, fg color.RGBA) {
+		for _, r := range text {
+			cells = append(cells, cell{ch: r, fg: fg})
+		}
+	}
+	level := func(v int) color.RGBA {
+		switch {
+		case v < 25:
+			return hudRed
+		case v < 75:
+			return hudGold
+		}
+		return hudGreen
+	}
+	value := func(v int) string {
+		if v < 0 {
+			return "--"
+		}
+		return strconv.Itoa(v)
+	}
+	add("HEALTH ", hudLabel)
+	add(value(s.health)+"%", level(s.health))
+	add("  ARMOR ", hudLabel)
+	add(value(s.armor)+"%", level(s.armor))
+	add("  AMMO ", hudLabel)
+	ammo := hudGold
+	if s.ammo == 0 {
+		ammo = hudRed
+	}
+	add(value(s.ammo), ammo)
+	add("  KEYS", hudLabel)
+	for i, k := range s.keys {
+		switch k {
+		case 1:
+			add(" ■", keyColors[i])
+		case 2:
+			add(" ◆", keyColors[i])
+		}
+	}
+	x := max((g.w-len(cells))/2, 0)
+	for i, c := range cells {
+		if x+i < g.w {
+			g.set(x+i, y, c)
+		}
+	}
+}
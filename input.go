@@ -0,0 +1,143 @@
+package main
+
+import "time"
+
+// escTimeout is how long an escape sequence may take to arrive whole
+// before what has come is taken as is: a lone ESC is the Escape key.
+const escTimeout = 25 * time.Millisecond
+
+// pasteOn turns on bracketed paste, which marks pasted text with
+// pasteStart and pasteEnd, and pasteOff turns it off again.
+const (
+	pasteOn    = "\x1b[?2004h"
+	pasteOff   = "\x1b[?2004l"
+	pasteStart = "\x1b[200~"
+	pasteEnd   = "\x1b[201~"
+)
+
+// focusOn turns on focus reporting, focusIn and focusOut, and focusOff
+// turns it off again.
+const (
+	focusOn  = "\x1b[?1004h"
+	focusOff = "\x1b[?1004l"
+	focusIn  = "\x1b[I"
+	focusOut = "\x1b[O"
+)
+
+// inputParser splits terminal input into keys: single bytes, and escape
+// sequences whole however the reads split them. Pasted text is dropped,
+// rather than played as a burst of keys.
+type inputParser struct {
+	buf []byte
+	// since is when buf's ESC arrived
+	since   time.Time
+	pasting bool
+}
+
+// next returns the next key that has arrived, or nil if there is none yet
+// or keys is closed.
+func (p *inputParser) next(keys <-chan byte, now time.Time) []byte {
+	for {
+		select {
+		case b, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			if seq := p.keep(p.feed(b, now)); seq != nil {
+				return normalizeKey(seq)
+			}
+		default:
+			if len(p.buf) > 0 && now.Sub(p.since) >= escTimeout {
+				seq := p.buf
+				p.buf = nil
+				if seq = p.keep(seq); seq != nil {
+					return normalizeKey(seq)
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// feed adds b, returning a key once one is complete.
+func (p *inputParser) feed(b byte, now time.Time) []byte {
+	if len(p.buf) == 0 {
+		if b != 0x1b {
+			return []byte{b}
+		}
+		p.buf, p.since = []byte{b}, now
+		return nil
+	}
+	if len(p.buf) == 1 && b == 0x1b {
+		// Escape pressed twice
+		p.since = now
+		return []byte{0x1b}
+	}
+	p.buf = append(p.buf, b)
+	done := false
+	switch {
+	case len(p.buf) == 2:
+		// CSI and SS3 go on; anything else after ESC is Alt and a key
+		done = b != '[' && b != 'O'
+	case p.buf[1] == '[' && len(p.buf) == 3 && b == '[':
+		// the Linux console's F1 to F5, ESC [ [ A to E
+	case p.buf[1] == '[' && len(p.buf) == 4 && p.buf[2] == '[':
+		done = true
+	case b >= 0x40 && b <= 0x7e:
+		// a final byte, after CSI or SS3 parameters
+		done = true
+	case b < 0x20 || len(p.buf) >= 32:
+		// not a sequence after all
+		done = true
+	}
+	if !done {
+		return nil
+	}
+	seq := p.buf
+	p.buf = nil
+	return seq
+}
+
+// keep returns seq unless it is part of a paste.
+func (p *inputParser) keep(seq []byte) []byte {
+	switch {
+	case seq == nil:
+	case string(seq) == pasteStart:
+		p.pasting = true
+	case string(seq) == pasteEnd:
+		p.pasting = false
+	case !p.pasting:
+		return seq
+	}
+	return nil
+}
+
+// keyAliases are the other sequences terminals send for keys, mapped to
+// the ones key bindings are written in.
+var keyAliases = map[string]string{
+	// application cursor and keypad modes
+	"\x1bOA": "\x1b[A", "\x1bOB": "\x1b[B", "\x1bOC": "\x1b[C", "\x1bOD": "\x1b[D",
+	"\x1bOH": "\x1b[H", "\x1bOF": "\x1b[F", "\x1bOM": "\r",
+	// VT220 and rxvt style
+	"\x1b[1~": "\x1b[H", "\x1b[7~": "\x1b[H", "\x1b[4~": "\x1b[F", "\x1b[8~": "\x1b[F",
+	"\x1b[11~": "\x1bOP", "\x1b[12~": "\x1bOQ", "\x1b[13~": "\x1bOR", "\x1b[14~": "\x1bOS",
+	// the Linux console
+	"\x1b[[A": "\x1bOP", "\x1b[[B": "\x1bOQ", "\x1b[[C": "\x1bOR", "\x1b[[D": "\x1bOS",
+	"\x1b[[E": "\x1b[15~",
+}
+
+func normalizeKey(seq []byte) []byte {
+	if alias, ok := keyAliases[string(seq)]; ok {
+		return []byte(alias)
+	}
+	return seq
+}
+
+// altKey splits an Alt-prefixed key, ESC and the key, reporting whether
+// seq was one.
+func altKey(seq []byte) ([]byte, bool) {
+	if len(seq) == 2 && seq[0] == 0x1b {
+		return seq[1:], true
+	}
+	return seq, false
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestInputParserSplitReads(t *testing.T) {
+	tests := []struct {
+		name  string
+		reads []string
+		want  []string
+	}{
+		{"arrow", []string{"\x1b[A"}, []string{"\x1b[A"}},
+		{"arrow split", []string{"\x1b", "[", "A"}, []string{"\x1b[A"}},
+		{"function key split", []string{"\x1b[2", "0~"}, []string{"\x1b[20~"}},
+		{"alias split", []string{"\x1bO", "A"}, []string{"\x1b[A"}},
+		{"linux console split", []string{"\x1b[[", "A"}, []string{"\x1bOP"}},
+		{"keys around", []string{"a\x1b[", "Bb"}, []string{"a", "\x1b[B", "b"}},
+		{"alt split", []string{"\x1b", "x"}, []string{"\x1bx"}},
+		{"lone escape", []string{"\x1b"}, []string{"\x1b"}},
+		{"escape twice", []string{"\x1b", "\x1b"}, []string{"\x1b", "\x1b"}},
+		{"escape then key", []string{"\x1b\x03"}, []string{"\x1b\x03"}},
+		{"paste split", []string{"\x1b[200~ab", "c\x1b[201", "~d"}, []string{"d"}},
+	}
+	for _, tt := range tests {
+		var p inputParser
+		now := time.Now()
+		var got []string
+		for _, r := range tt.reads {
+			keys := make(chan byte, len(r))
+			for i := range len(r) {
+				keys <- r[i]
+			}
+			for seq := p.next(keys, now); seq != nil; seq = p.next(keys, now) {
+				got = append(got, string(seq))
+			}
+		}
+		// what is left once an escape sequence has had its time
+		for seq := p.next(nil, now.Add(escTimeout)); seq != nil; seq = p.next(nil, now.Add(escTimeout)) {
+			got = append(got, string(seq))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
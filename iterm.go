@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+)
+
+// itermEncoder sends frames as PNGs with iTerm2's OSC 1337 inline images.
+type itermEncoder struct {
+	enc    png.Encoder
+	pngBuf bytes.Buffer
+}
+
+// pngPool lets the PNG encoder reuse its compression state across frames.
+type pngPool struct{ b *png.EncoderBuffer }
+
+func (p *pngPool) Get() *png.EncoderBuffer  { return p.b }
+func (p *pngPool) Put(b *png.EncoderBuffer) { p.b = b }
+
+// encode writes img as an inline image stretched over cols×rows cells.
+func (e *itermEncoder) encode(b *bytes.Buffer, img *image.RGBA, cols, rows int) {
+	if e.enc.BufferPool == nil {
+		e.enc.CompressionLevel = png.BestSpeed
+		e.enc.BufferPool = &pngPool{}
+	}
+	e.pngBuf.Reset()
+	if err := e.enc.Encode(&e.pngBuf, img); err != nil {
+		return
+	}
+	fmt.Fprintf(b, "\x1b]1337;File=inline=1;size=%d;width=%d;height=%d;preserveAspectRatio=0;doNotMoveCursor=1:",
+		e.pngBuf.Len(), cols, rows)
+	enc := base64.NewEncoder(base64.StdEncoding, b)
+	_, _ = enc.Write(e.pngBuf.Bytes())
+	_ = enc.Close()
+	b.WriteByte('\a')
+}
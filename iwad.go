@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// iwadTitles names the games of iwadNames, for the picker.
+var iwadTitles = map[string]string{
+	"doom2.wad":     "DOOM II",
+	"plutonia.wad":  "Final DOOM: The Plutonia Experiment",
+	"tnt.wad":       "Final DOOM: TNT: Evilution",
+	"doom.wad":      "DOOM",
+	"doom1.wad":     "DOOM shareware",
+	"chex.wad":      "Chex Quest",
+	"hacx.wad":      "Hacx",
+	"freedm.wad":    "FreeDM",
+	"freedoom2.wad": "Freedoom: Phase 2",
+	"freedoom1.wad": "Freedoom: Phase 1",
+}
+
+// steamGames are where Steam installs the IWADs, under steamapps/common.
+var steamGames = []string{
+	"Ultimate Doom/base", "Ultimate Doom/rerelease", "Doom 2/base", "Doom 2/finaldoombase",
+	"Final Doom/base", "DOOM 3 BFG Edition/base/wads",
+}
+
+// iwadDirs are where IWADs are looked for when -iwad isn't given: the
+// current directory, as the engine does, then DOOMWADDIR and DOOMWADPATH
+// as other ports take them, the usual install locations and Steam's.
+// An entry may be an IWAD itself rather than a directory.
+func iwadDirs() []string {
+	dirs := []string{"."}
+	if d := os.Getenv("DOOMWADDIR"); d != "" {
+		dirs = append(dirs, d)
+	}
+	if p := os.Getenv("DOOMWADPATH"); p != "" {
+		dirs = append(dirs, filepath.SplitList(p)...)
+	}
+	if d := gameDataDir(); d != "" {
+		dirs = append(dirs, d)
+	}
+	dirs = append(dirs, "/usr/local/share/games/doom", "/usr/share/games/doom", "/usr/local/share/doom", "/usr/share/doom")
+	home, _ := os.UserHomeDir()
+	var steam []string
+	switch runtime.GOOS {
+	case "windows":
+		steam = []string{`C:\Program Files (x86)\Steam`, `C:\Program Files\Steam`}
+	case "darwin":
+		steam = []string{filepath.Join(home, "Library", "Application Support", "Steam")}
+	default:
+		steam = []string{filepath.Join(home, ".steam", "steam"), filepath.Join(home, ".local", "share", "Steam")}
+	}
+	for _, s := range steam {
+		for _, g := range steamGames {
+			dirs = append(dirs, filepath.Join(s, "steamapps", "common", filepath.FromSlash(g)))
+		}
+	}
+	return dirs
+}
+
+// gameDataDir is the user's directory for game data, where IWADs are
+// looked for and the shareware one is downloaded to: games/doom in the
+// XDG data directory.
+func gameDataDir() string {
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		data = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(data, "games", "doom")
+}
+
+// foundIWAD is an IWAD found on the disk.
+type foundIWAD struct {
+	path string
+	name string // as in iwadNames
+}
+
+// findIWADs lists the IWADs in iwadDirs, best first: the engine's order
+// of preference, then the order of the directories.
+func findIWADs() []foundIWAD {
+	var found []foundIWAD
+	seen := make(map[string]bool)
+	add := func(path, name string) {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = path
+		}
+		if !seen[abs] {
+			seen[abs] = true
+			found = append(found, foundIWAD{path: path, name: name})
+		}
+	}
+	for _, dir := range iwadDirs() {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			continue
+		}
+		if !fi.IsDir() {
+			if name := strings.ToLower(filepath.Base(dir)); slices.Contains(iwadNames, name) {
+				add(dir, name)
+			}
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		// names in any case, as Steam's are in capitals
+		for _, e := range entries {
+			if name := strings.ToLower(e.Name()); !e.IsDir() && slices.Contains(iwadNames, name) {
+				add(filepath.Join(dir, e.Name()), name)
+			}
+		}
+	}
+	slices.SortStableFunc(found, func(a, b foundIWAD) int {
+		return slices.Index(iwadNames, a.name) - slices.Index(iwadNames, b.name)
+	})
+	return found
+}
+
+// hasIWAD reports whether args name the IWAD.
+func hasIWAD(args []string) bool {
+	return slices.ContainsFunc(args, func(a string) bool { return strings.EqualFold(a, "-iwad") })
+}
+
+// chooseIWAD adds -iwad to args, unless they have it, for the best IWAD
+// found, or the one picked when several are and we can ask. With none
+// found the shareware one is offered; without that, args are left for the
+// engine to complain about.
+func chooseIWAD(args []string) []string {
+	if hasIWAD(args) {
+		return args
+	}
+	found := findIWADs()
+	asking := term.IsTerminal(int(os.Stdin.Fd()))
+	if len(found) == 0 {
+		if asking {
+			if path := offerShareware(); path != "" {
+				return append(args, "-iwad", path)
+			}
+		}
+		return args
+	}
+	pick := 0
+	if len(found) > 1 && asking {
+		pick = pickIWAD(found)
+	}
+	return append(args, "-iwad", found[pick].path)
+}
+
+// pickIWAD asks which of found to play, the first if just Enter.
+func pickIWAD(found []foundIWAD) int {
+	fmt.Fprintln(os.Stderr, "termdoom: these IWADs were found:")
+	for i, f := range found {
+		fmt.Fprintf(os.Stderr, "  %d) %-36s %s\n", i+1, iwadTitles[f.name], f.path)
+	}
+	for {
+		fmt.Fprintf(os.Stderr, "play which? [1] ")
+		line, err := readLine(os.Stdin)
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return 0
+		}
+		if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(found) {
+			return n - 1
+		}
+		if err != nil {
+			return 0
+		}
+	}
+}
+
+// readLine reads the answer to a question from r a byte at a time, up to
+// the end of its line: a buffer could take keys typed ahead that are for
+// the game.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 && b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[:n]...)
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
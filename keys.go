@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AndreRenaud/gore"
+)
+
+// keymap maps what the terminal sends for a key to the engine's key code.
+type keymap map[string]uint8
+
+// keyNames spells the terminal sequences of keys without a character.
+var keyNames = map[string]string{
+	"up": "\x1b[A", "down": "\x1b[B", "right": "\x1b[C", "left": "\x1b[D",
+	"space": " ", "enter": "\r", "tab": "\t", "escape": "\x1b", "backspace": "\x7f",
+	"home": "\x1b[H", "end": "\x1b[F", "insert": "\x1b[2~", "delete": "\x1b[3~",
+	"pgup": "\x1b[5~", "pgdn": "\x1b[6~",
+	"f1": "\x1bOP", "f2": "\x1bOQ", "f3": "\x1bOR", "f4": "\x1bOS",
+	"f5": "\x1b[15~", "f6": "\x1b[17~", "f7": "\x1b[18~", "f8": "\x1b[19~",
+	"f9": "\x1b[20~", "f10": "\x1b[21~", "f11": "\x1b[23~", "f12": "\x1b[24~",
+	"equals": "=", "minus": "-", "comma": ",", "hash": "#", "semicolon": ";",
+	"capslock": "\ue00e", // only with the kitty protocol
+}
+
+// Engine keys the frontend uses itself, and autorun, which toggles always
+// run here instead of reaching the engine: 0 is no key to it.
+const (
+	keyRun     = 0x80 + 0x36 // right shift
+	keyAutorun = 0
+	// quicksave and quickload, F6 and F9 to the engine, which we drive
+	// its menus for
+	keyQuicksave = 0x80 + 0x40
+	keyQuickload = 0x80 + 0x43
+	// the settings menu, screenshots, the stats overlay and the minimap,
+	// on codes the engine has no key for
+	keySettings   = 0xe0
+	keyScreenshot = 0xe1
+	keyOverlay    = 0xe2
+	keyMinimap    = 0xe3
+)
+
+// frontendKeys are the engine keys frontendKey takes for itself.
+var frontendKeys = []uint8{keyAutorun, keyQuicksave, keyQuickload, keySettings, keyScreenshot, keyOverlay, keyMinimap}
+
+// gameActions names the engine's keys. Letters and digits bind as
+// themselves, which is what cheat codes are typed with.
+var gameActions = map[string]uint8{
+	"forward": gore.KEY_UPARROW1, "back": gore.KEY_DOWNARROW1,
+	"turnleft": gore.KEY_LEFTARROW1, "turnright": gore.KEY_RIGHTARROW1,
+	"strafeleft": gore.KEY_STRAFE_L1, "straferight": gore.KEY_STRAFE_R1,
+	"fire": gore.KEY_FIRE1, "use": gore.KEY_USE1,
+	"run": keyRun, "strafe": 0x80 + 0x38, "autorun": keyAutorun,
+	"map": gore.KEY_TAB, "menu": gore.KEY_ESCAPE, "enter": gore.KEY_ENTER,
+	"backspace": gore.KEY_BACKSPACE3, "pause": gore.KEY_PAUSE1,
+	"bigger": gore.KEY_EQUALS1, "smaller": gore.KEY_MINUS1,
+	"yes": 'y', "no": 'n',
+	"help": 0x80 + 0x3b, "save": 0x80 + 0x3c, "load": 0x80 + 0x3d, "volume": 0x80 + 0x3e,
+	"detail": 0x80 + 0x3f, "quicksave": keyQuicksave, "endgame": 0x80 + 0x41,
+	"messages": 0x80 + 0x42, "quickload": keyQuickload, "quit": 0x80 + 0x44,
+	"gamma": 0x80 + 0x57, "spy": 0x80 + 0x58,
+	"settings": keySettings, "screenshot": keyScreenshot, "overlay": keyOverlay, "minimap": keyMinimap,
+}
+
+// defaultKeys are the bindings before the config's [keys] section and
+// --bind, with the --keys preset on top: arrows to move, space or E to
+// use, comma or Ctrl to fire, Shift to run, Alt to strafe, Caps Lock to
+// toggle always run, F6 to quicksave and F9 to quickload, as in DOOM, F8
+// for the settings menu in place of DOOM's messages key, F10 for the
+// minimap, F11 the stats overlay and F12 to take a screenshot. Alt with a key works in most terminals, sent as ESC and
+// the key; the other modifiers need a keyboard protocol.
+// Gamepads move with the left stick or d-pad and turn with the right
+// stick; A uses, B selects in menus, the triggers fire and run. The
+// mouse turns, fires with the left button and uses with the right.
+var defaultKeys = [][2]string{
+	{"up", "forward"}, {"down", "back"}, {"left", "turnleft"}, {"right", "turnright"},
+	{"space", "use"}, {"e", "use"}, {"f1", "use"}, {",", "fire"},
+	{"enter", "enter"}, {"\n", "enter"}, {"escape", "menu"}, {"tab", "map"},
+	{"y", "yes"}, {"n", "no"},
+	{"shift", "run"}, {"rshift", "run"}, {"ctrl", "fire"}, {"rctrl", "fire"},
+	{"alt", "strafe"}, {"ralt", "strafe"}, {"capslock", "autorun"},
+	{"f6", "quicksave"}, {"f9", "quickload"},
+	{"f8", "settings"}, {"f10", "minimap"}, {"f11", "overlay"}, {"f12", "screenshot"},
+	{"pad-lstick-up", "forward"}, {"pad-lstick-down", "back"},
+	{"pad-lstick-left", "strafeleft"}, {"pad-lstick-right", "straferight"},
+	{"pad-up", "forward"}, {"pad-down", "back"}, {"pad-left", "turnleft"}, {"pad-right", "turnright"},
+	{"pad-a", "use"}, {"pad-b", "enter"}, {"pad-x", "strafe"}, {"pad-y", "map"},
+	{"pad-rt", "fire"}, {"pad-rb", "fire"}, {"pad-lt", "run"}, {"pad-lb", "run"},
+	{"pad-start", "menu"}, {"pad-back", "pause"},
+	{"mouse-left", "fire"}, {"mouse-right", "use"}, {"mouse-middle", "forward"},
+}
+
+// keyPresets are the --keys choices of letters to move with, laid out
+// like WASD on the keyboard they are named for, or vi's HJKL.
+var keyPresets = map[string][][2]string{
+	"arrows": nil,
+	"wasd":   {{"w", "forward"}, {"s", "back"}, {"a", "strafeleft"}, {"d", "straferight"}},
+	"vim":    {{"k", "forward"}, {"j", "back"}, {"h", "turnleft"}, {"l", "turnright"}},
+	"azerty": {{"z", "forward"}, {"s", "back"}, {"q", "strafeleft"}, {"d", "straferight"}},
+	// comma and E move here, so fire and use go where they are on QWERTY
+	"dvorak": {{",", "forward"}, {"o", "back"}, {"a", "strafeleft"}, {"e", "straferight"},
+		{".", "use"}, {"w", "fire"}},
+}
+
+var keyPresetNames = []string{"arrows", "wasd", "vim", "azerty", "dvorak"}
+
+func init() {
+	for _, m := range modifierKeys {
+		keyNames[m.name] = string(m.code)
+	}
+}
+
+func defaultKeymap(preset string) keymap {
+	k := make(keymap)
+	for _, b := range append(slices.Clone(defaultKeys), keyPresets[preset]...) {
+		if err := k.bind(b[0], b[1]); err != nil {
+			panic(err)
+		}
+	}
+	for d := '0'; d <= '9'; d++ {
+		k[string(d)] = uint8(d)
+	}
+	return k
+}
+
+// bind maps key, a key name, a single character or an escape sequence
+// written with \e, to action: an action name, a character, a key code,
+// or "none" to unbind it.
+func (k keymap) bind(key, action string) error {
+	seq, ok := keyNames[strings.ToLower(key)]
+	switch {
+	case ok:
+	case strings.HasPrefix(key, `\e`):
+		seq = "\x1b" + key[2:]
+	case len(key) == 1:
+		seq = key
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	}
+	if strings.ToLower(action) == "none" {
+		delete(k, seq)
+		return nil
+	}
+	code, err := parseAction(action)
+	if err != nil {
+		return err
+	}
+	k[seq] = code
+	return nil
+}
+
+// parseAction returns the engine key for an action name, a character, or
+// a key code.
+func parseAction(action string) (uint8, error) {
+	action = strings.ToLower(action)
+	if code, ok := gameActions[action]; ok {
+		return code, nil
+	}
+	if len(action) == 1 {
+		return action[0], nil
+	}
+	if n, err := strconv.Atoi(action); err == nil && n > 0 && n < 256 {
+		return uint8(n), nil
+	}
+	return 0, fmt.Errorf("unknown action %q (one of %s, a character, or a key code)", action, actionList())
+}
+
+// actionName spells an engine key the way parseAction reads it back.
+func actionName(code uint8) string {
+	name := ""
+	for n, c := range gameActions {
+		if c == code && (name == "" || n < name) {
+			name = n
+		}
+	}
+	switch {
+	case name != "":
+		return name
+	case code > ' ' && code <= '~':
+		return string(rune(code))
+	}
+	return strconv.Itoa(int(code))
+}
+
+// lookup returns the engine key for seq. Shifted letters fall back to
+// their lowercase binding.
+func (k keymap) lookup(seq []byte) (uint8, bool) {
+	if code, ok := k[string(seq)]; ok {
+		return code, true
+	}
+	if len(seq) == 1 && seq[0] >= 'A' && seq[0] <= 'Z' {
+		code, ok := k[string(seq[0]-'A'+'a')]
+		return code, ok
+	}
+	return 0, false
+}
+
+// typedChar returns the engine key of a printable character, which is
+// the character itself, lowercase as the engine's keys are.
+func typedChar(seq []byte) (uint8, bool) {
+	if len(seq) != 1 || seq[0] < ' ' || seq[0] > '~' {
+		return 0, false
+	}
+	c := seq[0]
+	if c >= 'A' && c <= 'Z' {
+		c += 'a' - 'A'
+	}
+	return c, true
+}
+
+// cheatCodes are the engine's cheats, with the choices idbehold takes;
+// idclev and idmus go on with digits, which are keys of their own.
+var cheatCodes = []string{
+	"iddqd", "idkfa", "idfa", "idspispopd", "idclip", "idchoppers", "idmypos", "idclev", "idmus",
+	"idbeholdv", "idbeholds", "idbeholdi", "idbeholdr", "idbeholda", "idbeholdl",
+}
+
+// cheatTyping is how much of a cheat code was just typed.
+type cheatTyping string
+
+// add follows on with c, reporting whether it continues a cheat code past
+// its first letter.
+func (ct *cheatTyping) add(c byte) bool {
+	s := string(*ct) + string(c)
+	for s != "" && !slices.ContainsFunc(cheatCodes, func(code string) bool { return strings.HasPrefix(code, s) }) {
+		s = s[1:]
+	}
+	*ct = cheatTyping(s)
+	return len(s) > 1
+}
+
+func actionList() string {
+	names := make([]string, 0, len(gameActions))
+	for n := range gameActions {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// bindings collects repeated --bind key=action flags.
+type bindings []string
+
+func (b *bindings) String() string { return strings.Join(*b, " ") }
+
+func (b *bindings) Set(s string) error {
+	if !strings.Contains(s, "=") {
+		return fmt.Errorf("%q is not key=action", s)
+	}
+	*b = append(*b, s)
+	return nil
+}
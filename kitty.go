@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"io"
+)
+
+// kittyChunk is the largest base64 payload the protocol allows per escape.
+const kittyChunk = 4096
+
+// kittyEncoder sends frames with the kitty graphics protocol. Every frame
+// reuses the same image and placement id so the terminal replaces the
+// picture in place instead of stacking new ones.
+type kittyEncoder struct {
+	z   bytes.Buffer
+	zw  *zlib.Writer
+	b64 []byte
+}
+
+// encode transmits img as zlib-compressed RGBA, scaled by the terminal to
+// cover cols×rows cells at the cursor.
+func (k *kittyEncoder) encode(b *bytes.Buffer, img *image.RGBA, cols, rows int) {
+	k.z.Reset()
+	if k.zw == nil {
+		k.zw, _ = zlib.NewWriterLevel(&k.z, zlib.BestSpeed)
+	} else {
+		k.zw.Reset(&k.z)
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < h; y++ {
+		o := y * img.Stride
+		_, _ = k.zw.Write(img.Pix[o : o+w*4])
+	}
+	_ = k.zw.Close()
+
+	n := base64.StdEncoding.EncodedLen(k.z.Len())
+	if cap(k.b64) < n {
+		k.b64 = make([]byte, n)
+	}
+	data := k.b64[:n]
+	base64.StdEncoding.Encode(data, k.z.Bytes())
+
+	// q=2 keeps the terminal from answering on stdin, C=1 keeps the cursor put
+	fmt.Fprintf(b, "\x1b_Ga=T,f=32,o=z,s=%d,v=%d,i=1,p=1,c=%d,r=%d,C=1,q=2,", w, h, cols, rows)
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > kittyChunk {
+			chunk = chunk[:kittyChunk]
+		}
+		data = data[len(chunk):]
+		if len(data) > 0 {
+			b.WriteString("m=1;")
+		} else {
+			b.WriteString("m=0;")
+		}
+		b.Write(chunk)
+		b.WriteString("\x1b\\")
+		if len(data) > 0 {
+			b.WriteString("\x1b_G")
+		}
+	}
+}
+
+// kittyCleanup deletes every image we placed.
+func kittyCleanup(w io.Writer) {
+	_, _ = io.WriteString(w, "\x1b_Ga=d,d=A,q=2\x1b\\")
+}
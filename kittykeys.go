@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// kittyKeyFlags are the kitty keyboard protocol enhancements we ask for:
+// disambiguated escape codes (1), press, repeat and release events (2)
+// and escape codes for every key, so letters get releases too (8).
+const kittyKeyFlags = 1 | 2 | 8
+
+// Key event types in the kitty keyboard protocol.
+const (
+	keyPress   = 1
+	keyRepeat  = 2
+	keyRelease = 3
+)
+
+// Modifier bits, as both keyboard protocols encode them less one.
+const (
+	modShift = 1
+	modAlt   = 2
+	modCtrl  = 4
+)
+
+// kittyKey decodes a kitty keyboard protocol key event into the sequence
+// the same key sends without the protocol, which is what key bindings are
+// written in, the event type and the modifiers held. ok is false for
+// anything else. It also reads xterm's CSI u and modified cursor and
+// function key reports, which are the same without event types.
+func kittyKey(seq []byte) (key []byte, event, mods int, ok bool) {
+	s := string(seq)
+	if len(s) < 3 || !strings.HasPrefix(s, "\x1b[") {
+		return nil, 0, 0, false
+	}
+	final := s[len(s)-1]
+	fields := strings.Split(s[2:len(s)-1], ";")
+	code, _, _ := strings.Cut(fields[0], ":")
+	event = keyPress
+	if len(fields) > 1 {
+		m, e, found := strings.Cut(fields[1], ":")
+		if n, err := strconv.Atoi(m); err == nil && n > 0 {
+			mods = n - 1
+		}
+		if found {
+			if event, _ = strconv.Atoi(e); event < keyPress || event > keyRelease {
+				return nil, 0, 0, false
+			}
+		}
+	}
+	key, ok = legacyKey(code, final)
+	return key, event, mods, ok
+}
+
+// otherKey decodes xterm's modifyOtherKeys report, CSI 27 ; mods ; code ~,
+// and the reports kittyKey reads, into the unmodified key and modifiers.
+func otherKey(seq []byte) (key []byte, mods int, ok bool) {
+	s := string(seq)
+	if rest, found := strings.CutPrefix(s, "\x1b[27;"); found && strings.HasSuffix(rest, "~") {
+		m, code, _ := strings.Cut(strings.TrimSuffix(rest, "~"), ";")
+		n, err := strconv.Atoi(m)
+		if err != nil || n < 1 {
+			return nil, 0, false
+		}
+		key, ok = legacyKey(code, 'u')
+		return key, n - 1, ok
+	}
+	key, _, mods, ok = kittyKey(seq)
+	return key, mods, ok
+}
+
+// legacyKey is the sequence a key sends without any keyboard protocol,
+// given its number and the final byte of its report.
+func legacyKey(code string, final byte) ([]byte, bool) {
+	switch final {
+	case 'u':
+		n, err := strconv.Atoi(code)
+		if err != nil {
+			return nil, false
+		}
+		switch n {
+		case 13:
+			return []byte("\r"), true
+		case 9:
+			return []byte("\t"), true
+		case 27:
+			return []byte("\x1b"), true
+		case 127:
+			return []byte("\x7f"), true
+		}
+		return []byte(string(rune(n))), true
+	case '~':
+		return []byte("\x1b[" + code + "~"), true
+	case 'A', 'B', 'C', 'D', 'F', 'H':
+		return []byte{0x1b, '[', final}, true
+	case 'P', 'Q', 'R', 'S':
+		return []byte{0x1b, 'O', final}, true
+	}
+	return nil, false
+}
+
+// modifierKeys are the kitty protocol's codes for the modifier keys
+// themselves, which it reports like any other key. Under modifyOtherKeys
+// the modifiers held with a key are turned into presses of the left ones,
+// so both protocols bind them the same way.
+var modifierKeys = []struct {
+	name string
+	code rune
+	mod  int
+}{
+	{"shift", 57441, modShift}, {"ctrl", 57442, modCtrl}, {"alt", 57443, modAlt},
+	{"rshift", 57447, modShift}, {"rctrl", 57448, modCtrl}, {"ralt", 57449, modAlt},
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// launcherSection is the config section the launcher remembers the last
+// game picked in.
+const launcherSection = "launcher"
+
+// skillNames are the engine's skills, -skill 1 to 5, as its menu has them.
+var skillNames = []string{"I'm too young to die", "Hey, not too rough", "Hurt me plenty", "Ultra-Violence", "Nightmare!"}
+
+// launcher is --launcher's menu, shown before the game to pick what the
+// engine would otherwise need arguments for: the IWAD, PWADs or a mod
+// profile, skill and map, and the renderer and colors to draw it with.
+type launcher struct {
+	sel   int
+	items []launchItem
+	// maps are each IWAD's maps, read as it is picked
+	maps map[string][]string
+}
+
+// launchItem is a choice the launcher cycles through values of, saved as
+// key in launcherSection and shown as names.
+type launchItem struct {
+	key, label string
+	values     []string
+	names      []string
+	cur        int
+}
+
+// launch runs the launcher on the terminal and returns flagArgs with what
+// was picked added, to be parsed again. Quitting it quits termdoom.
+func launch(opts options, flagArgs []string) []string {
+	found := findIWADs()
+	// an -iwad given is the one to start on
+	var given string
+	if i := slices.IndexFunc(flagArgs, func(a string) bool { return strings.EqualFold(a, "-iwad") }); i >= 0 && i+1 < len(flagArgs) {
+		given = flagArgs[i+1]
+		found = slices.DeleteFunc(found, func(f foundIWAD) bool { return sameFile(f.path, given) })
+		found = append([]foundIWAD{{path: given, name: strings.ToLower(filepath.Base(given))}}, found...)
+	}
+	if len(found) == 0 {
+		if args := chooseIWAD(nil); hasIWAD(args) {
+			found = []foundIWAD{{path: args[1], name: strings.ToLower(filepath.Base(args[1]))}}
+		}
+	}
+	if len(found) == 0 {
+		// for the engine to complain about
+		return flagArgs
+	}
+	cfg, err := loadConfig(opts.config)
+	if err != nil {
+		cfg = &config{path: opts.config}
+	}
+	last := make(map[string]string)
+	for _, e := range cfg.sections[launcherSection] {
+		last[e.key] = e.value
+	}
+	pick := func(key, cur string) string {
+		if v, ok := last[key]; ok {
+			return v
+		}
+		return cur
+	}
+
+	l := &launcher{maps: make(map[string][]string)}
+	var iwads, titles []string
+	for _, f := range found {
+		iwads = append(iwads, f.path)
+		title := iwadTitles[f.name]
+		if title == "" {
+			title = filepath.Base(f.path)
+		}
+		titles = append(titles, title)
+	}
+	iwad := pick("iwad", "")
+	if given != "" {
+		iwad = given
+	}
+	l.add("iwad", "Game", iwads, titles, iwad)
+	pwads, pwadNames := []string{""}, []string{"none"}
+	var mods []string
+	for s := range cfg.sections {
+		if name, ok := strings.CutPrefix(s, "mod."); ok {
+			mods = append(mods, name)
+		}
+	}
+	sort.Strings(mods)
+	for _, m := range mods {
+		pwads, pwadNames = append(pwads, "mod:"+m), append(pwadNames, m+" (mod)")
+	}
+	for _, p := range findPWADs(opts.config) {
+		pwads, pwadNames = append(pwads, p), append(pwadNames, filepath.Base(p))
+	}
+	l.add("pwad", "PWADs", pwads, pwadNames, pick("pwad", ""))
+	var skills []string
+	for i := range skillNames {
+		skills = append(skills, strconv.Itoa(i+1))
+	}
+	l.add("skill", "Skill", skills, skillNames, pick("skill", "3"))
+	l.add("map", "Map", nil, nil, "")
+	l.setMaps(pick("map", ""))
+	l.add("renderer", "Renderer", append([]string{"auto"}, rendererNames...), nil, pick("renderer", opts.renderer))
+	l.add("colors", "Colors", []string{"auto", "truecolor", "256", "16", "none"}, nil, pick("colors", opts.colors))
+
+	if !l.run() {
+		os.Exit(0)
+	}
+	var save []configEntry
+	for _, it := range l.items {
+		save = append(save, configEntry{key: it.key, value: it.values[it.cur]})
+	}
+	if err := updateConfig(opts.config, launcherSection, save); err != nil {
+		fmt.Fprintf(os.Stderr, "termdoom: launcher: not saved: %v\n", err)
+	}
+	return l.args(flagArgs)
+}
+
+// add adds an item showing cur, or the first value if cur isn't one.
+// names are the values' own without any.
+func (l *launcher) add(key, label string, values, names []string, cur string) {
+	if names == nil {
+		names = values
+	}
+	l.items = append(l.items, launchItem{key: key, label: label, values: values, names: names, cur: max(slices.Index(values, cur), 0)})
+}
+
+func (l *launcher) item(key string) *launchItem {
+	for i := range l.items {
+		if l.items[i].key == key {
+			return &l.items[i]
+		}
+	}
+	return nil
+}
+
+func (l *launcher) value(key string) string {
+	it := l.item(key)
+	return it.values[it.cur]
+}
+
+// setMaps offers the maps of the IWAD picked, on cur if it has it.
+func (l *launcher) setMaps(cur string) {
+	iwad := l.value("iwad")
+	maps, ok := l.maps[iwad]
+	if !ok {
+		lumps, _ := readWAD(iwad)
+		for _, lump := range lumps {
+			if _, err := warpArgs(lump.name); err == nil {
+				maps = append(maps, strings.ToUpper(lump.name))
+			}
+		}
+		l.maps[iwad] = maps
+	}
+	it := l.item("map")
+	it.values, it.names = maps, maps
+	if len(maps) == 0 {
+		// the engine's first, whatever it is
+		it.values, it.names = []string{""}, []string{"first"}
+	}
+	it.cur = max(slices.Index(it.values, cur), 0)
+}
+
+// run shows the launcher until a game is picked, or it is quit.
+func (l *launcher) run() bool {
+	fd := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "terminal raw mode:", err)
+		os.Exit(1)
+	}
+	restoreVT, _ := enableVT(os.Stdout)
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Print("\x1b[0m\x1b[2J\x1b[H\x1b[?25h\x1b[?1049l")
+		restoreVT()
+		term.Restore(fd, oldState)
+	}()
+	buf := make([]byte, 64)
+	for {
+		l.draw(os.Stdout)
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return false
+		}
+		step := 0
+		switch string(buf[:n]) {
+		case "\x1b[A", "k":
+			l.sel = (l.sel + len(l.items) - 1) % len(l.items)
+		case "\x1b[B", "j":
+			l.sel = (l.sel + 1) % len(l.items)
+		case "\x1b[D", "h":
+			step = -1
+		case "\x1b[C", "l", " ":
+			step = 1
+		case "\r", "\n":
+			return true
+		case "q", "\x1b", "\x03":
+			return false
+		}
+		if step == 0 {
+			continue
+		}
+		it := &l.items[l.sel]
+		it.cur = (it.cur + step + len(it.values)) % len(it.values)
+		if it.key == "iwad" {
+			l.setMaps(l.value("map"))
+		}
+	}
+}
+
+// lines lays the launcher out as the settings menu is.
+func (l *launcher) lines() []panelLine {
+	lines := []panelLine{{text: "termdoom", dim: true}, {}}
+	for i, it := range l.items {
+		lines = append(lines, panelLine{text: fmt.Sprintf("%-9s ◀ %s ▶", it.label, it.names[it.cur]), selected: i == l.sel})
+	}
+	lines = append(lines, panelLine{}, panelLine{text: l.value("iwad"), dim: true})
+	return append(lines, panelLine{}, panelLine{text: "↑↓ choose  ←→ change  Enter play  q quit", dim: true})
+}
+
+func (l *launcher) draw(w io.Writer) {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	for i, line := range l.lines() {
+		fmt.Fprintf(&b, "\x1b[%d;3H", i+2)
+		switch {
+		case line.selected:
+			b.WriteString("\x1b[7m")
+		case line.dim:
+			b.WriteString("\x1b[2m")
+		}
+		b.WriteString(line.text)
+		b.WriteString("\x1b[0m")
+	}
+	io.WriteString(w, b.String())
+}
+
+// args are flagArgs with the launcher's picks in place of any engine
+// arguments for the same, and after any flags for the same, so they win.
+func (l *launcher) args(flagArgs []string) []string {
+	out := withoutEngineArgs(flagArgs, "-iwad", "-skill", "-warp")
+	out = append(out, "--renderer="+l.value("renderer"), "--colors="+l.value("colors"))
+	switch pwad := l.value("pwad"); {
+	case strings.HasPrefix(pwad, "mod:"):
+		out = append(out, "--mod="+strings.TrimPrefix(pwad, "mod:"))
+	case pwad != "":
+		out = append(out, "--file="+pwad)
+	}
+	out = append(out, "-iwad", l.value("iwad"), "-skill", l.value("skill"))
+	if warp, err := warpArgs(l.value("map")); err == nil {
+		out = append(out, warp...)
+	}
+	return out
+}
+
+// withoutEngineArgs is args without the engine arguments named and their
+// values.
+func withoutEngineArgs(args []string, names ...string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		if !slices.ContainsFunc(names, func(n string) bool { return strings.EqualFold(args[i], n) }) {
+			out = append(out, args[i])
+			continue
+		}
+		for i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			i++
+		}
+	}
+	return out
+}
+
+// findPWADs lists the PWADs in a wads directory next to the config file
+// and where IWADs are looked for.
+func findPWADs(config string) []string {
+	dirs := iwadDirs()
+	if config != "" {
+		dirs = append([]string{filepath.Join(filepath.Dir(config), "wads")}, dirs...)
+	}
+	var found []string
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			path := filepath.Join(dir, e.Name())
+			if e.IsDir() || !strings.EqualFold(filepath.Ext(path), ".wad") {
+				continue
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				abs = path
+			}
+			if !seen[abs] && isPWAD(path) {
+				seen[abs] = true
+				found = append(found, path)
+			}
+		}
+	}
+	return found
+}
+
+// sameFile reports whether paths a and b are the same file.
+func sameFile(a, b string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	return err == nil && os.SameFile(fa, fb)
+}
+
+// isPWAD reports whether path is a WAD with lumps that isn't an IWAD.
+func isPWAD(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var h [4]byte
+	_, err = io.ReadFull(f, h[:])
+	return err == nil && string(h[:]) == "PWAD" && checkWAD(path) == nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// convBuckets are the upper bounds, in seconds, of the frame conversion
+// histogram: a frame has 28ms at 35 fps.
+var convBuckets = [...]float64{0.0005, 0.001, 0.002, 0.005, 0.01, 0.02, 0.05, 0.1}
+
+// metrics counts what the frontend does for --metrics-listen, served at
+// /metrics in Prometheus's text format. Rates, such as bytes written a
+// second, are the counters' rate() in Prometheus.
+type metrics struct {
+	frames  atomic.Uint64
+	dropped atomic.Uint64
+	events  atomic.Uint64
+	written writeCounter
+	fps     atomic.Uint64 // float64 bits
+
+	mu sync.Mutex // guards the histogram
+	// counts has a count per bucket, not cumulative, and one for above
+	// the last
+	counts  [len(convBuckets) + 1]uint64
+	convSum float64
+}
+
+// writeCounter counts the bytes written to it.
+type writeCounter struct{ atomic.Uint64 }
+
+func (w *writeCounter) Write(b []byte) (int, error) {
+	w.Add(uint64(len(b)))
+	return len(b), nil
+}
+
+func listenMetrics(addr string) (*metrics, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	m := &metrics{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.serve)
+	go http.Serve(l, mux)
+	return m, nil
+}
+
+// frame records a drawn frame that took conv to convert, with the frame
+// rate as of it.
+func (m *metrics) frame(conv time.Duration, fps float64) {
+	m.frames.Add(1)
+	m.fps.Store(math.Float64bits(fps))
+	s := conv.Seconds()
+	i := 0
+	for i < len(convBuckets) && s > convBuckets[i] {
+		i++
+	}
+	m.mu.Lock()
+	m.counts[i]++
+	m.convSum += s
+	m.mu.Unlock()
+}
+
+func (m *metrics) serve(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	metric := func(name, kind, help string, v any) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, v)
+	}
+	metric("termdoom_frames_total", "counter", "Frames drawn.", m.frames.Load())
+	metric("termdoom_frames_dropped_total", "counter", "Frames the engine drew that were dropped to keep to the frame rate cap or a slow terminal.", m.dropped.Load())
+	metric("termdoom_fps", "gauge", "Frames drawn a second, over the last second.", math.Float64frombits(m.fps.Load()))
+	metric("termdoom_written_bytes_total", "counter", "Bytes written to the terminal.", m.written.Load())
+	metric("termdoom_input_events_total", "counter", "Input events handed to the engine.", m.events.Load())
+	metric("termdoom_goroutines", "gauge", "Goroutines running.", runtime.NumGoroutine())
+
+	m.mu.Lock()
+	counts, sum := m.counts, m.convSum
+	m.mu.Unlock()
+	const conv = "termdoom_frame_conversion_seconds"
+	fmt.Fprintf(&b, "# HELP %s Time taken to convert a frame for the terminal.\n# TYPE %s histogram\n", conv, conv)
+	var n uint64
+	for i, le := range convBuckets {
+		n += counts[i]
+		fmt.Fprintf(&b, "%s_bucket{le=\"%g\"} %d\n", conv, le, n)
+	}
+	n += counts[len(convBuckets)]
+	fmt.Fprintf(&b, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", conv, n, conv, sum, conv, n)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+)
+
+// minimapCorners are where the minimap can go.
+var minimapCorners = map[string]bool{
+	"top-left": true, "top-right": true, "bottom-left": true, "bottom-right": true,
+}
+
+// minimap keeps a small copy of the automap in a corner of the picture.
+// The frontend only ever sees the automap the engine draws, so the copy
+// is of the map as it was when last opened: the layout explored so far,
+// centered where the player was.
+type minimap struct {
+	on     bool
+	view   *automapView
+	corner string
+	// g holds the traced map inside a border, valid once one was seen
+	g     grid
+	valid bool
+}
+
+var minimapBorder = color.RGBA{110, 110, 110, 255}
+
+// parseMinimapSize reads a "WxH" size in cells.
+func parseMinimapSize(s string) (w, h int, err error) {
+	if _, err := fmt.Sscanf(strings.ToLower(s), "%dx%d", &w, &h); err != nil {
+		return 0, 0, fmt.Errorf("%q is not WxH", s)
+	}
+	if w < 8 || h < 4 {
+		return 0, 0, fmt.Errorf("%q is smaller than 8x4", s)
+	}
+	return w, h, nil
+}
+
+func newMinimap(view *automapView, w, h int, corner string) *minimap {
+	m := &minimap{view: view, corner: corner}
+	m.g.resize(w, h)
+	return m
+}
+
+// capture copies the middle of the automap in img, which follows the
+// player, at the minimap's scale: half the map's width across.
+func (m *minimap) capture(img *image.RGBA, bg color.RGBA) {
+	iw, ih := m.g.w-2, m.g.h-2
+	sw := 160
+	// the same shape as the cells it goes into; pixels are 1.2 times
+	// taller than wide and terminal cells about twice
+	sh := min(sw*ih*2*10/(iw*12), m.view.titleY)
+	cx, cy := img.Rect.Min.X+160, img.Rect.Min.Y+m.view.titleY/2
+	src := image.Rect(cx-sw/2, cy-sh/2, cx+sw/2, cy+sh/2).Intersect(img.Rect)
+
+	m.g.fill(cell{ch: ' ', bg: overlayBG})
+	inner := grid{}
+	inner.resize(iw, ih)
+	m.view.draw(&inner, img.SubImage(src).(*image.RGBA), bg)
+	m.g.blit(&inner, 1, 1)
+	for x := 0; x < m.g.w; x++ {
+		m.g.set(x, 0, cell{ch: '─', fg: minimapBorder, bg: overlayBG})
+		m.g.set(x, m.g.h-1, cell{ch: '─', fg: minimapBorder, bg: overlayBG})
+	}
+	for y := 0; y < m.g.h; y++ {
+		m.g.set(0, y, cell{ch: '│', fg: minimapBorder, bg: overlayBG})
+		m.g.set(m.g.w-1, y, cell{ch: '│', fg: minimapBorder, bg: overlayBG})
+	}
+	m.g.set(0, 0, cell{ch: '┌', fg: minimapBorder, bg: overlayBG})
+	m.g.set(m.g.w-1, 0, cell{ch: '┐', fg: minimapBorder, bg: overlayBG})
+	m.g.set(0, m.g.h-1, cell{ch: '└', fg: minimapBorder, bg: overlayBG})
+	m.g.set(m.g.w-1, m.g.h-1, cell{ch: '┘', fg: minimapBorder, bg: overlayBG})
+	m.valid = true
+}
+
+// draw puts the minimap in its corner of the picture, which is the
+// cols×rows area of g at (x0, y0), if it is on and fits.
+func (m *minimap) draw(g *grid, x0, y0, cols, rows int) {
+	if !m.on || !m.valid || m.g.w > cols || m.g.h > rows {
+		return
+	}
+	x, y := x0, y0
+	if strings.HasSuffix(m.corner, "right") {
+		x = x0 + cols - m.g.w
+	}
+	if strings.HasPrefix(m.corner, "bottom") {
+		y = y0 + rows - m.g.h
+	}
+	g.blit(&m.g, x, y)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// pathList is a flag taking any number of files, in order: repeated,
+// comma-separated, or following it on the command line, as in
+// --file a.wad b.wad.
+type pathList []string
+
+func (p *pathList) String() string { return strings.Join(*p, ",") }
+
+func (p *pathList) Set(s string) error {
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			*p = append(*p, f)
+		}
+	}
+	return nil
+}
+
+// findModFile finds a WAD or lump named by --file or a mod profile: as
+// given, or for a bare name that isn't here, in the directories IWADs are
+// looked for in, DOOMWADDIR and so on.
+func findModFile(name string) (string, error) {
+	fi, err := os.Stat(name)
+	if err == nil && fi.IsDir() {
+		return "", fmt.Errorf("%s is a directory", name)
+	}
+	if err == nil {
+		return name, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if filepath.Base(name) == name {
+		// iwadDirs()[0] is the current directory
+		for _, dir := range iwadDirs()[1:] {
+			p := filepath.Join(dir, name)
+			if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+				return p, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%s: no such file", name)
+}
+
+// checkWAD reads the header of a .wad, for an error here rather than the
+// engine's crash on a broken or empty one. Other files are left alone:
+// the engine loads each of them as a single lump.
+func checkWAD(path string) error {
+	if !strings.EqualFold(filepath.Ext(path), ".wad") {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var h [12]byte
+	if _, err := io.ReadFull(f, h[:]); err != nil || (string(h[:4]) != "IWAD" && string(h[:4]) != "PWAD") {
+		return fmt.Errorf("%s: not a WAD file", path)
+	}
+	if binary.LittleEndian.Uint32(h[4:]) == 0 {
+		return fmt.Errorf("%s: no lumps in it", path)
+	}
+	return nil
+}
+
+// addEngineFiles adds paths to args' -file, after any the engine arguments
+// already give it, or as a new -file at the end. The engine reads only
+// the first -file, up to the next dash argument.
+func addEngineFiles(args []string, paths []string) []string {
+	if len(paths) == 0 {
+		return args
+	}
+	for i, a := range args {
+		if !strings.EqualFold(a, "-file") {
+			continue
+		}
+		end := i + 1
+		for end < len(args) && !strings.HasPrefix(args[end], "-") {
+			end++
+		}
+		out := append([]string{}, args[:end]...)
+		out = append(out, paths...)
+		return append(out, args[end:]...)
+	}
+	return append(append(args, "-file"), paths...)
+}
+
+// engineFiles takes -file and its files out of args, for hostFS to add
+// to the IWAD instead: the engine's own -file crashes on whatever it
+// loads. As in DOOM, the shareware IWAD takes none.
+func engineFiles(args []string) (rest, files []string, err error) {
+	for i := 0; i < len(args); i++ {
+		if !strings.EqualFold(args[i], "-file") {
+			rest = append(rest, args[i])
+			continue
+		}
+		for i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			i++
+			files = append(files, args[i])
+		}
+	}
+	if i := slices.IndexFunc(rest, func(a string) bool { return strings.EqualFold(a, "-iwad") }); files != nil && i >= 0 && i+1 < len(rest) {
+		if strings.EqualFold(filepath.Base(rest[i+1]), "doom1.wad") {
+			return nil, nil, fmt.Errorf("the shareware IWAD can't load other WADs or lumps; that takes the full game")
+		}
+	}
+	return rest, files, nil
+}
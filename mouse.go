@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Mouse buttons, numbered as SGR reports number them, bind like keys
+// through private-use characters as the gamepad's do.
+const (
+	mouseLeft = iota
+	mouseMiddle
+	mouseRight
+	mouseButtons
+)
+
+var mouseNames = [mouseButtons]string{"mouse-left", "mouse-middle", "mouse-right"}
+
+func mouseKey(b int) string { return string(rune(0xf0100 + b)) }
+
+func init() {
+	for b, name := range mouseNames {
+		keyNames[name] = mouseKey(b)
+	}
+}
+
+// mouseOn turns on reporting of all mouse motion in SGR form, and
+// mouseOff turns it off again.
+const (
+	mouseOn  = "\x1b[?1003;1006h"
+	mouseOff = "\x1b[?1003;1006l"
+)
+
+// mouseCellTurn is how far moving the pointer one cell sideways turns at
+// --mouse-sensitivity 1, in the engine's mouse position: about half as
+// far as a tic of turning with run held.
+const mouseCellTurn = 0.0025
+
+// mouseReport is an SGR mouse report, CSI < button ; x ; y M, or m when a
+// button is released. The button has 32 added for motion and 64 for the
+// wheel.
+type mouseReport struct {
+	button, x, y int
+	release      bool
+}
+
+func parseMouse(seq []byte) (m mouseReport, ok bool) {
+	rest, found := strings.CutPrefix(string(seq), "\x1b[<")
+	if !found || len(rest) < 6 {
+		return m, false
+	}
+	final := rest[len(rest)-1]
+	if final != 'M' && final != 'm' {
+		return m, false
+	}
+	f := strings.Split(rest[:len(rest)-1], ";")
+	if len(f) != 3 {
+		return m, false
+	}
+	var err [3]error
+	m.button, err[0] = strconv.Atoi(f[0])
+	m.x, err[1] = strconv.Atoi(f[1])
+	m.y, err[2] = strconv.Atoi(f[2])
+	m.release = final == 'm'
+	return m, err[0] == nil && err[1] == nil && err[2] == nil
+}
+
+// mouse turns the pointer's sideways movement into turning, and its
+// buttons into presses and releases.
+type mouse struct {
+	sensitivity float64
+	lastX       int // 0 before the first report, cells being numbered from 1
+	dx          float64
+}
+
+// report handles m, calling press for a button that went down or up, and
+// returns 1 for the wheel turned up, -1 down and 0 otherwise.
+func (ms *mouse) report(m mouseReport, press func(button int, down bool)) (wheel int) {
+	if ms.lastX != 0 {
+		ms.dx += float64(m.x-ms.lastX) * mouseCellTurn * ms.sensitivity
+	}
+	ms.lastX = m.x
+	switch {
+	case m.button&64 != 0:
+		if m.button&3 == 0 {
+			return 1
+		}
+		if m.button&3 == 1 {
+			return -1
+		}
+	case m.button&32 == 0 && m.button&3 != 3:
+		// not motion, nor a release without saying which button
+		press(m.button&3, !m.release)
+	}
+	return 0
+}
+
+// turning returns how far the mouse has turned since it was last asked.
+func (ms *mouse) turning() float64 {
+	dx := ms.dx
+	ms.dx = 0
+	return dx
+}
+
+// nextWeapon returns the weapon slot, 1 to 7, dir slots on from cur,
+// skipping weapons the status bar last showed as not owned when arms is
+// known. Slot 1, the fist, is always there.
+func nextWeapon(cur, dir int, arms *hudState) int {
+	for slot := cur; ; {
+		slot = (slot-1+dir+7)%7 + 1
+		if slot == 1 || arms == nil || !arms.armsRead || arms.arms[slot-2] {
+			return slot
+		}
+	}
+}
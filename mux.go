@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// multiplexer is the terminal multiplexer we run under, if any. They
+// swallow escape sequences they don't understand, so graphics and titles
+// meant for the real terminal have to be wrapped in a passthrough.
+type multiplexer int
+
+const (
+	muxNone multiplexer = iota
+	muxTmux
+	muxScreen
+)
+
+// detectMux tells tmux and GNU screen apart by the variables they set.
+func detectMux() multiplexer {
+	switch {
+	case os.Getenv("TMUX") != "":
+		return muxTmux
+	case os.Getenv("STY") != "" || strings.HasPrefix(os.Getenv("TERM"), "screen") && os.Getenv("TERM_PROGRAM") != "tmux":
+		return muxScreen
+	}
+	return muxNone
+}
+
+// screenChunk is how much GNU screen passes through per DCS string.
+const screenChunk = 768
+
+// wrap appends seq to b so that the multiplexer hands it to the outer
+// terminal untouched. tmux (with allow-passthrough on) wants one DCS with
+// every ESC doubled; screen wants DCS strings short enough for its buffer.
+func (m multiplexer) wrap(b *bytes.Buffer, seq []byte) {
+	switch m {
+	case muxTmux:
+		b.WriteString("\x1bPtmux;")
+		for _, c := range seq {
+			if c == 0x1b {
+				b.WriteByte(0x1b)
+			}
+			b.WriteByte(c)
+		}
+		b.WriteString("\x1b\\")
+	case muxScreen:
+		for len(seq) > 0 {
+			n := min(len(seq), screenChunk)
+			b.WriteString("\x1bP")
+			b.Write(seq[:n])
+			b.WriteString("\x1b\\")
+			seq = seq[n:]
+		}
+	default:
+		b.Write(seq)
+	}
+}
+
+// muxWriter wraps everything written through it for the multiplexer.
+type muxWriter struct {
+	w   io.Writer
+	mux multiplexer
+}
+
+func (m muxWriter) Write(p []byte) (int, error) {
+	var b bytes.Buffer
+	m.mux.wrap(&b, p)
+	if _, err := m.w.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/AndreRenaud/gore"
+)
+
+// netCommand is a key event asked for over --input-listen; press means
+// down and then up again by itself, like a key typed in the terminal.
+type netCommand struct {
+	key   uint8
+	down  bool
+	press bool
+}
+
+// listenInput accepts connections on addr, each sending lines of
+//
+//	down|up|press key
+//
+// with the key as --bind spells actions (a KEY_ prefix is allowed), and
+// sends the commands on the channel returned. Each line is answered with
+// "ok" or an error, and keys a connection leaves down are let go when it
+// closes.
+func listenInput(addr string) (<-chan netCommand, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan netCommand, 64)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveInput(c, ch)
+		}
+	}()
+	return ch, nil
+}
+
+func serveInput(c net.Conn, ch chan<- netCommand) {
+	defer c.Close()
+	held := make(map[uint8]bool)
+	defer func() {
+		for k := range held {
+			ch <- netCommand{key: k}
+		}
+	}()
+	sc := bufio.NewScanner(c)
+	for sc.Scan() {
+		cmd, err := parseNetCommand(sc.Text())
+		if err != nil {
+			fmt.Fprintf(c, "error: %v\n", err)
+			continue
+		}
+		if !cmd.press {
+			if cmd.down {
+				held[cmd.key] = true
+			} else {
+				delete(held, cmd.key)
+			}
+		}
+		ch <- cmd
+		fmt.Fprintf(c, "ok\n")
+	}
+}
+
+func parseNetCommand(line string) (netCommand, error) {
+	var cmd netCommand
+	f := strings.Fields(line)
+	if len(f) != 2 {
+		return cmd, fmt.Errorf("%q is not: down|up|press key", line)
+	}
+	switch strings.ToLower(f[0]) {
+	case "down":
+		cmd.down = true
+	case "up":
+	case "press":
+		cmd.down, cmd.press = true, true
+	default:
+		return cmd, fmt.Errorf("unknown command %q", f[0])
+	}
+	key := f[1]
+	if len(key) > 4 && strings.EqualFold(key[:4], "key_") {
+		key = key[4:]
+	}
+	var err error
+	cmd.key, err = parseAction(key)
+	return cmd, err
+}
+
+// event turns cmd into an engine event.
+func (cmd netCommand) event() gore.DoomEvent {
+	if cmd.down {
+		return gore.DoomEvent{Type: gore.Ev_keydown, Key: cmd.key}
+	}
+	return gore.DoomEvent{Type: gore.Ev_keyup, Key: cmd.key}
+}
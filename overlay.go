@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"time"
+)
+
+// stats measures the frontend for the overlay: frames drawn per second,
+// how long converting a frame takes, its size in bytes and how many frames
+// the pacer dropped.
+type stats struct {
+	on      bool
+	frames  int
+	since   time.Time
+	fps     float64
+	conv    time.Duration
+	bytes   int
+	dropped int
+}
+
+// frame records one drawn frame.
+func (s *stats) frame(now time.Time, conv time.Duration, n int) {
+	s.frames++
+	s.conv = conv
+	s.bytes = n
+	if d := now.Sub(s.since); d >= time.Second {
+		s.fps = float64(s.frames) / d.Seconds()
+		s.frames, s.since = 0, now
+	}
+}
+
+func (s *stats) String() string {
+	return fmt.Sprintf(" %4.1f fps  %5.2f ms  %6.1f KB  %d dropped ",
+		s.fps, float64(s.conv.Microseconds())/1000, float64(s.bytes)/1000, s.dropped)
+}
+
+var (
+	overlayFG = color.RGBA{255, 255, 255, 255}
+	overlayBG = color.RGBA{0, 0, 0, 255}
+)
+
+// draw writes the overlay into the top-left corner of g.
+func (s *stats) draw(g *grid) {
+	x := 0
+	for _, r := range s.String() {
+		if x >= g.w || g.h == 0 {
+			break
+		}
+		g.set(x, 0, cell{ch: r, fg: overlayFG, bg: overlayBG})
+		x++
+	}
+}
+
+// write puts the overlay over whatever is on screen, for graphics
+// renderers that don't draw into a grid.
+func (s *stats) write(b *bytes.Buffer) {
+	b.WriteString("\x1b[1;1H\x1b[97;40m")
+	b.WriteString(s.String())
+	b.WriteString("\x1b[0m")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pacer decides which frames get drawn and writes them out. The engine
+// calls DrawFrame as often as it likes, so frames above the cap are
+// dropped before any conversion work is done. In vsync mode output goes
+// through a writer goroutine and frames are also dropped while the
+// previous one is still being written, so a slow terminal never builds a
+// backlog.
+type pacer struct {
+	w        io.Writer
+	interval time.Duration // 0 means no cap
+	next     time.Time
+	vsync    bool
+	busy     atomic.Bool
+	frames   chan []byte
+	mu       sync.Mutex // serializes writes to w
+	// observe, if set, is told how long each frame took to write
+	observe func(n int, d time.Duration, now time.Time)
+}
+
+func newPacer(w io.Writer, fps int, vsync bool) *pacer {
+	p := &pacer{w: w, vsync: vsync}
+	p.setFPS(fps)
+	if vsync {
+		p.frames = make(chan []byte)
+		go func() {
+			for b := range p.frames {
+				p.writeFrame(b)
+				p.busy.Store(false)
+			}
+		}()
+	}
+	return p
+}
+
+// setFPS changes the frame rate cap, 0 for none.
+func (p *pacer) setFPS(fps int) {
+	p.interval = 0
+	if fps > 0 {
+		p.interval = time.Second / time.Duration(fps)
+	}
+}
+
+// ready reports whether a frame should be drawn now.
+func (p *pacer) ready(now time.Time) bool {
+	if p.vsync && p.busy.Load() {
+		return false
+	}
+	if p.interval == 0 {
+		return true
+	}
+	if now.Before(p.next) {
+		return false
+	}
+	p.next = p.next.Add(p.interval)
+	if p.next.Before(now) {
+		// we fell behind; don't try to catch up with a burst
+		p.next = now.Add(p.interval)
+	}
+	return true
+}
+
+// frame writes a finished frame. In vsync mode b is handed to the writer
+// and must not be touched until ready reports true again.
+func (p *pacer) frame(b []byte) {
+	if p.vsync {
+		p.busy.Store(true)
+		p.frames <- b
+		return
+	}
+	p.writeFrame(b)
+}
+
+func (p *pacer) writeFrame(b []byte) {
+	start := time.Now()
+	p.write(b)
+	if p.observe != nil {
+		now := time.Now()
+		p.observe(len(b), now.Sub(start), now)
+	}
+}
+
+// write sends b to the terminal without interleaving with a frame.
+func (p *pacer) write(b []byte) {
+	p.mu.Lock()
+	_, _ = p.w.Write(b)
+	p.mu.Unlock()
+}
+
+// wait blocks until any frame in flight has been written.
+func (p *pacer) wait() {
+	for p.busy.Load() {
+		time.Sleep(time.Millisecond)
+	}
+}
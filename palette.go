@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// loadPalette reads a --palette file and returns a function remapping
+// frames through it. Supported are JASC-PAL text and raw 768-byte RGB
+// .pal files, GIMP .gpl palettes, and PNG images. Palettes snap every
+// pixel to the nearest of their colors; a 256×1 PNG is instead a gradient
+// map from brightness to color, which suits sepia-like themes.
+func loadPalette(path string) (func(*image.RGBA), error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".png") {
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		b := img.Bounds()
+		if b.Dx() == 256 && b.Dy() == 1 {
+			return gradientMap(img), nil
+		}
+		var pal []color.RGBA
+		seen := make(map[color.RGBA]bool)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+				c.A = 255
+				if !seen[c] {
+					seen[c] = true
+					pal = append(pal, c)
+				}
+				if len(pal) > 256 {
+					return nil, fmt.Errorf("%s: more than 256 colors", path)
+				}
+			}
+		}
+		return nearestMap(pal), nil
+	}
+	pal, err := parsePalette(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return nearestMap(pal), nil
+}
+
+// parsePalette reads the text and raw palette formats.
+func parsePalette(data []byte) ([]color.RGBA, error) {
+	text := string(data)
+	var pal []color.RGBA
+	switch {
+	case strings.HasPrefix(text, "JASC-PAL"), strings.HasPrefix(text, "GIMP Palette"):
+		jasc := strings.HasPrefix(text, "JASC-PAL")
+		sc := bufio.NewScanner(strings.NewReader(text))
+		for n := 0; sc.Scan(); n++ {
+			f := strings.Fields(sc.Text())
+			// JASC has version and count lines; GIMP has # comments
+			// and Name:/Columns: headers
+			if n == 0 || jasc && n < 3 || len(f) < 3 || strings.HasPrefix(f[0], "#") {
+				continue
+			}
+			var rgb [3]uint8
+			ok := true
+			for i := range rgb {
+				v, err := strconv.ParseUint(f[i], 10, 8)
+				ok = ok && err == nil
+				rgb[i] = uint8(v)
+			}
+			if ok {
+				pal = append(pal, color.RGBA{rgb[0], rgb[1], rgb[2], 255})
+			}
+		}
+	case len(data) == 768:
+		for i := 0; i < len(data); i += 3 {
+			pal = append(pal, color.RGBA{data[i], data[i+1], data[i+2], 255})
+		}
+	default:
+		return nil, fmt.Errorf("not a JASC-PAL, GIMP or raw 768-byte palette")
+	}
+	if len(pal) == 0 {
+		return nil, fmt.Errorf("no colors")
+	}
+	return pal, nil
+}
+
+// nearestMap remaps pixels to the closest palette color by a lookup on 5
+// bits per channel, built once up front.
+func nearestMap(pal []color.RGBA) func(*image.RGBA) {
+	var table [32 * 32 * 32]color.RGBA
+	for i := range table {
+		r, g, b := (i>>10)<<3|4, (i>>5&31)<<3|4, (i&31)<<3|4
+		best, bd := pal[0], 1<<30
+		for _, c := range pal {
+			dr, dg, db := r-int(c.R), g-int(c.G), b-int(c.B)
+			if d := 3*dr*dr + 6*dg*dg + db*db; d < bd {
+				best, bd = c, d
+			}
+		}
+		table[i] = best
+	}
+	return func(img *image.RGBA) {
+		w, h := img.Rect.Dx(), img.Rect.Dy()
+		for y := 0; y < h; y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+			for o := 0; o < len(row); o += 4 {
+				c := table[int(row[o]>>3)<<10|int(row[o+1]>>3)<<5|int(row[o+2]>>3)]
+				row[o], row[o+1], row[o+2] = c.R, c.G, c.B
+			}
+		}
+	}
+}
+
+// gradientMap colors each pixel by its brightness from a 256-pixel strip.
+func gradientMap(strip image.Image) func(*image.RGBA) {
+	var ramp [256]color.RGBA
+	b := strip.Bounds()
+	for i := range ramp {
+		ramp[i] = color.RGBAModel.Convert(strip.At(b.Min.X+i, b.Min.Y)).(color.RGBA)
+	}
+	return func(img *image.RGBA) {
+		w, h := img.Rect.Dx(), img.Rect.Dy()
+		for y := 0; y < h; y++ {
+			row := img.Pix[y*img.Stride : y*img.Stride+w*4]
+			for o := 0; o < len(row); o += 4 {
+				c := ramp[luma(row[o], row[o+1], row[o+2])]
+				row[o], row[o+1], row[o+2] = c.R, c.G, c.B
+			}
+		}
+	}
+}
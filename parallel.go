@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"runtime"
+	"sync"
+)
+
+// minBandCells is the smallest share of a frame worth handing to a worker;
+// below it goroutine handoff costs more than it saves.
+const minBandCells = 2000
+
+// converter turns scaled frames into terminal bytes on several goroutines,
+// each owning a band of rows. Band outputs are concatenated in order.
+type converter struct {
+	workers int // 0 means GOMAXPROCS
+	tol     int // color tolerance, see writeGrid
+	bufs    []bytes.Buffer
+	// rows enables skipping rows unchanged since the last full redraw,
+	// going by hashes of each row's cells; on the screen when valid
+	rows   bool
+	hashes []uint64
+	skip   []bool
+	valid  bool
+}
+
+// forget marks the screen as no longer showing the last frame's rows.
+func (c *converter) forget() {
+	c.valid = false
+}
+
+// bands returns how many row bands to split a w×h grid into.
+func (c *converter) bands(w, h int) int {
+	n := c.workers
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	return max(1, min(n, h, w*h/minBandCells))
+}
+
+// run calls fn for each band [y0, y1) of h rows, in parallel when n > 1.
+func run(n, h int, fn func(i, y0, y1 int)) {
+	if n == 1 {
+		fn(0, 0, h)
+		return
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fn(i, i*h/n, (i+1)*h/n)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// draw runs the renderer over bands of the scene.
+func (c *converter) draw(r renderer, scene *grid, img *image.RGBA) {
+	n := c.bands(scene.w, scene.h)
+	if r.serial {
+		n = 1
+	}
+	run(n, scene.h, func(_, y0, y1 int) {
+		band := scene.rows(y0, y1)
+		sub := img.SubImage(image.Rect(0, y0*r.cellH, img.Rect.Dx(), y1*r.cellH)).(*image.RGBA)
+		r.draw(&band, sub)
+	})
+}
+
+// encode writes g, or only its changes from prev when prev is non-nil.
+func (c *converter) encode(b *bytes.Buffer, g, prev *grid, mode colorMode) {
+	n := c.bands(g.w, g.h)
+	if len(c.bufs) < n {
+		c.bufs = make([]bytes.Buffer, n)
+	}
+	rows := c.rows && prev == nil
+	if rows {
+		if len(c.hashes) != g.h {
+			c.hashes, c.skip = make([]uint64, g.h), make([]bool, g.h)
+			c.valid = false
+		}
+	} else {
+		// cell diffs move on from what the hashes describe
+		c.valid = false
+	}
+	run(n, g.h, func(i, y0, y1 int) {
+		out := &c.bufs[i]
+		out.Reset()
+		band := g.rows(y0, y1)
+		switch {
+		case prev != nil:
+			old := prev.rows(y0, y1)
+			writeGridDiff(out, &band, &old, mode, c.tol)
+		case rows:
+			for y := y0; y < y1; y++ {
+				h := hashRow(g.cells[y*g.w : (y+1)*g.w])
+				c.skip[y] = c.valid && h == c.hashes[y]
+				c.hashes[y] = h
+			}
+			writeGridRows(out, &band, mode, c.tol, c.skip[y0:y1])
+		default:
+			writeGrid(out, &band, mode, c.tol)
+		}
+	})
+	c.valid = rows
+	for i := 0; i < n; i++ {
+		b.Write(c.bufs[i].Bytes())
+	}
+}
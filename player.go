@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// A recording's output, as --record writes it, for the replay subcommand.
+type recordedOutput struct {
+	at   time.Duration // since the start
+	data []byte
+}
+
+// loadRecording reads an asciinema cast or a ttyrec file, telling them
+// apart by the cast's JSON header.
+func loadRecording(path string) ([]recordedOutput, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte("{")) {
+		return parseCast(data)
+	}
+	return parseTtyrec(data)
+}
+
+func parseCast(data []byte) ([]recordedOutput, error) {
+	var out []recordedOutput
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	for n := 1; sc.Scan(); n++ {
+		if n == 1 || len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var e [3]any
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		t, ok1 := e[0].(float64)
+		kind, ok2 := e[1].(string)
+		s, ok3 := e[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return nil, fmt.Errorf("line %d: not an event", n)
+		}
+		if kind == "o" {
+			out = append(out, recordedOutput{time.Duration(t * float64(time.Second)), []byte(s)})
+		}
+	}
+	return out, sc.Err()
+}
+
+func parseTtyrec(data []byte) ([]recordedOutput, error) {
+	var out []recordedOutput
+	var start time.Time
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, errors.New("ttyrec: truncated header")
+		}
+		sec := binary.LittleEndian.Uint32(data[0:])
+		usec := binary.LittleEndian.Uint32(data[4:])
+		n := binary.LittleEndian.Uint32(data[8:])
+		data = data[12:]
+		if uint32(len(data)) < n {
+			return nil, errors.New("ttyrec: truncated record")
+		}
+		t := time.Unix(int64(sec), int64(usec)*1000)
+		if out == nil {
+			start = t
+		}
+		out = append(out, recordedOutput{t.Sub(start), data[:n]})
+		data = data[n:]
+	}
+	return out, nil
+}
+
+// Replay speeds, as powers of two, and how far the arrow keys seek.
+const (
+	replayMinSpeed = 1.0 / 16
+	replayMaxSpeed = 16
+	replaySeek     = 5 * time.Second
+)
+
+// replayCommand is termdoom replay: it plays a recording in the terminal.
+// Space pauses, + and - double and halve the speed, the left and right
+// arrows seek five seconds, and q or Escape quits. Where it has got to is
+// in the window title, leaving the picture alone.
+func replayCommand(args []string) {
+	fs := flag.NewFlagSet("termdoom replay", flag.ExitOnError)
+	speed := fs.Float64("speed", 1, "playback speed to start at")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: termdoom replay [--speed n] recording.cast|recording.ttyrec\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		usageError(fs, "replay needs one recording")
+	}
+	if *speed < replayMinSpeed || *speed > replayMaxSpeed {
+		usageError(fs, "speed must be within %g..%g", replayMinSpeed, replayMaxSpeed)
+	}
+	rec, err := loadRecording(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "termdoom:", err)
+		os.Exit(1)
+	}
+
+	fd := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "terminal raw mode:", err)
+		os.Exit(1)
+	}
+	defer term.Restore(fd, oldState)
+	fmt.Print("\x1b[?1049h\x1b[22;0t\x1b[2J\x1b[H\x1b[?25l")
+	defer fmt.Print("\x1b[0m\x1b[2J\x1b[H\x1b[?25h\x1b[23;0t\x1b[?1049l")
+
+	p := &player{rec: rec, speed: *speed, w: bufio.NewWriter(os.Stdout)}
+	p.run(keyReader(os.Stdin))
+}
+
+// player plays a recording back.
+type player struct {
+	rec    []recordedOutput
+	next   int           // the first output not yet written
+	pos    time.Duration // where in the recording playback is
+	speed  float64
+	paused bool
+	w      *bufio.Writer
+	title  string
+}
+
+func (p *player) run(keys <-chan byte) {
+	var input inputParser
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	last := time.Now()
+	for {
+		now := <-tick.C
+		for seq := input.next(keys, now); seq != nil; seq = input.next(keys, now) {
+			if !p.key(string(seq)) {
+				return
+			}
+		}
+		if !p.paused {
+			p.pos += time.Duration(float64(now.Sub(last)) * p.speed)
+		}
+		last = now
+		if end := p.length(); p.pos >= end {
+			p.pos, p.paused = end, true
+		}
+		for p.next < len(p.rec) && p.rec[p.next].at <= p.pos {
+			p.w.Write(p.rec[p.next].data)
+			p.next++
+		}
+		p.showTitle()
+		p.w.Flush()
+	}
+}
+
+// key handles a key, reporting false for quitting.
+func (p *player) key(seq string) bool {
+	switch seq {
+	case "q", "Q", "\x1b", "\x03":
+		return false
+	case " ":
+		if p.pos >= p.length() {
+			p.seek(0)
+		}
+		p.paused = !p.paused
+	case "+", "=":
+		p.speed = min(p.speed*2, replayMaxSpeed)
+	case "-", "_":
+		p.speed = max(p.speed/2, replayMinSpeed)
+	case "\x1b[C":
+		p.pos = min(p.pos+replaySeek, p.length())
+	case "\x1b[D":
+		p.seek(max(p.pos-replaySeek, 0))
+	}
+	return true
+}
+
+func (p *player) length() time.Duration {
+	if len(p.rec) == 0 {
+		return 0
+	}
+	return p.rec[len(p.rec)-1].at
+}
+
+// seek goes back to pos. What is on the screen there is only known by
+// drawing it, so the output is written again from the last screen clear
+// before it, or the start.
+func (p *player) seek(pos time.Duration) {
+	p.pos = pos
+	p.next = 0
+	for i := range p.rec {
+		if p.rec[i].at > pos {
+			break
+		}
+		if bytes.Contains(p.rec[i].data, []byte("\x1b[2J")) {
+			p.next = i
+		}
+	}
+	p.w.WriteString("\x1b[0m\x1b[2J\x1b[H")
+}
+
+func (p *player) showTitle() {
+	state := fmt.Sprintf("%g×", p.speed)
+	if p.paused {
+		state = "paused"
+	}
+	title := fmt.Sprintf("termdoom replay %s / %s %s", clock(p.pos), clock(p.length()), state)
+	if title != p.title {
+		p.title = title
+		p.w.WriteString("\x1b]0;" + title + "\x07")
+	}
+}
+
+// clock formats d as minutes and seconds.
+func clock(d time.Duration) string {
+	s := int(d / time.Second)
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
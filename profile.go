@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+)
+
+// startProfiling starts what --pprof and --cpuprofile ask for; stop ends
+// the CPU profile and writes the --memprofile one, when the game is over.
+// A profile that can't be had is complained about, not fatal.
+func startProfiling(opts options) (stop func()) {
+	if opts.pprof != "" {
+		if err := servePprof(opts.pprof); err != nil {
+			fmt.Fprintf(os.Stderr, "termdoom: pprof unavailable: %v\r\n", err)
+		}
+	}
+	var cpu *os.File
+	if opts.cpuProfile != "" {
+		f, err := os.Create(opts.cpuProfile)
+		if err == nil {
+			err = runtimepprof.StartCPUProfile(f)
+			if err != nil {
+				f.Close()
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "termdoom: CPU profile unavailable: %v\r\n", err)
+		} else {
+			cpu = f
+		}
+	}
+	return func() {
+		if cpu != nil {
+			runtimepprof.StopCPUProfile()
+			cpu.Close()
+		}
+		if opts.memProfile != "" {
+			if err := writeMemProfile(opts.memProfile); err != nil {
+				fmt.Fprintf(os.Stderr, "termdoom: memory profile: %v\n", err)
+			}
+		}
+	}
+}
+
+// servePprof serves net/http/pprof's handlers at /debug/pprof/ on addr.
+func servePprof(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	go http.Serve(l, mux)
+	return nil
+}
+
+// writeMemProfile writes a heap profile to path, as of a garbage
+// collection so it is up to date.
+func writeMemProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := runtimepprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
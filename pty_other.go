@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func openPTY() (master, slave *os.File, err error) {
+	return nil, nil, errors.New("serving games to remote terminals is only supported on Linux")
+}
+
+func setPTYSize(master *os.File, w, h, xpx, ypx int) error {
+	return nil
+}
+
+func ptyAttr() *syscall.SysProcAttr {
+	return nil
+}
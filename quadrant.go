@@ -0,0 +1,78 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// quadrantGlyphs is indexed by a mask of lit quadrants:
+// 1 top-left, 2 top-right, 4 bottom-left, 8 bottom-right.
+var quadrantGlyphs = [16]rune{
+	' ', '▘', '▝', '▀', '▖', '▌', '▞', '▛',
+	'▗', '▚', '▐', '▜', '▄', '▙', '▟', '█',
+}
+
+// toQuadrant splits each cell into 2×2 pixels and picks the quadrant glyph
+// whose foreground/background partition best fits them, coloring each side
+// with the average of its pixels. img must be 2g.w×2g.h.
+func toQuadrant(g *grid, img *image.RGBA) {
+	var px [4][3]int
+	for y := 0; y < g.h; y++ {
+		for x := 0; x < g.w; x++ {
+			for i := range px {
+				o := (y*2+i/2)*img.Stride + (x*2+i%2)*4
+				px[i] = [3]int{int(img.Pix[o]), int(img.Pix[o+1]), int(img.Pix[o+2])}
+			}
+
+			best, bestErr := 15, -1
+			var bestFG, bestBG [3]int
+			for mask := 1; mask < 16; mask++ {
+				fg, bg := quadrantMeans(&px, mask)
+				e := 0
+				for i, p := range px {
+					m := bg
+					if mask&(1<<i) != 0 {
+						m = fg
+					}
+					for c := 0; c < 3; c++ {
+						d := p[c] - m[c]
+						e += d * d
+					}
+				}
+				if bestErr < 0 || e < bestErr {
+					best, bestErr, bestFG, bestBG = mask, e, fg, bg
+				}
+			}
+
+			c := cell{
+				ch: quadrantGlyphs[best],
+				fg: color.RGBA{uint8(bestFG[0]), uint8(bestFG[1]), uint8(bestFG[2]), 255},
+			}
+			if best != 15 {
+				c.bg = color.RGBA{uint8(bestBG[0]), uint8(bestBG[1]), uint8(bestBG[2]), 255}
+			}
+			g.set(x, y, c)
+		}
+	}
+}
+
+// quadrantMeans averages the pixels inside and outside mask.
+func quadrantMeans(px *[4][3]int, mask int) (fg, bg [3]int) {
+	nf, nb := 0, 0
+	for i, p := range px {
+		if mask&(1<<i) != 0 {
+			fg[0], fg[1], fg[2] = fg[0]+p[0], fg[1]+p[1], fg[2]+p[2]
+			nf++
+		} else {
+			bg[0], bg[1], bg[2] = bg[0]+p[0], bg[1]+p[1], bg[2]+p[2]
+			nb++
+		}
+	}
+	for c := 0; c < 3; c++ {
+		fg[c] /= nf
+		if nb > 0 {
+			bg[c] /= nb
+		}
+	}
+	return fg, bg
+}
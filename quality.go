@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sync/atomic"
+	"time"
+)
+
+// qualityLevels trade picture detail for bytes, from full quality down.
+// div renders at 1/div of the resolution and blows it back up, so runs of
+// identical cells need no color changes; mask drops low color bits in
+// true color mode, so fewer neighbouring cells differ and fewer change
+// between frames.
+var qualityLevels = []struct {
+	div  int
+	mask uint8
+}{
+	{1, 0xff},
+	{1, 0xf8},
+	{1, 0xf0},
+	{2, 0xf0},
+	{2, 0xe0},
+	{4, 0xe0},
+}
+
+const (
+	// load is write time over the frame budget, averaged over frames
+	degradeLoad = 1.0
+	restoreLoad = 0.35
+	degradeHold = time.Second
+	restoreHold = 3 * time.Second
+)
+
+// quality adapts the picture to what the output link can carry. It watches
+// how long writes to the terminal take compared with the time between
+// frames: when frames back up, as on a slow SSH link, it steps down a
+// level, and it steps back up once writes have been quick for a while.
+type quality struct {
+	level  atomic.Int32
+	rate   atomic.Int64 // bytes per second while writing, smoothed
+	budget time.Duration
+
+	// observe's state, touched only by the goroutine writing frames
+	load, bytes, secs float64
+	since             time.Time
+
+	small scaler
+}
+
+func newQuality(fps int) *quality {
+	if fps <= 0 {
+		fps = 35
+	}
+	return &quality{budget: time.Second / time.Duration(fps)}
+}
+
+// observe records that a frame of n bytes took d to write.
+func (q *quality) observe(n int, d time.Duration, now time.Time) {
+	const k = 0.2
+	q.load += k * (float64(d)/float64(q.budget) - q.load)
+	q.bytes += k * (float64(n) - q.bytes)
+	q.secs += k * (d.Seconds() - q.secs)
+	if q.secs > 0 {
+		q.rate.Store(int64(q.bytes / q.secs))
+	}
+	lvl := int(q.level.Load())
+	switch {
+	case q.load > degradeLoad && lvl < len(qualityLevels)-1:
+		if q.since.IsZero() {
+			q.since = now
+		} else if now.Sub(q.since) >= degradeHold {
+			q.level.Store(int32(lvl + 1))
+			q.since = time.Time{}
+		}
+	case q.load < restoreLoad && lvl > 0:
+		if q.since.IsZero() {
+			q.since = now
+		} else if now.Sub(q.since) >= restoreHold {
+			q.level.Store(int32(lvl - 1))
+			q.since = time.Time{}
+		}
+	default:
+		q.since = time.Time{}
+	}
+}
+
+func (q *quality) String() string {
+	return fmt.Sprintf("quality %d/%d (%d KB/s)", len(qualityLevels)-int(q.level.Load()), len(qualityLevels), q.rate.Load()/1000)
+}
+
+// apply reduces img in place for the current level.
+func (q *quality) apply(img *image.RGBA, mode colorMode) {
+	l := qualityLevels[q.level.Load()]
+	if l.div > 1 {
+		w, h := img.Rect.Dx(), img.Rect.Dy()
+		small := q.small.scale(img, max(1, w/l.div), max(1, h/l.div), false)
+		blowUp(img, small)
+	}
+	if l.mask != 0xff && mode == colorTrue {
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i] &= l.mask
+			img.Pix[i+1] &= l.mask
+			img.Pix[i+2] &= l.mask
+		}
+	}
+}
+
+// blowUp fills dst with src scaled up by nearest neighbour.
+func blowUp(dst, src *image.RGBA) {
+	w, h := dst.Rect.Dx(), dst.Rect.Dy()
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	for y := 0; y < h; y++ {
+		srow := src.Pix[y*sh/h*src.Stride:]
+		drow := dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			copy(drow[x*4:x*4+4], srow[x*sw/w*4:])
+		}
+	}
+}
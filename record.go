@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AndreRenaud/gore"
+)
+
+// Input logs, written by --record-input and played by --replay-input,
+// hold the events the engine got, one per line: the milliseconds since
+// it first asked for one, then "down" or "up" and the key as --bind
+// spells actions, or "mouse" and the mouse position. Blank lines and
+// lines starting with # are skipped, so they can be written by hand.
+//
+//	1200 down forward
+//	1900 up forward
+//	2000 mouse 0.05
+
+// inputRecorder writes an input log.
+type inputRecorder struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func newInputRecorder(path string) (*inputRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &inputRecorder{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// record logs ev, which the engine got at since into the game.
+func (r *inputRecorder) record(ev *gore.DoomEvent, since time.Duration) {
+	ms := since.Milliseconds()
+	switch ev.Type {
+	case gore.Ev_keydown:
+		fmt.Fprintf(r.w, "%d down %s\n", ms, actionName(ev.Key))
+	case gore.Ev_keyup:
+		fmt.Fprintf(r.w, "%d up %s\n", ms, actionName(ev.Key))
+	case gore.Ev_mouse:
+		fmt.Fprintf(r.w, "%d mouse %g\n", ms, ev.Mouse.XPos)
+	}
+	// a line at a time, so a crash leaves the log up to it
+	r.w.Flush()
+}
+
+func (r *inputRecorder) close() error {
+	if err := r.w.Flush(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
+
+// timedEvent is an event in an input log.
+type timedEvent struct {
+	at time.Duration
+	ev gore.DoomEvent
+}
+
+// inputReplay plays an input log back.
+type inputReplay struct {
+	events []timedEvent
+	next   int
+}
+
+func loadInputReplay(path string) (*inputReplay, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &inputReplay{}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		e, err := parseTimedEvent(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n+1, err)
+		}
+		if len(r.events) > 0 && e.at < r.events[len(r.events)-1].at {
+			return nil, fmt.Errorf("%s:%d: out of order", path, n+1)
+		}
+		r.events = append(r.events, e)
+	}
+	return r, nil
+}
+
+func parseTimedEvent(line string) (timedEvent, error) {
+	var e timedEvent
+	f := strings.Fields(line)
+	if len(f) != 3 {
+		return e, fmt.Errorf("%q is not: milliseconds down|up|mouse value", line)
+	}
+	ms, err := strconv.Atoi(f[0])
+	if err != nil || ms < 0 {
+		return e, fmt.Errorf("bad time %q", f[0])
+	}
+	e.at = time.Duration(ms) * time.Millisecond
+	switch f[1] {
+	case "down", "up":
+		e.ev.Type = gore.Ev_keydown
+		if f[1] == "up" {
+			e.ev.Type = gore.Ev_keyup
+		}
+		e.ev.Key, err = parseAction(f[2])
+	case "mouse":
+		e.ev.Type = gore.Ev_mouse
+		e.ev.Mouse.XPos, err = strconv.ParseFloat(f[2], 64)
+	default:
+		err = fmt.Errorf("unknown event %q", f[1])
+	}
+	return e, err
+}
+
+// due sets ev to the next event if its time has come. Mouse events wait
+// for the first of a round, as the engine needs them.
+func (r *inputReplay) due(ev *gore.DoomEvent, since time.Duration, first bool) bool {
+	if r.next >= len(r.events) {
+		return false
+	}
+	e := r.events[r.next]
+	if e.at > since || e.ev.Type == gore.Ev_mouse && !first {
+		return false
+	}
+	*ev = e.ev
+	r.next++
+	return true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"os"
+	"strings"
+)
+
+// renderer converts a frame scaled to cellW×cellH source pixels per
+// terminal cell into the cell grid. Graphics renderers set encode instead and
+// write the unscaled frame straight to the output, covering cols×rows cells.
+// cleanup, if set, runs on exit to remove anything left on screen. Cell
+// renderers run on bands of rows in parallel unless serial is set.
+type renderer struct {
+	cellW, cellH int
+	draw         func(g *grid, img *image.RGBA)
+	serial       bool
+	encode       func(b *bytes.Buffer, img *image.RGBA, cols, rows int)
+	cleanup      func(w io.Writer)
+}
+
+var renderers = map[string]renderer{
+	"ascii":        {cellW: 1, cellH: 1, draw: toASCII},
+	"ascii-dual":   {cellW: 2, cellH: 2, draw: toASCIIDual},
+	"edges":        {cellW: 1, cellH: 1, draw: toEdges, serial: true}, // looks across band edges
+	"pixel":        {cellW: 1, cellH: 1, draw: toPixels},
+	"halfblock":    {cellW: 1, cellH: 2, draw: toHalfBlock},
+	"quadrant":     {cellW: 2, cellH: 2, draw: toQuadrant},
+	"braille":      {cellW: 2, cellH: 4, draw: toBraille},
+	"braille-mono": {cellW: 2, cellH: 4, draw: toBrailleMono},
+	"sixel":        {encode: (&sixelEncoder{}).encode},
+	"kitty":        {encode: (&kittyEncoder{}).encode, cleanup: kittyCleanup},
+	"iterm":        {encode: (&itermEncoder{}).encode},
+}
+
+// rendererNames lists the registry in the order it is presented to users.
+var rendererNames = []string{
+	"ascii", "ascii-dual", "edges", "pixel", "halfblock", "quadrant", "braille", "braille-mono", "sixel", "kitty", "iterm",
+}
+
+// tinyCellPx is the cell height at or below which textured glyphs turn to
+// mush and solid background pixels look better.
+const tinyCellPx = 8
+
+// autoRenderer picks the best renderer the terminal is known to handle:
+// a graphics protocol if one was detected, half blocks otherwise, or plain
+// colored cells when the font is tiny.
+func autoRenderer(caps termCaps) string {
+	switch {
+	case caps.kittyGraphics:
+		return "kitty"
+	case caps.iterm:
+		return "iterm"
+	case caps.sixel:
+		return "sixel"
+	case os.Getenv("TERM") == "dumb":
+		return "ascii"
+	}
+	if _, h, ok := cellPixels(); ok && h <= tinyCellPx {
+		return "pixel"
+	}
+	return "halfblock"
+}
+
+// rendererList formats rendererNames for usage text.
+func rendererList() string {
+	return strings.Join(rendererNames, ", ")
+}
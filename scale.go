@@ -0,0 +1,80 @@
+package main
+
+import "image"
+
+// scaler resamples frames into a buffer it reuses between frames, so the
+// steady state allocates nothing.
+type scaler struct {
+	dst *image.RGBA
+	// xs and ys are the source column and row of each destination pixel
+	// for nearest-neighbour scaling, rebuilt when sizes change
+	xs, ys []int
+	srcW   int
+	srcH   int
+}
+
+// scale returns src resized to w×h. With box set, each destination pixel
+// averages the source pixels it covers, which keeps thin details from
+// vanishing when shrinking; otherwise the nearest pixel is used, which is
+// faster and crisper. The result is only valid until the next call.
+func (s *scaler) scale(src *image.RGBA, w, h int, box bool) *image.RGBA {
+	if s.dst == nil || s.dst.Rect.Dx() != w || s.dst.Rect.Dy() != h {
+		s.dst = image.NewRGBA(image.Rect(0, 0, w, h))
+		s.xs = s.xs[:0]
+	}
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	if len(s.xs) != w || s.srcW != sw || s.srcH != sh {
+		s.srcW, s.srcH = sw, sh
+		s.xs = s.xs[:0]
+		for x := 0; x < w; x++ {
+			s.xs = append(s.xs, x*sw/w)
+		}
+		s.ys = s.ys[:0]
+		for y := 0; y < h; y++ {
+			s.ys = append(s.ys, y*sh/h)
+		}
+	}
+	if box && (sw > w || sh > h) {
+		s.box(src)
+	} else {
+		s.nearest(src)
+	}
+	return s.dst
+}
+
+func (s *scaler) nearest(src *image.RGBA) {
+	d := s.dst
+	for y, sy := range s.ys {
+		srow := src.Pix[sy*src.Stride:]
+		drow := d.Pix[y*d.Stride:]
+		for x, sx := range s.xs {
+			copy(drow[x*4:x*4+4], srow[sx*4:sx*4+4])
+		}
+	}
+}
+
+func (s *scaler) box(src *image.RGBA) {
+	d := s.dst
+	w, h := d.Rect.Dx(), d.Rect.Dy()
+	for y := 0; y < h; y++ {
+		y0, y1 := s.ys[y], (y+1)*s.srcH/h
+		y1 = max(y1, y0+1)
+		drow := d.Pix[y*d.Stride:]
+		for x := 0; x < w; x++ {
+			x0, x1 := s.xs[x], (x+1)*s.srcW/w
+			x1 = max(x1, x0+1)
+			var r, g, b, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += int(row[sx*4])
+					g += int(row[sx*4+1])
+					b += int(row[sx*4+2])
+					n++
+				}
+			}
+			o := x * 4
+			drow[o], drow[o+1], drow[o+2], drow[o+3] = uint8(r/n), uint8(g/n), uint8(b/n), 255
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/AndreRenaud/gore"
+)
+
+// textScreens recognises the menus, the intermission tally and the
+// finale text in frames, by matching DOOM's own graphics from the WAD at
+// the positions the engine draws them, and reads them back out as text.
+// Downscaled to 80 columns their bitmap lettering is unreadable; as a
+// text panel it is crisp at any size.
+type textScreens struct {
+	w    *wad
+	pals [][256]color.RGBA
+	pal  int // palette of the last recognised screen, tried first
+	// font is the small red font, '!' to '_'
+	font  [63]*patch
+	skull [2]*patch
+	// patches caches decoded lumps; nil for ones the WAD lacks
+	patches map[string]*patch
+}
+
+// panelLine is one line of a text panel.
+type panelLine struct {
+	text     string
+	selected bool
+	dim      bool
+}
+
+func newTextScreens(w *wad) (*textScreens, error) {
+	pals, err := w.palettes()
+	if err != nil {
+		return nil, err
+	}
+	s := &textScreens{w: w, pals: pals, patches: make(map[string]*patch)}
+	for i := range s.font {
+		s.font[i] = s.patch(fmt.Sprintf("STCFN%03d", i+'!'))
+	}
+	s.skull = [2]*patch{s.patch("M_SKULL1"), s.patch("M_SKULL2")}
+	return s, nil
+}
+
+func (s *textScreens) patch(name string) *patch {
+	p, ok := s.patches[name]
+	if !ok {
+		p, _ = s.w.patch(name)
+		s.patches[name] = p
+	}
+	return p
+}
+
+// match reports whether patch p, drawn at (x, y) the way the engine does,
+// is on img in palette pal. Only every step-th opaque pixel is checked.
+func (s *textScreens) match(img *image.RGBA, p *patch, x, y, pal, step int) bool {
+	if p == nil {
+		return false
+	}
+	x, y = x-p.left, y-p.top
+	if x < 0 || y < 0 || x+p.w > img.Rect.Dx() || y+p.h > img.Rect.Dy() {
+		return false
+	}
+	colors := &s.pals[pal]
+	hit, n := 0, 0
+	for i, v := range p.pix {
+		if v < 0 || i%step != 0 {
+			continue
+		}
+		n++
+		o := (y+i/p.w)*img.Stride + (x+i%p.w)*4
+		c := colors[v]
+		if img.Pix[o] == c.R && img.Pix[o+1] == c.G && img.Pix[o+2] == c.B {
+			hit++
+		} else if n == 32 && hit < 24 {
+			// plainly not here; most frames show none of these
+			return false
+		}
+	}
+	return n > 0 && hit*20 >= n*19
+}
+
+// find looks for p at (x, y) in the last palette, then in every other one.
+func (s *textScreens) find(img *image.RGBA, p *patch, x, y, step int) bool {
+	if s.match(img, p, x, y, s.pal, step) {
+		return true
+	}
+	for pal := range s.pals {
+		if pal != s.pal && s.match(img, p, x, y, pal, step) {
+			s.pal = pal
+			return true
+		}
+	}
+	return false
+}
+
+// readText reads a line of the small font starting at (x, y), the way
+// the engine's text writer lays it out: glyphs side by side, 4 pixels
+// for a space.
+func (s *textScreens) readText(img *image.RGBA, x, y int) string {
+	var b strings.Builder
+	spaces := 0
+	for x < img.Rect.Dx() && spaces < 4 {
+		best := -1
+		for i, p := range s.font {
+			if p != nil && (best < 0 || p.w*p.h > s.font[best].w*s.font[best].h) && s.match(img, p, x, y, s.pal, 1) {
+				best = i
+			}
+		}
+		if best < 0 {
+			b.WriteByte(' ')
+			x += 4
+			spaces++
+			continue
+		}
+		b.WriteByte(byte(best + '!'))
+		x += s.font[best].w
+		spaces = 0
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// readNumber reads digits (and whatever extra glyphs are given) drawn
+// right to left ending at x, as the intermission screen draws numbers.
+func (s *textScreens) readNumber(img *image.RGBA, x, y int, extra map[rune]*patch) string {
+	var out []rune
+	for {
+		found := false
+		for d := 0; d < 10 && !found; d++ {
+			if p := s.patch(fmt.Sprintf("WINUM%d", d)); p != nil && s.match(img, p, x-p.w, y, s.pal, 1) {
+				out = append([]rune{rune('0' + d)}, out...)
+				x -= p.w
+				found = true
+			}
+		}
+		for r, p := range extra {
+			if !found && p != nil && s.match(img, p, x-p.w, y, s.pal, 1) {
+				out = append([]rune{r}, out...)
+				x -= p.w
+				found = true
+			}
+		}
+		if !found {
+			return string(out)
+		}
+	}
+}
+
+// read returns the panel for the screen img shows, or nil for ordinary
+// frames.
+func (s *textScreens) read(img *image.RGBA) []panelLine {
+	if img.Rect.Dx() != 320 || img.Rect.Dy() != 200 {
+		return nil
+	}
+	// the menu goes over everything else
+	if lines := s.readMenu(img); lines != nil {
+		return lines
+	}
+	if lines := s.readIntermission(img); lines != nil {
+		return lines
+	}
+	return s.readFinale(img)
+}
+
+// menuScreen describes one of the engine's menus: its title graphic and
+// where it goes, and the live layout of its items.
+type menuScreen struct {
+	title     string
+	lump      string
+	tx, ty    int
+	layout    func() (x, y int, items []string)
+	textSlots bool // load and save: items are savegame names
+}
+
+// menuItems gathers a menu's item graphics names.
+func menuItems(x, y int16, n int, name func(i int) string) (int, int, []string) {
+	items := make([]string, n)
+	for i := range items {
+		items[i] = name(i)
+	}
+	return int(x), int(y), items
+}
+
+var menuScreens = []menuScreen{
+	{"DOOM", "M_DOOM", 94, 2, func() (int, int, []string) {
+		d := &gore.MainDef
+		return menuItems(d.Fx, d.Fy, min(int(d.Fnumitems), len(d.Fmenuitems)), func(i int) string { return d.Fmenuitems[i].Fname })
+	}, false},
+	{"New Game", "M_SKILL", 54, 38, func() (int, int, []string) {
+		d := &gore.NewDef
+		return menuItems(d.Fx, d.Fy, min(int(d.Fnumitems), len(d.Fmenuitems)), func(i int) string { return d.Fmenuitems[i].Fname })
+	}, false},
+	{"Episode", "M_EPISOD", 54, 38, func() (int, int, []string) {
+		d := &gore.EpiDef
+		return menuItems(d.Fx, d.Fy, min(int(d.Fnumitems), len(d.Fmenuitems)), func(i int) string { return d.Fmenuitems[i].Fname })
+	}, false},
+	{"Options", "M_OPTTTL", 108, 15, func() (int, int, []string) {
+		d := &gore.OptionsDef
+		return menuItems(d.Fx, d.Fy, min(int(d.Fnumitems), len(d.Fmenuitems)), func(i int) string { return d.Fmenuitems[i].Fname })
+	}, false},
+	{"Sound Volume", "M_SVOL", 60, 38, func() (int, int, []string) {
+		d := &gore.SoundDef
+		return menuItems(d.Fx, d.Fy, min(int(d.Fnumitems), len(d.Fmenuitems)), func(i int) string { return d.Fmenuitems[i].Fname })
+	}, false},
+	{"Load Game", "M_LOADG", 72, 28, func() (int, int, []string) {
+		d := &gore.LoadDef
+		return menuItems(d.Fx, d.Fy, min(int(d.Fnumitems), len(d.Fmenuitems)), func(i int) string { return d.Fmenuitems[i].Fname })
+	}, true},
+	{"Save Game", "M_SAVEG", 72, 28, func() (int, int, []string) {
+		d := &gore.LoadDef // the save menu shares the load menu's layout
+		return menuItems(d.Fx, d.Fy, min(int(d.Fnumitems), len(d.Fmenuitems)), func(i int) string { return d.Fmenuitems[i].Fname })
+	}, true},
+}
+
+// menuLabels spells out the menu item graphics.
+var menuLabels = map[string]string{
+	"M_NGAME": "New Game", "M_OPTION": "Options", "M_LOADG": "Load Game",
+	"M_SAVEG": "Save Game", "M_RDTHIS": "Read This!", "M_QUITG": "Quit Game",
+	"M_EPI1": "Knee-Deep in the Dead", "M_EPI2": "The Shores of Hell",
+	"M_EPI3": "Inferno", "M_EPI4": "Thy Flesh Consumed",
+	"M_JKILL": "I'm too young to die.", "M_ROUGH": "Hey, not too rough.",
+	"M_HURT": "Hurt me plenty.", "M_ULTRA": "Ultra-Violence.", "M_NMARE": "Nightmare!",
+	"M_ENDGAM": "End Game", "M_MESSG": "Messages", "M_DETAIL": "Graphic Detail",
+	"M_SCRNSZ": "Screen Size", "M_MSENS": "Mouse Sensitivity", "M_SVOL": "Sound Volume",
+	"M_SFXVOL": "Sfx Volume", "M_MUSVOL": "Music Volume",
+}
+
+// menuSliders are the items followed by a slider, and its width.
+var menuSliders = map[string]int{"M_MSENS": 10, "M_SCRNSZ": 9, "M_SFXVOL": 16, "M_MUSVOL": 16}
+
+const (
+	menuLineHeight = 16
+	skullXOff      = -32
+)
+
+func (s *textScreens) readMenu(img *image.RGBA) []panelLine {
+	for _, m := range menuScreens {
+		if !s.find(img, s.patch(m.lump), m.tx, m.ty, 3) {
+			continue
+		}
+		x, y, items := m.layout()
+		lines := []panelLine{{text: m.title, dim: true}, {}}
+		for i, name := range items {
+			line := panelLine{text: menuLabels[name]}
+			if m.textSlots {
+				line.text = s.readText(img, x, y+i*menuLineHeight)
+				if line.text == "" {
+					line.text = "-"
+				}
+			}
+			if name == "" && !m.textSlots {
+				// the row a slider occupies
+				continue
+			}
+			switch name {
+			case "M_MESSG":
+				line.text += ": " + s.onOff(img, x+120, y+i*menuLineHeight, "M_MSGON", "M_MSGOFF", "on", "off")
+			case "M_DETAIL":
+				line.text += ": " + s.onOff(img, x+175, y+i*menuLineHeight, "M_GDHIGH", "M_GDLOW", "high", "low")
+			}
+			if w, ok := menuSliders[name]; ok {
+				line.text += " " + s.slider(img, x, y+(i+1)*menuLineHeight, w)
+			}
+			for _, sk := range s.skull {
+				if s.match(img, sk, x+skullXOff, y-5+i*menuLineHeight, s.pal, 2) {
+					line.selected = true
+				}
+			}
+			lines = append(lines, line)
+		}
+		return lines
+	}
+	return nil
+}
+
+func (s *textScreens) onOff(img *image.RGBA, x, y int, on, off, onText, offText string) string {
+	switch {
+	case s.match(img, s.patch(on), x, y, s.pal, 1):
+		return onText
+	case s.match(img, s.patch(off), x, y, s.pal, 1):
+		return offText
+	}
+	return "?"
+}
+
+// slider reads a menu slider of width steps as a bar.
+func (s *textScreens) slider(img *image.RGBA, x, y, width int) string {
+	dot := s.patch("M_THERMO")
+	for i := 0; i < width; i++ {
+		if s.match(img, dot, x+8+i*8, y, s.pal, 1) {
+			return "[" + strings.Repeat("#", i+1) + strings.Repeat("-", width-i-1) + "]"
+		}
+	}
+	return ""
+}
+
+// Where the intermission tally is drawn.
+const (
+	wiStatsX = 50
+	wiStatsY = 50
+	wiTimeX  = 16
+	wiTimeY  = 168
+)
+
+func (s *textScreens) readIntermission(img *image.RGBA) []panelLine {
+	t, ok := s.tally(img)
+	if !ok {
+		return nil
+	}
+	lines := []panelLine{{text: t.Level, dim: true}, {}}
+	lines = append(lines,
+		panelLine{text: fmt.Sprintf("Kills   %4d%%", t.Kills)},
+		panelLine{text: fmt.Sprintf("Items   %4d%%", t.Items)},
+		panelLine{text: fmt.Sprintf("Secret  %4d%%", t.Secrets)},
+		panelLine{text: "Time    " + t.Time})
+	if t.Par != "" {
+		lines = append(lines, panelLine{text: "Par     " + t.Par})
+	}
+	return lines
+}
+
+// levelTally is the intermission's tally of a level: the percentages of
+// monsters killed, items picked up and secrets found, and the times.
+type levelTally struct {
+	Level   string `json:"level"`
+	Kills   int    `json:"kills_percent"`
+	Items   int    `json:"items_percent"`
+	Secrets int    `json:"secrets_percent"`
+	Time    string `json:"time"`
+	Par     string `json:"par,omitempty"`
+}
+
+// tally reads the intermission's tally, if img is the intermission. While
+// it counts up the numbers read are the counts so far.
+func (s *textScreens) tally(img *image.RGBA) (levelTally, bool) {
+	kills := s.patch("WIOSTK")
+	if kills == nil || !s.match(img, kills, wiStatsX, wiStatsY, 0, 2) {
+		return levelTally{}, false
+	}
+	s.pal = 0
+	t := levelTally{Level: s.levelName(img)}
+	lh := 3 * s.patch("WINUM0").h / 2
+	for i, v := range []*int{&t.Kills, &t.Items, &t.Secrets} {
+		// nothing read is 0
+		*v, _ = strconv.Atoi(s.readNumber(img, 320-wiStatsX, wiStatsY+i*lh, nil))
+	}
+	colon := map[rune]*patch{':': s.patch("WICOLON")}
+	t.Time = s.readNumber(img, 160-wiTimeX, wiTimeY, colon)
+	if s.match(img, s.patch("WIPAR"), 160+wiTimeX, wiTimeY, 0, 2) {
+		t.Par = s.readNumber(img, 320-wiTimeX, wiTimeY, colon)
+	}
+	return t, true
+}
+
+// levelName finds which level the intermission is about from the name
+// graphic at the top.
+func (s *textScreens) levelName(img *image.RGBA) string {
+	for _, kind := range []string{"WILV", "CWILV"} {
+		for n := 0; n < 40; n++ {
+			lump := fmt.Sprintf("%s%02d", kind, n)
+			if kind == "WILV" {
+				lump = fmt.Sprintf("WILV%d%d", n/10, n%10)
+			}
+			p := s.patch(lump)
+			if p == nil {
+				continue
+			}
+			// finished at the top, or entering below
+			for _, y := range []int{2, wiStatsY - 30} {
+				if s.match(img, p, (320-p.w)/2, y, 0, 2) {
+					if kind == "WILV" {
+						return fmt.Sprintf("E%dM%d", n/10+1, n%10+1)
+					}
+					return "MAP" + strconv.Itoa(n+1)
+				}
+			}
+		}
+	}
+	return "Level complete"
+}
+
+// Where the finale's text starts and its line spacing.
+const (
+	finaleX    = 10
+	finaleY    = 10
+	finaleLine = 11
+)
+
+func (s *textScreens) readFinale(img *image.RGBA) []panelLine {
+	s.pal = 0
+	first := s.readText(img, finaleX, finaleY)
+	if len(first) < 3 {
+		return nil
+	}
+	lines := []panelLine{{text: first}}
+	blank := 0
+	for y := finaleY + finaleLine; y+finaleLine <= 200 && blank < 2; y += finaleLine {
+		t := s.readText(img, finaleX, y)
+		if t == "" {
+			blank++
+		} else {
+			blank = 0
+		}
+		lines = append(lines, panelLine{text: t})
+	}
+	for len(lines) > 0 && lines[len(lines)-1].text == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+var (
+	panelFG  = color.RGBA{230, 230, 230, 255}
+	panelDim = color.RGBA{200, 40, 40, 255}
+	panelSel = color.RGBA{255, 220, 60, 255}
+	panelBG  = color.RGBA{16, 16, 16, 255}
+)
+
+// drawPanel draws lines in a bordered box centered on g.
+func drawPanel(g *grid, lines []panelLine) {
+	w := 0
+	for _, l := range lines {
+		w = max(w, len([]rune(l.text))+2)
+	}
+	w = min(w+4, g.w)
+	h := min(len(lines)+2, g.h)
+	x0, y0 := (g.w-w)/2, (g.h-h)/2
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ch := ' '
+			switch {
+			case y == 0 || y == h-1:
+				ch = '─'
+				if x == 0 || x == w-1 {
+					ch = map[bool]rune{true: '┌', false: '└'}[y == 0]
+					if x == w-1 {
+						ch = map[bool]rune{true: '┐', false: '┘'}[y == 0]
+					}
+				}
+			case x == 0 || x == w-1:
+				ch = '│'
+			}
+			g.set(x0+x, y0+y, cell{ch: ch, fg: panelFG, bg: panelBG})
+		}
+	}
+	for i, l := range lines {
+		if i+1 >= h-1 {
+			break
+		}
+		fg, text := panelFG, "  "+l.text
+		switch {
+		case l.selected:
+			fg, text = panelSel, "▶ "+l.text
+		case l.dim:
+			fg = panelDim
+		}
+		for j, r := range []rune(text) {
+			if 2+j >= w-1 {
+				break
+			}
+			g.set(x0+1+j, y0+1+i, cell{ch: r, fg: fg, bg: panelBG})
+		}
+	}
+}
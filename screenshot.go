@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// saveScreenshot writes frame, the engine's picture, as a PNG into dir,
+// named by the time, and beside it g, the cells on the screen, as ANSI
+// text to cat, unless g is nil as it is for graphics renderers. It
+// returns the name without extension.
+func saveScreenshot(dir string, frame *image.RGBA, g *grid, mode colorMode, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	name := filepath.Join(dir, "termdoom-"+now.Format("20060102-150405.000"))
+	if err := writePNG(name+".png", frame); err != nil {
+		return "", err
+	}
+	if g == nil {
+		return name, nil
+	}
+	var b bytes.Buffer
+	writeGrid(&b, g, mode, 0)
+	b.WriteString("\x1b[0m")
+	return name, os.WriteFile(name+".ans", b.Bytes(), 0o644)
+}
+
+func writePNG(path string, img image.Image) error {
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b.Bytes(), 0o644)
+}
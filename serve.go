@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AndreRenaud/gore"
+)
+
+// servePage is the browser player: xterm.js, served with it, as the
+// terminal, with keys and its size sent back. Input messages start with i, sizes
+// with r; everything from the server is terminal output.
+const servePage = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>termdoom</title>
+<link rel="stylesheet" href="/xterm.min.css">
+<script src="/xterm.min.js"></script>
+<script src="/addon-fit.min.js"></script>
+<style>html, body, #term { margin: 0; height: 100%; background: #000; overflow: hidden; }</style>
+</head>
+<body>
+<div id="term"></div>
+<script>
+const term = new Terminal({fontSize: 10, scrollback: 0, allowProposedApi: true});
+const fit = new FitAddon.FitAddon();
+term.loadAddon(fit);
+term.open(document.getElementById("term"));
+fit.fit();
+const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws");
+ws.binaryType = "arraybuffer";
+const send = s => { if (ws.readyState === WebSocket.OPEN) ws.send(s); };
+ws.onopen = () => { send("r" + term.cols + "," + term.rows); term.focus(); };
+ws.onmessage = e => term.write(new Uint8Array(e.data));
+ws.onclose = () => term.write("\x1b[0m\r\n[game over or disconnected]\r\n");
+term.onData(d => send("i" + d));
+term.onBinary(d => send("i" + d));
+term.onResize(s => send("r" + s.cols + "," + s.rows));
+window.addEventListener("resize", () => fit.fit());
+</script>
+</body>
+</html>
+`
+
+// webAssets are the scripts and styles the page loads, by path, and where
+// on the CDN web/fetch.sh vendors them from. Until they are vendored, the
+// page is sent to the CDN for them.
+var webAssets = map[string]string{
+	"/xterm.min.css":    "https://cdn.jsdelivr.net/npm/@xterm/xterm@5.5.0/css/xterm.min.css",
+	"/xterm.min.js":     "https://cdn.jsdelivr.net/npm/@xterm/xterm@5.5.0/lib/xterm.min.js",
+	"/addon-fit.min.js": "https://cdn.jsdelivr.net/npm/@xterm/addon-fit@0.10.0/lib/addon-fit.min.js",
+}
+
+//go:generate sh web/fetch.sh
+//go:embed web
+var webFiles embed.FS
+
+// handleAssets serves webAssets on mux.
+func handleAssets(mux *http.ServeMux) {
+	for path, cdn := range webAssets {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			data, err := webFiles.ReadFile("web" + path)
+			if err != nil {
+				http.Redirect(w, r, cdn, http.StatusFound)
+				return
+			}
+			http.ServeContent(w, r, path, time.Time{}, bytes.NewReader(data))
+		})
+	}
+}
+
+// webTerminal is a browser standing in for the terminal in termdoom serve.
+// Standard input and output become pipes to its WebSocket, so the game
+// runs as it would in a terminal, xterm.js answering its queries.
+type webTerminal struct {
+	mu   sync.Mutex
+	w, h int
+	ws   *wsConn
+	// out is standard output's pipe; done is closed once all written
+	// to it has gone to the browser
+	out  *os.File
+	done chan struct{}
+}
+
+// serveWeb serves the player on addr, waiting for a browser to open it,
+// then plays to that browser. There is one game, so one player: others
+// are turned away, and the game ends when its page is closed.
+func serveWeb(addr string) (*webTerminal, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "termdoom: open http://%s/ in a browser to play\n", ln.Addr())
+	t := &webTerminal{done: make(chan struct{})}
+	connected := make(chan struct{}, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, servePage)
+	})
+	handleAssets(mux)
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		t.mu.Lock()
+		busy := t.ws != nil
+		t.mu.Unlock()
+		if busy {
+			http.Error(w, "someone is already playing", http.StatusConflict)
+			return
+		}
+		ws, err := upgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		t.mu.Lock()
+		if t.ws != nil {
+			t.mu.Unlock()
+			ws.close()
+			return
+		}
+		t.ws = ws
+		t.mu.Unlock()
+		if err := t.play(ws); err != nil {
+			// gone before it began; wait for another
+			t.mu.Lock()
+			t.ws = nil
+			t.mu.Unlock()
+			ws.close()
+			return
+		}
+		connected <- struct{}{}
+	})
+	go http.Serve(ln, mux)
+	<-connected
+	return t, nil
+}
+
+// play takes over standard input and output for ws, once it has said its
+// size, and keeps passing keys along until the page goes.
+func (t *webTerminal) play(ws *wsConn) error {
+	for t.w == 0 {
+		op, msg, err := ws.read()
+		if err != nil {
+			return err
+		}
+		if op == wsText {
+			t.message(msg, nil)
+		}
+	}
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	os.Stdin, os.Stdout, t.out = inR, outW, outW
+	termSize = t.size
+	go func() {
+		defer close(t.done)
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := outR.Read(buf)
+			if err != nil {
+				return
+			}
+			ws.write(wsBinary, buf[:n])
+		}
+	}()
+	go func() {
+		for {
+			op, msg, err := ws.read()
+			if err != nil {
+				// the page went: as with a terminal closed, the game ends
+				inW.Close()
+				gore.Stop()
+				return
+			}
+			if op == wsText {
+				t.message(msg, inW)
+			}
+		}
+	}()
+	return nil
+}
+
+// message handles one message from the page: keys, written to in, or
+// its size.
+func (t *webTerminal) message(msg []byte, in *os.File) {
+	if len(msg) == 0 {
+		return
+	}
+	switch msg[0] {
+	case 'i':
+		if in != nil {
+			in.Write(msg[1:])
+		}
+	case 'r':
+		if w, h, ok := parseWebSize(msg[1:]); ok {
+			t.mu.Lock()
+			t.w, t.h = w, h
+			t.mu.Unlock()
+		}
+	}
+}
+
+// parseWebSize reads the page's "cols,rows", which must be within
+// maxCols and maxRows as a remote player's terminal is.
+func parseWebSize(b []byte) (w, h int, ok bool) {
+	cols, rows, ok := strings.Cut(string(b), ",")
+	w, err1 := strconv.Atoi(cols)
+	h, err2 := strconv.Atoi(rows)
+	return w, h, ok && err1 == nil && err2 == nil && w > 0 && h > 0 && w <= maxCols && h <= maxRows
+}
+
+// size is the page's terminal size, for termSize.
+func (t *webTerminal) size() (w, h int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.w == 0 {
+		return 0, 0, errors.New("no size yet")
+	}
+	return t.w, t.h, nil
+}
+
+// close sends what is left of the output and closes the page's connection.
+func (t *webTerminal) close() {
+	t.out.Close()
+	<-t.done
+	t.ws.close()
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestParseWebSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		w, h int
+		ok   bool
+	}{
+		{"80,24", 80, 24, true},
+		{"1000,500", 1000, 500, true},
+		{"1001,500", 0, 0, false},
+		{"1000,501", 0, 0, false},
+		{"100000,100000", 0, 0, false},
+		{"0,24", 0, 0, false},
+		{"80,-1", 0, 0, false},
+		{"80", 0, 0, false},
+		{"80,x", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		w, h, ok := parseWebSize([]byte(tt.in))
+		if ok != tt.ok || ok && (w != tt.w || h != tt.h) {
+			t.Errorf("parseWebSize(%q) = %d, %d, %v, want %d, %d, %v", tt.in, w, h, ok, tt.w, tt.h, tt.ok)
+		}
+	}
+}
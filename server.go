@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// rotation starts the games of --map-rotation on each map in turn; nil
+// without it, for the engine's usual start.
+var rotation *mapRotation
+
+// mapRotation is the maps given to --map-rotation, as -warp arguments.
+type mapRotation struct {
+	mu   sync.Mutex
+	maps [][]string
+	next int
+}
+
+// parseMapRotation reads a list of maps, such as E1M1,E1M2 or
+// MAP01,MAP02.
+func parseMapRotation(s string) (*mapRotation, error) {
+	r := &mapRotation{}
+	for _, name := range strings.Split(s, ",") {
+		warp, err := warpArgs(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		r.maps = append(r.maps, warp)
+	}
+	return r, nil
+}
+
+// warpArgs are the engine arguments starting on the named map.
+func warpArgs(name string) ([]string, error) {
+	upper := strings.ToUpper(name)
+	var e, m int
+	if n, err := fmt.Sscanf(upper, "E%dM%d", &e, &m); err == nil && n == 2 && fmt.Sprintf("E%dM%d", e, m) == upper && e >= 1 && m >= 1 {
+		return []string{"-warp", strconv.Itoa(e), strconv.Itoa(m)}, nil
+	}
+	if num, ok := strings.CutPrefix(upper, "MAP"); ok {
+		if m, err := strconv.Atoi(num); err == nil && m >= 1 && m <= 99 {
+			return []string{"-warp", strconv.Itoa(m)}, nil
+		}
+	}
+	return nil, fmt.Errorf("%q is not a map such as E1M1 or MAP01", name)
+}
+
+// warp is the arguments for the next game's map.
+func (r *mapRotation) warp() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w := r.maps[r.next]
+	r.next = (r.next + 1) % len(r.maps)
+	return w
+}
+
+// runServers runs the servers for remote players opts asks for, and with
+// web, for browsers on --listen, until one of them fails. The IWAD for
+// their games is found now, from args, the engine arguments.
+func runServers(opts options, args []string, web bool) {
+	if opts.mapRotation != "" {
+		// checked with the flags
+		rotation, _ = parseMapRotation(opts.mapRotation)
+	}
+	args = chooseIWAD(args)
+	i := slices.IndexFunc(args, func(a string) bool { return strings.EqualFold(a, "-iwad") })
+	if i < 0 || i+1 >= len(args) {
+		fmt.Fprintln(os.Stderr, "termdoom: no IWAD found for the games")
+		os.Exit(1)
+	}
+	iwad, err := filepath.Abs(args[i+1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "termdoom:", err)
+		os.Exit(1)
+	}
+	// the games run in home, where a relative config isn't
+	config := opts.config
+	if config != "" {
+		if config, err = filepath.Abs(config); err != nil {
+			fmt.Fprintln(os.Stderr, "termdoom:", err)
+			os.Exit(1)
+		}
+	}
+	home, err := os.MkdirTemp("", "termdoom-players-")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "termdoom:", err)
+		os.Exit(1)
+	}
+	remoteGames.iwad, remoteGames.config, remoteGames.home = iwad, config, home
+	failed := make(chan error)
+	if opts.sshListen != "" {
+		go func() { failed <- fmt.Errorf("ssh: %v", serveSSH(opts)) }()
+	}
+	if opts.telnetListen != "" {
+		go func() { failed <- fmt.Errorf("telnet: %v", serveTelnet(opts)) }()
+	}
+	if web {
+		go func() { failed <- fmt.Errorf("web: %v", serveWebGames(opts)) }()
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	code := 0
+	select {
+	case err := <-failed:
+		fmt.Fprintln(os.Stderr, "termdoom:", err)
+		code = 1
+	case <-stop:
+	}
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+// serveWebGames is termdoom server's browser side: the page termdoom
+// serve has, but with a game for every browser opening it, each on a
+// terminal of its own as for --ssh-listen.
+func serveWebGames(opts options) error {
+	ln, err := net.Listen("tcp", opts.listen)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "termdoom: web: playing on http://%s/\n", ln.Addr())
+	var sessions atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, servePage)
+	})
+	handleAssets(mux)
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		if sessions.Add(1) > int32(opts.webMaxSessions) {
+			sessions.Add(-1)
+			http.Error(w, "all games are taken, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		defer sessions.Add(-1)
+		ws, err := upgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		defer ws.close()
+		fmt.Fprintf(os.Stderr, "termdoom: web: %s playing\n", r.RemoteAddr)
+		webSession(ws)
+		fmt.Fprintf(os.Stderr, "termdoom: web: %s gone\n", r.RemoteAddr)
+	})
+	return http.Serve(ln, mux)
+}
+
+// webSession runs one game for ws, once the page has said its size.
+func webSession(ws *wsConn) {
+	var w, h int
+	for w == 0 {
+		op, msg, err := ws.read()
+		if err != nil {
+			return
+		}
+		if op == wsText && len(msg) > 0 && msg[0] == 'r' {
+			if cols, rows, ok := parseWebSize(msg[1:]); ok {
+				w, h = cols, rows
+			}
+		}
+	}
+	// xterm.js takes 24-bit color
+	game, err := startGame("xterm-256color", w, h, 0, 0, []string{"COLORTERM=truecolor"})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "termdoom: web: %v\n", err)
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := game.pty.Read(buf)
+			if err != nil {
+				break
+			}
+			if ws.write(wsBinary, buf[:n]) != nil {
+				break
+			}
+		}
+		game.wait()
+		// ends the reads below
+		ws.close()
+	}()
+	for {
+		op, msg, err := ws.read()
+		if err != nil {
+			break
+		}
+		if op != wsText || len(msg) == 0 {
+			continue
+		}
+		switch msg[0] {
+		case 'i':
+			game.pty.Write(msg[1:])
+		case 'r':
+			if w, h, ok := parseWebSize(msg[1:]); ok {
+				game.resize(w, h, 0, 0)
+			}
+		}
+	}
+	// the page went
+	game.kill()
+	<-done
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// settingsMenu changes the picture settings, and always run, while playing. Each change
+// takes effect on the next frame and is written to the config file, so
+// it sticks for the next game too.
+type settingsMenu struct {
+	on     bool
+	sel    int
+	items  []settingItem
+	config string
+	status string
+}
+
+// settingItem is a flag the menu cycles through values of.
+type settingItem struct {
+	flag, label string
+	values      []string
+	cur         int
+}
+
+// settingsFPS are the frame rate caps offered, 0 being none.
+var settingsFPS = []string{"15", "20", "30", "35", "60", "0"}
+
+func newSettingsMenu(opts options, caps termCaps) *settingsMenu {
+	var rs []string
+	for _, name := range rendererNames {
+		// graphics only where the terminal said it has them
+		r := renderers[name]
+		if r.encode == nil || name == opts.renderer ||
+			name == "kitty" && caps.kittyGraphics || name == "iterm" && caps.iterm || name == "sixel" && caps.sixel {
+			rs = append(rs, name)
+		}
+	}
+	palettes := []string{"none"}
+	if opts.palette != "" {
+		palettes = append(palettes, opts.palette)
+	}
+	// and any in a palettes directory next to the config file
+	if opts.config != "" {
+		files, _ := filepath.Glob(filepath.Join(filepath.Dir(opts.config), "palettes", "*"))
+		for _, f := range files {
+			if f != opts.palette {
+				palettes = append(palettes, f)
+			}
+		}
+	}
+	palette := opts.palette
+	if palette == "" {
+		palette = "none"
+	}
+	m := &settingsMenu{config: opts.config}
+	if opts.remote {
+		m.config = ""
+	}
+	m.add("renderer", "Renderer", rs, opts.renderer)
+	m.add("palette", "Palette", palettes, palette)
+	m.add("dither", "Dithering", []string{"fs", "bayer", "none"}, opts.dither)
+	m.add("scale", "Scale", []string{"fit", "fill", "stretch", "integer"}, opts.scale)
+	m.add("fps", "FPS cap", settingsFPS, strconv.Itoa(opts.fps))
+	m.add("always-run", "Always run", []string{"false", "true"}, strconv.FormatBool(opts.alwaysRun))
+	return m
+}
+
+// set shows value for flag after it was changed some other way.
+func (m *settingsMenu) set(flag, value string) {
+	for i := range m.items {
+		if it := &m.items[i]; it.flag == flag {
+			it.cur = max(slices.Index(it.values, value), 0)
+		}
+	}
+}
+
+// add adds an item showing cur, which joins values if it isn't one.
+func (m *settingsMenu) add(flag, label string, values []string, cur string) {
+	i := slices.Index(values, cur)
+	if i < 0 {
+		values = append(values, cur)
+		i = len(values) - 1
+	}
+	m.items = append(m.items, settingItem{flag: flag, label: label, values: values, cur: i})
+}
+
+// key handles a key while the menu is open. A changed setting is passed
+// to apply, then saved if apply accepts it.
+func (m *settingsMenu) key(seq []byte, apply func(flag, value string) error) {
+	step := 0
+	switch string(seq) {
+	case "\x1b[A":
+		m.sel = (m.sel + len(m.items) - 1) % len(m.items)
+	case "\x1b[B":
+		m.sel = (m.sel + 1) % len(m.items)
+	case "\x1b[D":
+		step = -1
+	case "\x1b[C", "\r", " ":
+		step = 1
+	case "\x1b":
+		m.on = false
+	}
+	if step == 0 {
+		return
+	}
+	it := &m.items[m.sel]
+	prev := it.cur
+	it.cur = (it.cur + step + len(it.values)) % len(it.values)
+	value := it.values[it.cur]
+	if err := apply(it.flag, value); err != nil {
+		it.cur = prev
+		m.status = err.Error()
+		return
+	}
+	if it.flag == "palette" && value == "none" {
+		value = ""
+	}
+	if m.config == "" {
+		m.status = "for this game only"
+		return
+	}
+	if err := updateConfig(m.config, "", []configEntry{{key: it.flag, value: value}}); err != nil {
+		m.status = "not saved: " + err.Error()
+		return
+	}
+	m.status = "saved to " + m.config
+}
+
+// lines lays the menu out for drawPanel.
+func (m *settingsMenu) lines() []panelLine {
+	lines := []panelLine{{text: "Settings", dim: true}, {}}
+	for i, it := range m.items {
+		v := it.values[it.cur]
+		if it.flag == "palette" && v != "none" {
+			v = filepath.Base(v)
+		}
+		if it.flag == "fps" && v == "0" {
+			v = "no cap"
+		}
+		if it.flag == "always-run" {
+			v = map[string]string{"false": "off", "true": "on"}[v]
+		}
+		lines = append(lines, panelLine{text: fmt.Sprintf("%-10s ◀ %s ▶", it.label, v), selected: i == m.sel})
+	}
+	lines = append(lines, panelLine{}, panelLine{text: "↑↓ choose  ←→ change  Esc close", dim: true})
+	if m.status != "" {
+		lines = append(lines, panelLine{text: m.status, dim: true})
+	}
+	return lines
+}
+
+// applySetting makes a settings menu change take effect.
+func (t *termDoom) applySetting(flag, value string) error {
+	switch flag {
+	case "renderer":
+		if old := t.renderer; old.cleanup != nil {
+			// take the old renderer's images off the screen
+			var raw, b bytes.Buffer
+			old.cleanup(&raw)
+			t.passthrough.wrap(&b, raw.Bytes())
+			t.pace.write(b.Bytes())
+		}
+		t.renderer = renderers[value]
+		t.passthrough = muxNone
+		if t.renderer.encode != nil && !(value == "sixel" && t.caps.sixel) {
+			t.passthrough = t.mux
+		}
+	case "palette":
+		if value == "none" {
+			t.remap = nil
+			break
+		}
+		remap, err := loadPalette(value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(value), err)
+		}
+		t.remap = remap
+	case "dither":
+		t.dither = ditherer{kind: value}
+	case "scale":
+		t.scale = value
+	case "fps":
+		fps, _ := strconv.Atoi(value)
+		t.pace.setFPS(fps)
+	case "always-run":
+		t.setAlwaysRun(value == "true")
+		return nil
+	}
+	// start over on a clean screen
+	t.lastW = 0
+	t.shadowValid = false
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The shareware DOOM, version 1.9, which id Software let be passed
+// around freely, and where it is downloaded from.
+const (
+	sharewareURL    = "https://distro.ibiblio.org/slitaz/sources/packages/d/doom1.wad"
+	sharewareSHA256 = "1d7d43be501e67d927e415e0b8f3e29c3bf33075e859721816f652a526cac771"
+	sharewareSize   = 4196020
+)
+
+// offerShareware asks to download the shareware IWAD, for when there is
+// none, into gameDataDir where it is found from then on. It returns its
+// path, or "" if declined or the download failed.
+func offerShareware() string {
+	dir := gameDataDir()
+	if dir == "" {
+		return ""
+	}
+	fmt.Fprintf(os.Stderr, "termdoom: no IWAD found. Download the shareware DOOM (doom1.wad, 4 MB) to %s? [y/N] ", dir)
+	line, _ := readLine(os.Stdin)
+	if answer := strings.ToLower(strings.TrimSpace(line)); answer != "y" && answer != "yes" {
+		return ""
+	}
+	path, err := downloadShareware(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\ntermdoom: download failed: %v\n", err)
+		return ""
+	}
+	fmt.Fprintf(os.Stderr, "\ntermdoom: saved %s\n", path)
+	return path
+}
+
+// downloadShareware downloads doom1.wad into dir, keeping it only if its
+// checksum is the release's.
+func downloadShareware(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(sharewareURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", sharewareURL, resp.Status)
+	}
+	f, err := os.CreateTemp(dir, "doom1.wad.*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	sum := sha256.New()
+	progress := &downloadProgress{total: sharewareSize}
+	// a little over the size, so a longer file fails the checksum
+	_, err = io.Copy(io.MultiWriter(f, sum, progress), io.LimitReader(resp.Body, sharewareSize+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if got := hex.EncodeToString(sum.Sum(nil)); got != sharewareSHA256 {
+		return "", fmt.Errorf("checksum mismatch: got sha256 %s, want %s", got, sharewareSHA256)
+	}
+	path := filepath.Join(dir, "doom1.wad")
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// downloadProgress shows how much of a download has come.
+type downloadProgress struct {
+	total, n int64
+	shown    int64
+}
+
+func (p *downloadProgress) Write(b []byte) (int, error) {
+	p.n += int64(len(b))
+	if pct := p.n * 100 / p.total; pct != p.shown {
+		p.shown = pct
+		fmt.Fprintf(os.Stderr, "\r%d%%", min(pct, 100))
+	}
+	return len(b), nil
+}
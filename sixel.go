@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strconv"
+)
+
+// Assumed cell size in pixels when the terminal doesn't report one.
+const (
+	defaultCellPxW = 8
+	defaultCellPxH = 16
+)
+
+// sixelEncoder keeps its scratch buffers between frames.
+type sixelEncoder struct {
+	scaler  scaler
+	index   []uint8
+	palette []color.RGBA
+	lookup  map[color.RGBA]uint8
+	row     []byte
+}
+
+// encode scales img to the pixel area of cols×rows cells and writes it
+// as a sixel image at the cursor.
+func (s *sixelEncoder) encode(b *bytes.Buffer, img *image.RGBA, cols, rows int) {
+	cw, ch, ok := cellPixels()
+	if !ok {
+		cw, ch = defaultCellPxW, defaultCellPxH
+	}
+	// sixel bands are six pixels tall; round down so we never scroll
+	pw, ph := cols*cw, (rows*ch)/6*6
+	s.quantize(s.scaler.scale(img, pw, ph, false))
+
+	b.WriteString("\x1bPq\"1;1;")
+	b.WriteString(strconv.Itoa(pw))
+	b.WriteByte(';')
+	b.WriteString(strconv.Itoa(ph))
+	for i, c := range s.palette {
+		// sixel color components are percentages
+		b.WriteByte('#')
+		b.WriteString(strconv.Itoa(i))
+		b.WriteString(";2;")
+		b.WriteString(strconv.Itoa(int(c.R) * 100 / 255))
+		b.WriteByte(';')
+		b.WriteString(strconv.Itoa(int(c.G) * 100 / 255))
+		b.WriteByte(';')
+		b.WriteString(strconv.Itoa(int(c.B) * 100 / 255))
+	}
+
+	if cap(s.row) < pw {
+		s.row = make([]byte, pw)
+	}
+	row := s.row[:pw]
+	var used [256]bool
+	for top := 0; top < ph; top += 6 {
+		used = [256]bool{}
+		for y := top; y < top+6; y++ {
+			for _, p := range s.index[y*pw : (y+1)*pw] {
+				used[p] = true
+			}
+		}
+		first := true
+		for c := range s.palette {
+			if !used[c] {
+				continue
+			}
+			for x := range row {
+				row[x] = 0
+			}
+			for dy := 0; dy < 6; dy++ {
+				line := s.index[(top+dy)*pw : (top+dy+1)*pw]
+				for x, p := range line {
+					if int(p) == c {
+						row[x] |= 1 << dy
+					}
+				}
+			}
+			if !first {
+				// back to the start of the band for the next color
+				b.WriteByte('$')
+			}
+			first = false
+			b.WriteByte('#')
+			b.WriteString(strconv.Itoa(c))
+			writeSixelRow(b, row)
+		}
+		b.WriteByte('-')
+	}
+	b.WriteString("\x1b\\")
+}
+
+// writeSixelRow run-length encodes one color's bits for a six-pixel band.
+func writeSixelRow(b *bytes.Buffer, row []byte) {
+	for i := 0; i < len(row); {
+		j := i + 1
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		ch := row[i] + '?'
+		if n := j - i; n > 3 {
+			b.WriteByte('!')
+			b.WriteString(strconv.Itoa(n))
+			b.WriteByte(ch)
+		} else {
+			for ; n > 0; n-- {
+				b.WriteByte(ch)
+			}
+		}
+		i = j
+	}
+}
+
+// quantize fills s.index and s.palette for img. DOOM frames use at most 256
+// distinct colors, so the exact colors are used as the palette; anything
+// busier falls back to a fixed 3-3-2 bit palette.
+func (s *sixelEncoder) quantize(img *image.RGBA) {
+	n := img.Rect.Dx() * img.Rect.Dy()
+	if cap(s.index) < n {
+		s.index = make([]uint8, n)
+	}
+	s.index = s.index[:n]
+	if s.lookup == nil {
+		s.lookup = make(map[color.RGBA]uint8, 256)
+	}
+	clear(s.lookup)
+	s.palette = s.palette[:0]
+
+	w := img.Rect.Dx()
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < w; x++ {
+			o := y*img.Stride + x*4
+			c := color.RGBA{img.Pix[o], img.Pix[o+1], img.Pix[o+2], 255}
+			i, ok := s.lookup[c]
+			if !ok {
+				if len(s.palette) == 256 {
+					s.quantize332(img)
+					return
+				}
+				i = uint8(len(s.palette))
+				s.lookup[c] = i
+				s.palette = append(s.palette, c)
+			}
+			s.index[y*w+x] = i
+		}
+	}
+}
+
+func (s *sixelEncoder) quantize332(img *image.RGBA) {
+	s.palette = s.palette[:0]
+	for i := 0; i < 256; i++ {
+		s.palette = append(s.palette, color.RGBA{
+			uint8((i >> 5) * 255 / 7),
+			uint8((i >> 2 & 7) * 255 / 7),
+			uint8((i & 3) * 255 / 3),
+			255,
+		})
+	}
+	w := img.Rect.Dx()
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < w; x++ {
+			o := y*img.Stride + x*4
+			s.index[y*w+x] = img.Pix[o]&0xe0 | img.Pix[o+1]>>5<<2 | img.Pix[o+2]>>6
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spectatorBacklog is how many writes a spectator can fall behind by
+// before it skips frames and is caught up with a whole one.
+const spectatorBacklog = 16
+
+// spectators passes everything the game draws on to read-only watchers
+// connected over --spectate-listen. Diff updates only make sense on top
+// of the frame before, so a watcher gets nothing until a whole frame is
+// drawn: catchUp, asked each frame, says when one is wanted.
+type spectators struct {
+	l       net.Listener
+	mu      sync.Mutex
+	clients map[*spectator]bool
+	want    bool // someone is waiting for a whole frame
+	wg      sync.WaitGroup
+}
+
+type spectator struct {
+	conn   net.Conn
+	frames chan []byte
+	synced bool // whether it has had a whole frame since joining or falling behind
+}
+
+// listenSpectators accepts watchers on addr, a TCP address or unix: and
+// a socket's path.
+func listenSpectators(addr string) (*spectators, error) {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+		// a socket left behind by a game that didn't get to remove it
+		if fi, err := os.Lstat(path); err == nil && fi.Mode().Type() == fs.ModeSocket {
+			os.Remove(path)
+		}
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &spectators{l: l, clients: make(map[*spectator]bool)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			} else if err != nil {
+				continue
+			}
+			s.join(conn)
+		}
+	}()
+	return s, nil
+}
+
+// join starts sending to conn, once the next whole frame is drawn.
+func (s *spectators) join(conn net.Conn) {
+	c := &spectator{conn: conn, frames: make(chan []byte, spectatorBacklog)}
+	w, h, err := termSize()
+	if err != nil {
+		w, h = 80, 24
+	}
+	// ask the watcher's terminal for the game's size, which not all do,
+	// then set it up as ours is
+	c.frames <- fmt.Appendf(nil, "\x1b[8;%d;%dt\x1b[?1049h\x1b[2J\x1b[H\x1b[?25l", h, w)
+	s.mu.Lock()
+	s.clients[c] = true
+	s.want = true
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer conn.Close()
+		for b := range c.frames {
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if _, err := conn.Write(b); err != nil {
+				s.leave(c)
+				// let close or leave's close of frames end the loop
+				for range c.frames {
+				}
+				return
+			}
+		}
+	}()
+	go func() {
+		// watchers only watch: what they type is dropped, and their
+		// hanging up is noticed here
+		io.Copy(io.Discard, conn)
+		s.leave(c)
+	}()
+}
+
+// leave stops sending to c.
+func (s *spectators) leave(c *spectator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[c] {
+		delete(s.clients, c)
+		close(c.frames)
+	}
+}
+
+// Write sends b to every watcher up to date; one too far behind skips
+// what follows until it is caught up.
+func (s *spectators) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var frame []byte
+	for c := range s.clients {
+		if !c.synced {
+			continue
+		}
+		if frame == nil {
+			frame = append([]byte(nil), b...)
+		}
+		select {
+		case c.frames <- frame:
+		default:
+			c.synced = false
+			s.want = true
+		}
+	}
+	return len(b), nil
+}
+
+// catchUp reports whether the frame about to be drawn should be a whole
+// one, for watchers that joined or fell behind. The frames before it have
+// all been written, so the next Write is that frame.
+func (s *spectators) catchUp() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.want {
+		return false
+	}
+	s.want = false
+	for c := range s.clients {
+		c.synced = true
+	}
+	return true
+}
+
+// close stops taking watchers and tells those watching that the game is
+// over, putting their terminals back as ours is.
+func (s *spectators) close() {
+	s.l.Close()
+	s.mu.Lock()
+	for c := range s.clients {
+		select {
+		case c.frames <- []byte("\x1b[0m\x1b[2J\x1b[H\x1b[?25h\x1b[?1049lgame over\r\n"):
+		default:
+		}
+		delete(s.clients, c)
+		close(c.frames)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
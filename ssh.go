@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultHostKeyPath is where --ssh-listen keeps its host key unless told,
+// next to the config file.
+func defaultHostKeyPath() string {
+	if p := defaultConfigPath(); p != "" {
+		return filepath.Join(filepath.Dir(p), "ssh_host_ed25519_key")
+	}
+	return "ssh_host_ed25519_key"
+}
+
+// loadHostKey reads the server's host key from path, making one the first
+// time so clients see the same key every time after.
+func loadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		block, err := ssh.MarshalPrivateKey(key, "termdoom host key")
+		if err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(block)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "termdoom: ssh: made a new host key, %s\n", path)
+	} else if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(data)
+}
+
+// serveSSH is --ssh-listen: anyone connecting plays a game of their own,
+// sized to their terminal, no password asked. It runs until it can't
+// accept connections any more.
+func serveSSH(opts options) error {
+	key, err := loadHostKey(opts.sshHostKey)
+	if err != nil {
+		return fmt.Errorf("host key: %v", err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(key)
+	ln, err := net.Listen("tcp", opts.sshListen)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "termdoom: ssh: playing on %s, host key %s\n", ln.Addr(), ssh.FingerprintSHA256(key.PublicKey()))
+	var sessions atomic.Int32
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			sc, chans, reqs, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			defer sc.Close()
+			go ssh.DiscardRequests(reqs)
+			for nc := range chans {
+				if nc.ChannelType() != "session" {
+					nc.Reject(ssh.UnknownChannelType, "only sessions here")
+					continue
+				}
+				if sessions.Add(1) > int32(opts.sshMaxSessions) {
+					sessions.Add(-1)
+					nc.Reject(ssh.ResourceShortage, "all games are taken, try again later")
+					continue
+				}
+				ch, chReqs, err := nc.Accept()
+				if err != nil {
+					sessions.Add(-1)
+					continue
+				}
+				go func() {
+					defer sessions.Add(-1)
+					fmt.Fprintf(os.Stderr, "termdoom: ssh: %s@%s playing\n", sc.User(), sc.RemoteAddr())
+					sshSession(ch, chReqs)
+					fmt.Fprintf(os.Stderr, "termdoom: ssh: %s@%s gone\n", sc.User(), sc.RemoteAddr())
+				}()
+			}
+		}()
+	}
+}
+
+// SSH request payloads, RFC 4254.
+type (
+	ptyRequest struct {
+		Term               string
+		Cols, Rows, XP, YP uint32
+		Modes              string
+	}
+	windowChange struct {
+		Cols, Rows, XP, YP uint32
+	}
+	envRequest struct {
+		Name, Value string
+	}
+	exitStatus struct {
+		Status uint32
+	}
+)
+
+// sshSession runs one game on ch, once the client has asked for a
+// terminal and a shell.
+func sshSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	var pty *ptyRequest
+	var env []string
+	var game *gameProcess
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			pty = new(ptyRequest)
+			ok := ssh.Unmarshal(req.Payload, pty) == nil && game == nil
+			if _, _, _, _, sized := remoteSize(int(pty.Cols), int(pty.Rows), 0, 0); !sized {
+				ok = false
+			}
+			if !ok {
+				pty = nil
+			}
+			req.Reply(ok, nil)
+		case "env":
+			var e envRequest
+			ok := ssh.Unmarshal(req.Payload, &e) == nil && (e.Name == "LANG" || e.Name == "COLORTERM" || e.Name == "TERM_PROGRAM")
+			if ok {
+				env = append(env, e.Name+"="+e.Value)
+			}
+			req.Reply(ok, nil)
+		case "window-change":
+			var wc windowChange
+			if ssh.Unmarshal(req.Payload, &wc) == nil && game != nil {
+				if w, h, xpx, ypx, ok := remoteSize(int(wc.Cols), int(wc.Rows), int(wc.XP), int(wc.YP)); ok {
+					game.resize(w, h, xpx, ypx)
+				}
+			}
+		case "shell", "exec":
+			if game != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			if pty == nil {
+				req.Reply(true, nil)
+				io.WriteString(ch.Stderr(), "termdoom needs a terminal; connect with ssh -t\r\n")
+				ch.SendRequest("exit-status", false, ssh.Marshal(exitStatus{1}))
+				return
+			}
+			var err error
+			w, h, xpx, ypx, _ := remoteSize(int(pty.Cols), int(pty.Rows), int(pty.XP), int(pty.YP))
+			game, err = startGame(pty.Term, w, h, xpx, ypx, env)
+			if err != nil {
+				req.Reply(false, nil)
+				fmt.Fprintf(os.Stderr, "termdoom: ssh: %v\n", err)
+				return
+			}
+			req.Reply(true, nil)
+			go func() {
+				io.Copy(game.pty, ch)
+				// the client went or hung up
+				game.kill()
+			}()
+			go func() {
+				io.Copy(ch, game.pty)
+				status := game.wait()
+				ch.SendRequest("exit-status", false, ssh.Marshal(exitStatus{uint32(max(status, 0))}))
+				ch.Close()
+			}()
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+	if game != nil {
+		game.kill()
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+	runtimemetrics "runtime/metrics"
+	"strings"
+	"time"
+)
+
+// sessionStats gathers the summary shown when the game ends: how long it
+// was played, what was drawn and written, the memory it took and the
+// tally of each level finished, read off the intermission screen.
+type sessionStats struct {
+	start   time.Time
+	frames  int
+	written writeCounter
+	// peak is the most memory the runtime had, checked once a second
+	peak    uint64
+	nextMem time.Time
+	screens *textScreens // nil if the WAD can't be read, for no tallies
+	levels  []levelTally
+	// tallying is whether the last frame was an intermission
+	tallying bool
+}
+
+// sessionReport is the summary, for --stats-json as well.
+type sessionReport struct {
+	Seconds    float64      `json:"seconds"`
+	Frames     int          `json:"frames"`
+	FPS        float64      `json:"average_fps"`
+	Written    uint64       `json:"bytes_written"`
+	PeakMemory uint64       `json:"peak_memory_bytes"`
+	Levels     []levelTally `json:"levels"`
+}
+
+func newSessionStats(w *wad) *sessionStats {
+	s := &sessionStats{start: time.Now(), levels: []levelTally{}}
+	if w != nil {
+		s.screens, _ = newTextScreens(w)
+	}
+	return s
+}
+
+// frame records a drawn frame.
+func (s *sessionStats) frame(img *image.RGBA, now time.Time) {
+	s.frames++
+	if !now.Before(s.nextMem) {
+		s.nextMem = now.Add(time.Second)
+		s.peak = max(s.peak, memoryInUse())
+	}
+	if s.screens == nil {
+		return
+	}
+	t, ok := s.screens.tally(img)
+	switch {
+	case ok && s.tallying:
+		// the count goes on, or was skipped to the end
+		s.levels[len(s.levels)-1] = t
+	case ok:
+		s.levels = append(s.levels, t)
+	}
+	s.tallying = ok
+}
+
+// memoryInUse is the memory the Go runtime has from the system.
+func memoryInUse() uint64 {
+	sample := []runtimemetrics.Sample{{Name: "/memory/classes/total:bytes"}}
+	runtimemetrics.Read(sample)
+	if sample[0].Value.Kind() != runtimemetrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+func (s *sessionStats) report() *sessionReport {
+	d := time.Since(s.start)
+	r := &sessionReport{
+		Seconds:    d.Seconds(),
+		Frames:     s.frames,
+		Written:    s.written.Load(),
+		PeakMemory: max(s.peak, memoryInUse()),
+		Levels:     s.levels,
+	}
+	if d > 0 {
+		r.FPS = float64(s.frames) / d.Seconds()
+	}
+	return r
+}
+
+func (r *sessionReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "played        %s\n", time.Duration(r.Seconds*float64(time.Second)).Round(time.Second))
+	fmt.Fprintf(&b, "frames        %d, %.1f fps average\n", r.Frames, r.FPS)
+	fmt.Fprintf(&b, "written       %.1f MB\n", float64(r.Written)/1e6)
+	fmt.Fprintf(&b, "peak memory   %.1f MB\n", float64(r.PeakMemory)/1e6)
+	for _, l := range r.Levels {
+		fmt.Fprintf(&b, "%-13s kills %d%%, items %d%%, secrets %d%%, time %s", l.Level, l.Kills, l.Items, l.Secrets, l.Time)
+		if l.Par != "" {
+			fmt.Fprintf(&b, ", par %s", l.Par)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// finish prints the summary to stdout, after the terminal is put back,
+// and writes it to jsonPath as well unless that is empty.
+func (s *sessionStats) finish(stdout *os.File, jsonPath string) {
+	r := s.report()
+	fmt.Fprint(stdout, r)
+	if jsonPath == "" {
+		return
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err == nil {
+		err = os.WriteFile(jsonPath, append(data, '\n'), 0o644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "termdoom: stats: %v\n", err)
+	}
+}
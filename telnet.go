@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Telnet commands and options, RFC 854 and on.
+const (
+	telnetSE   = 240
+	telnetIP   = 244
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetBinary = 0
+	telnetEcho   = 1
+	telnetSGA    = 3 // suppress go ahead
+	telnetTType  = 24
+	telnetNAWS   = 31
+)
+
+// telnetHello asks the client to send characters as typed, in 8 bits,
+// leaving echoing to us, and to say its window size and terminal type.
+var telnetHello = []byte{
+	telnetIAC, telnetWILL, telnetEcho,
+	telnetIAC, telnetWILL, telnetSGA, telnetIAC, telnetDO, telnetSGA,
+	telnetIAC, telnetWILL, telnetBinary, telnetIAC, telnetDO, telnetBinary,
+	telnetIAC, telnetDO, telnetNAWS,
+	telnetIAC, telnetDO, telnetTType,
+}
+
+// telnetAsked are the options telnetHello brings up, which the client's
+// answers to are not answered again.
+var telnetAsked = map[byte]bool{telnetEcho: true, telnetSGA: true, telnetBinary: true, telnetNAWS: true, telnetTType: true}
+
+// telnetMaxSB is the longest subnegotiation kept, enough for NAWS's 5
+// bytes or any terminal type; a longer one is dropped.
+const telnetMaxSB = 64
+
+// telnetWait is how long a client has to say its size and type before its
+// game starts without them.
+const telnetWait = time.Second
+
+// telnetEvent is something from the client: typed bytes, a window size or
+// its terminal type.
+type telnetEvent struct {
+	data []byte
+	w, h int
+	term string
+}
+
+// telnetParser takes the commands out of what a client sends.
+type telnetParser struct {
+	conn  net.Conn
+	state byte // 0, or the command byte being read after IAC
+	iac   bool
+	sb    []byte // a subnegotiation being read, its option first
+	inSB  bool
+	cr    bool // the last byte was a CR, whose LF or NUL is dropped
+	data  []byte
+}
+
+// feed parses buf, sending on what it held.
+func (p *telnetParser) feed(buf []byte, events chan<- telnetEvent) {
+	p.data = p.data[:0]
+	for _, b := range buf {
+		switch {
+		case p.state != 0:
+			p.option(p.state, b)
+			p.state = 0
+		case p.iac:
+			p.iac = false
+			switch {
+			case b == telnetIAC && p.inSB:
+				p.sbByte(b)
+			case b == telnetIAC:
+				p.byte(b)
+			case b == telnetSB:
+				p.inSB, p.sb = true, p.sb[:0]
+			case b == telnetSE && p.inSB:
+				p.inSB = false
+				if ev, ok := p.subnegotiation(); ok {
+					events <- ev
+				}
+			case b >= telnetWILL:
+				p.state = b
+			case b == telnetIP:
+				p.byte(0x03) // as Ctrl+C
+			}
+		case b == telnetIAC:
+			p.iac = true
+		case p.inSB:
+			p.sbByte(b)
+		default:
+			p.byte(b)
+		}
+	}
+	if len(p.data) > 0 {
+		events <- telnetEvent{data: bytes.Clone(p.data)}
+	}
+}
+
+// sbByte adds b to the subnegotiation being read, up to one byte over
+// telnetMaxSB, for it to be seen to be too long.
+func (p *telnetParser) sbByte(b byte) {
+	if len(p.sb) <= telnetMaxSB {
+		p.sb = append(p.sb, b)
+	}
+}
+
+// byte takes one typed byte; Enter comes as CR LF or CR NUL, and is just
+// CR to the game as from a terminal.
+func (p *telnetParser) byte(b byte) {
+	if p.cr && (b == '\n' || b == 0) {
+		p.cr = false
+		return
+	}
+	p.cr = b == '\r'
+	p.data = append(p.data, b)
+}
+
+// option answers the client's WILL, WONT, DO or DONT for opt: options we
+// didn't bring up are refused.
+func (p *telnetParser) option(cmd, opt byte) {
+	switch {
+	case cmd == telnetWILL && opt == telnetTType:
+		// now it may be asked which terminal it is
+		p.conn.Write([]byte{telnetIAC, telnetSB, telnetTType, 1, telnetIAC, telnetSE})
+	case telnetAsked[opt]:
+	case cmd == telnetWILL:
+		p.conn.Write([]byte{telnetIAC, telnetDONT, opt})
+	case cmd == telnetDO:
+		p.conn.Write([]byte{telnetIAC, telnetWONT, opt})
+	}
+}
+
+func (p *telnetParser) subnegotiation() (telnetEvent, bool) {
+	switch {
+	case len(p.sb) > telnetMaxSB:
+		return telnetEvent{}, false
+	case len(p.sb) == 5 && p.sb[0] == telnetNAWS:
+		w, h, _, _, ok := remoteSize(int(p.sb[1])<<8|int(p.sb[2]), int(p.sb[3])<<8|int(p.sb[4]), 0, 0)
+		return telnetEvent{w: w, h: h}, ok
+	case len(p.sb) > 2 && p.sb[0] == telnetTType && p.sb[1] == 0:
+		return telnetEvent{term: string(bytes.ToLower(p.sb[2:]))}, true
+	}
+	return telnetEvent{}, false
+}
+
+// serveTelnet is --telnet-listen: everyone connecting plays a game of
+// their own, as with --ssh-listen but in the clear.
+func serveTelnet(opts options) error {
+	ln, err := net.Listen("tcp", opts.telnetListen)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "termdoom: telnet: playing on %s\n", ln.Addr())
+	var sessions atomic.Int32
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if sessions.Add(1) > int32(opts.telnetMaxSessions) {
+				sessions.Add(-1)
+				conn.Write([]byte("All games are taken, try again later.\r\n"))
+				return
+			}
+			defer sessions.Add(-1)
+			fmt.Fprintf(os.Stderr, "termdoom: telnet: %s playing\n", conn.RemoteAddr())
+			telnetSession(conn)
+			fmt.Fprintf(os.Stderr, "termdoom: telnet: %s gone\n", conn.RemoteAddr())
+		}()
+	}
+}
+
+// telnetSession runs one game on conn.
+func telnetSession(conn net.Conn) {
+	conn.Write(telnetHello)
+	events := make(chan telnetEvent, 16)
+	go func() {
+		defer close(events)
+		p := &telnetParser{conn: conn}
+		r := bufio.NewReader(conn)
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				return
+			}
+			p.feed(buf[:n], events)
+		}
+	}()
+
+	// the size and terminal type, or what most terminals will take
+	w, h, term := 80, 24, "xterm"
+	sized, typed := false, false
+	wait := time.After(telnetWait)
+	for !sized || !typed {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.w > 0 {
+				w, h, sized = ev.w, ev.h, true
+			}
+			if ev.term != "" {
+				term, typed = ev.term, true
+			}
+		case <-wait:
+			sized, typed = true, true
+		}
+	}
+	game, err := startGame(term, w, h, 0, 0, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "termdoom: telnet: %v\n", err)
+		fmt.Fprintf(conn, "termdoom: %v\r\n", err)
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := game.pty.Read(buf)
+			if err != nil {
+				break
+			}
+			// a 255 in the game's output would read as a command
+			if _, err := conn.Write(bytes.ReplaceAll(buf[:n], []byte{telnetIAC}, []byte{telnetIAC, telnetIAC})); err != nil {
+				break
+			}
+		}
+		game.wait()
+		conn.Close()
+	}()
+	for ev := range events {
+		switch {
+		case ev.data != nil:
+			game.pty.Write(ev.data)
+		case ev.w > 0:
+			game.resize(ev.w, ev.h, 0, 0)
+		}
+	}
+	// the client went
+	game.kill()
+	<-done
+}
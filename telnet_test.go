@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTelnetParser(t *testing.T) {
+	const iac, sb, se = telnetIAC, telnetSB, telnetSE
+	naws := func(w1, w2, h1, h2 byte) string {
+		return string([]byte{iac, sb, telnetNAWS, w1, w2, h1, h2, iac, se})
+	}
+	tests := []struct {
+		name  string
+		reads []string
+		want  []telnetEvent
+	}{
+		{"typed", []string{"ab"}, []telnetEvent{{data: []byte("ab")}}},
+		{"escaped IAC", []string{"a\xff\xffb"}, []telnetEvent{{data: []byte("a\xffb")}}},
+		{"escaped IAC split", []string{"a\xff", "\xffb"}, []telnetEvent{{data: []byte("a")}, {data: []byte("\xffb")}}},
+		{"enter", []string{"x\r\ny\r\x00z"}, []telnetEvent{{data: []byte("x\ry\rz")}}},
+		{"interrupt", []string{"\xff\xf4"}, []telnetEvent{{data: []byte{0x03}}}},
+		{"NAWS", []string{naws(0, 80, 0, 24)}, []telnetEvent{{w: 80, h: 24}}},
+		{"NAWS split", []string{naws(0, 80, 0, 24)[:4], naws(0, 80, 0, 24)[4:]}, []telnetEvent{{w: 80, h: 24}}},
+		{"NAWS escaped", []string{"\xff\xfa\x1f\x00\xff\xff\x00\x18\xff\xf0"}, []telnetEvent{{w: 255, h: 24}}},
+		{"NAWS clamped", []string{"\xff\xfa\x1f" + strings.Repeat("\xff\xff", 4) + "\xff\xf0"}, []telnetEvent{{w: maxCols, h: maxRows}}},
+		{"NAWS empty", []string{naws(0, 0, 0, 24)}, nil},
+		{"truncated subnegotiation", []string{"\xff\xfa\x1f\x00\x50\xff\xf0z"}, []telnetEvent{{data: []byte("z")}}},
+		{"unended subnegotiation", []string{"\xff\xfa\x1f\x00\x50", "abc"}, nil},
+		{"long subnegotiation", []string{"\xff\xfa\x18\x00" + strings.Repeat("x", 1000), "\xff\xf0k"}, []telnetEvent{{data: []byte("k")}}},
+		{"terminal type", []string{"\xff\xfa\x18\x00XTERM-256color\xff\xf0"}, []telnetEvent{{term: "xterm-256color"}}},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		go io.Copy(io.Discard, client)
+		p := &telnetParser{conn: server}
+		events := make(chan telnetEvent, 16)
+		for _, r := range tt.reads {
+			p.feed([]byte(r), events)
+		}
+		close(events)
+		var got []telnetEvent
+		for ev := range events {
+			got = append(got, ev)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+		if len(p.sb) > telnetMaxSB+1 {
+			t.Errorf("%s: %d bytes of subnegotiation kept", tt.name, len(p.sb))
+		}
+		server.Close()
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// termCaps is what we could find out about the terminal at startup.
+type termCaps struct {
+	kittyGraphics bool
+	sixel         bool
+	iterm         bool
+	// da2 is the terminal type from the secondary device attributes, or -1.
+	da2 int
+	// mux is the multiplexer in between; the device attributes are its
+	// own, but the kitty query is passed through to the real terminal
+	mux multiplexer
+	// bg is the terminal's background color, when it answered OSC 11,
+	// and bgSpec the color as it gave it
+	bg     color.RGBA
+	bgSpec string
+	// kittyKeys is whether the terminal answered the kitty keyboard
+	// protocol query
+	kittyKeys bool
+	// otherKeys is whether the terminal knows xterm's modifyOtherKeys,
+	// because it answered the query or is tmux, which does it itself
+	otherKeys bool
+}
+
+// probeTimeout bounds how long we wait for the terminal to answer queries;
+// terminals that ignore them entirely would otherwise stall startup.
+const probeTimeout = 300 * time.Millisecond
+
+// probeTerminal combines environment hints with the answers to a kitty
+// graphics query, the background color and keyboard protocol queries,
+// DA2 and DA1. Every terminal answers DA1, so its reply
+// marks the end of the responses. Must run in raw mode before any input is
+// consumed from keys.
+func probeTerminal(keys <-chan byte, w io.Writer) termCaps {
+	caps := termCaps{da2: -1, mux: detectMux()}
+	caps.otherKeys = caps.mux == muxTmux
+	termEnv := os.Getenv("TERM")
+	switch os.Getenv("TERM_PROGRAM") {
+	case "iTerm.app":
+		caps.iterm = true
+	case "WezTerm", "ghostty":
+		caps.kittyGraphics = true
+	}
+	if os.Getenv("KITTY_WINDOW_ID") != "" || strings.Contains(termEnv, "kitty") || strings.Contains(termEnv, "ghostty") {
+		caps.kittyGraphics = true
+	}
+	if termEnv == "dumb" {
+		return caps
+	}
+
+	_, _ = io.WriteString(muxWriter{w, caps.mux}, "\x1b_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\x1b\\")
+	_, _ = io.WriteString(w, "\x1b]11;?\x1b\\\x1b[?u\x1b[?4m\x1b[>c\x1b[c")
+	var resp []byte
+	deadline := time.After(probeTimeout)
+	for {
+		select {
+		case b, ok := <-keys:
+			if !ok {
+				return caps
+			}
+			resp = append(resp, b)
+			if b != 'c' {
+				continue
+			}
+			// DA1 is the last reply: ESC [ ? params c
+			if i := bytes.LastIndex(resp, []byte("\x1b[?")); i >= 0 && isParams(resp[i+3:len(resp)-1]) {
+				parseCaps(&caps, resp)
+				return caps
+			}
+		case <-deadline:
+			parseCaps(&caps, resp)
+			return caps
+		}
+	}
+}
+
+// parseCaps reads the kitty graphics, OSC 11, keyboard, modifyOtherKeys,
+// DA2 and DA1 replies out of resp.
+func parseCaps(caps *termCaps, resp []byte) {
+	s := string(resp)
+	if i := strings.Index(s, "\x1b]11;"); i >= 0 {
+		// terminated by BEL or ST
+		if j := strings.IndexAny(s[i+5:], "\a\x1b"); j > 0 {
+			spec := s[i+5 : i+5+j]
+			if c, ok := parseOSCColor(spec); ok {
+				caps.bg, caps.bgSpec = c, spec
+			}
+		}
+	}
+	if strings.Contains(s, "\x1b_Gi=31;OK") {
+		caps.kittyGraphics = true
+	}
+	// CSI > 4 ; value m answers the modifyOtherKeys query, CSI > params c
+	// is DA2
+	for rest := s; ; {
+		i := strings.Index(rest, "\x1b[>")
+		if i < 0 {
+			break
+		}
+		rest = rest[i+3:]
+		j := strings.IndexAny(rest, "mc")
+		if j < 0 || !isParams([]byte(rest[:j])) {
+			continue
+		}
+		params := strings.Split(rest[:j], ";")
+		if rest[j] == 'm' {
+			caps.otherKeys = caps.otherKeys || params[0] == "4"
+			continue
+		}
+		if n, err := strconv.Atoi(params[0]); err == nil {
+			caps.da2 = n
+		}
+	}
+	// CSI ? flags u answers the keyboard query, CSI ? params c is DA1
+	for rest := s; ; {
+		i := strings.Index(rest, "\x1b[?")
+		if i < 0 {
+			break
+		}
+		rest = rest[i+3:]
+		j := strings.IndexAny(rest, "uc")
+		if j < 0 || !isParams([]byte(rest[:j])) {
+			continue
+		}
+		if rest[j] == 'u' {
+			caps.kittyKeys = true
+			continue
+		}
+		for _, p := range strings.Split(rest[:j], ";") {
+			if p == "4" {
+				caps.sixel = true
+			}
+		}
+	}
+}
+
+func isParams(b []byte) bool {
+	for _, c := range b {
+		if (c < '0' || c > '9') && c != ';' {
+			return false
+		}
+	}
+	return true
+}
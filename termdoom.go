@@ -8,55 +8,617 @@ import (
 	"image/color"
 	"io"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/AndreRenaud/gore"
-	"github.com/nfnt/resize"
 	"golang.org/x/term"
 )
 
-// Characters from dark to bright
-const ramp = " .:-=+*#%@"
+// Characters from dark to bright; replaced by --ramp and reversed by --invert
+// or a light background
+var ramp = []rune(" .:-=+*#%@")
 
 type termDoom struct {
 	keys            <-chan byte
+	input           inputParser
 	outstandingDown map[uint8]time.Time
+	keyupDelay      time.Duration // how long keys stay down after they were last sent
+	renderer        renderer
+	colors          colorMode
+	dither          ditherer
+	filters         []func(*image.RGBA)
+	remap           func(*image.RGBA) // from --palette
+	flash           *flashGuard       // nil unless --reduce-flashing
+	adjust          adjust
+	cellAspect      float64
+	aspectSetting   float64 // --cell-aspect, 0 to ask the terminal
+	scale           string
+	scaler          scaler
+	boxFilter       bool
+	conv            converter
+	out             bytes.Buffer
+	pace            *pacer
+	// mux wraps titles, and passthrough graphics, for a multiplexer
+	mux, passthrough multiplexer
+	raw              bytes.Buffer
+	stats            stats
+	hud              *hudReader // for --text-hud, --bell and choosing weapons with the wheel
+	textHUD          bool
+	bell             *bell           // nil without --bell
+	session          sessionRecorder // nil without --record
+	spectators       *spectators     // nil without --spectate-listen
+	gif              *gifRecorder    // nil without --export-gif
+	frames           *frameServer    // nil without --frame-listen
+	metrics          *metrics        // nil without --metrics-listen
+	summary          *sessionStats   // nil for termdoom bench
+	video            *videoExporter  // nil without --export-video
+	screens          *textScreens    // nil unless --text-screens
+	automap          *automapView    // for the minimap and --automap=lines
+	automapLines     bool
+	minimap          *minimap // nil without --minimap
+	settings         *settingsMenu
+	keymap           keymap
+	// kittyKeys means keys arrive in the kitty keyboard protocol, with
+	// releases; held has the keys pressed and not yet released
+	kittyKeys bool
+	held      map[uint8]bool
+	// pending are events to hand out before reading more keys, such as
+	// the modifiers that came with one
+	pending []gore.DoomEvent
+	pad     *gamepad // nil without --gamepad
+	mouse   *mouse   // nil without --mouse
+	// mouseX is the mouse position the engine is told about, which
+	// turning from the mouse and gamepad moves, last when it last moved
+	mouseX    float64
+	lastMouse time.Time
+	// weapon is the slot last chosen by number or wheel
+	weapon    int
+	alwaysRun bool
+	cheat     cheatTyping
+	// quickSaved is whether a quicksave has picked the engine's slot
+	quickSaved bool
+	// focusPause pauses the game while the terminal is in the background;
+	// focusPaused is whether we did
+	focusPause, focusPaused bool
+	// batch is whether the engine's current round of GetEvent calls has
+	// had an event yet
+	batch bool
+	// started is when the engine first asked for events, which input
+	// logs count from
+	started      time.Time
+	net          <-chan netCommand // nil without --input-listen
+	recorder     *inputRecorder    // nil without --record-input
+	replay       *inputReplay      // nil without --replay-input
+	caps         termCaps
+	quality      *quality // nil when --adaptive is off
+	qualityLevel int32
+	// frame is our copy of the engine's frame, which filters modify
+	frame *image.RGBA
+	// scene is the renderer's output, placed into grid with letterboxing
+	scene, grid grid
+	// shadow is the grid as last written, when diff updates are on
+	diff        bool
+	sync        bool
+	plain       bool
+	shadow      grid
+	shadowValid bool
+	// panelShown is set while a panel is drawn in a graphics renderer's place
+	panelShown bool
+	lastW      int
+	lastH      int
+	// resized signals a terminal resize; without it the size is polled
+	resized   chan os.Signal
+	watching  bool
+	w, h      int
+	sizeValid bool
+	// screenshot is set by F12 for the next frame drawn to be saved
+	screenshot    bool
+	screenshotDir string
+	dump          *frameDumper // nil without --dump-frames
 }
 
-// DrawFrame converts the RGBA frame to ANSI colored ASCII and writes to stdout.
+// DrawFrame converts the RGBA frame to ANSI colored text and writes to stdout.
 func (t *termDoom) DrawFrame(img *image.RGBA) {
-	w, h, err := term.GetSize(int(os.Stdout.Fd()))
-	if err != nil || w < 20 || h < 10 {
+	start := time.Now()
+	if t.gif != nil {
+		t.gif.frame(img, start)
+	}
+	if t.video != nil {
+		t.video.frame(img, start)
+	}
+	if t.frames != nil {
+		t.frames.put(img)
+	}
+	if t.dump != nil {
+		if err := t.dump.frame(img); err != nil {
+			t.dump = nil
+			t.SetTitle("frame dump stopped: " + err.Error())
+		}
+	}
+	if !t.pace.ready(start) {
+		t.stats.dropped++
+		if t.metrics != nil {
+			t.metrics.dropped.Add(1)
+		}
+		return
+	}
+	if t.summary != nil {
+		t.summary.frame(img, start)
+	}
+	if q := t.quality; q != nil {
+		if l := q.level.Load(); l != t.qualityLevel {
+			t.qualityLevel = l
+			t.SetTitle(q.String())
+		}
+	}
+	w, h := t.size()
+	if w < minCols || h+1 < minRows {
+		t.tooSmall(w, h)
+		return
+	}
+
+	b := &t.out
+	b.Reset()
+	if t.sync {
+		// begin synchronized update: the terminal shows the frame atomically
+		b.WriteString("\x1b[?2026h")
+	}
+	if (w != t.lastW || h != t.lastH) && !t.plain {
+		// the letterbox bars moved; don't leave the old picture behind
+		b.WriteString("\x1b[0m\x1b[2J")
+		t.lastW, t.lastH = w, h
+		t.shadowValid = false
+		t.conv.forget()
+	} else if t.spectators != nil && t.spectators.catchUp() && !t.plain {
+		// someone started watching: they need the whole picture
+		b.WriteString("\x1b[0m\x1b[2J")
+		t.shadowValid = false
+		t.conv.forget()
+	}
+
+	// with a text HUD, the bar is read from the engine's frame and the
+	// picture is cropped to the 3D view above it
+	read := t.hud != nil && t.hud.read(img)
+	if read && t.bell != nil && t.bell.ring(t.hud.state, time.Now()) {
+		b.WriteByte('\a')
+	}
+	hud := read && t.textHUD
+	// the automap is traced, but only in place of cells
+	amap := t.automap != nil && t.renderer.encode == nil && t.automap.read(img)
+	var panel []panelLine
+	if t.settings.on {
+		panel = t.settings.lines()
+	} else if t.screens != nil {
+		panel = t.screens.read(img)
+	}
+	raw := img
+	t.frame = copyFrame(t.frame, img)
+	img = t.frame
+	hudRows := 0
+	if hud {
+		img = img.SubImage(image.Rect(0, 0, img.Rect.Dx(), stBarY)).(*image.RGBA)
+		hudRows = 1
+	}
+	if t.flash != nil {
+		t.flash.apply(img)
+	}
+	t.adjust.apply(img)
+	if t.remap != nil {
+		t.remap(img)
+	}
+
+	// size the picture for the scale mode and center it
+	r := t.renderer
+	pxPerCol := r.cellW
+	if r.encode != nil {
+		pxPerCol = defaultCellPxW
+		if cw, _, ok := cellPixels(); ok {
+			pxPerCol = cw
+		}
+	}
+	// the picture's shape: 4:3 for a whole frame, wider when cropped
+	shape := doomAspect * float64(img.Rect.Dx()) / 320 * 200 / float64(img.Rect.Dy())
+	cols, rows, src := place(t.scale, w, h-hudRows, t.cellAspect, shape, pxPerCol, img.Rect.Dx(), img.Rect.Dy())
+	if src != img.Rect {
+		img = img.SubImage(src).(*image.RGBA)
+	}
+	x0, y0 := (w-cols)/2, (h-rows-hudRows)/2
+
+	if r.encode != nil && panel != nil {
+		// text can't go over the image, so it takes the image's place
+		if !t.panelShown {
+			if r.cleanup != nil {
+				t.raw.Reset()
+				r.cleanup(&t.raw)
+				t.passthrough.wrap(b, t.raw.Bytes())
+			}
+			b.WriteString("\x1b[0m\x1b[2J")
+			t.panelShown = true
+			t.shadowValid = false
+			t.conv.forget()
+		}
+		t.grid.resize(w, h)
+		t.grid.fill(cell{ch: ' '})
+		drawPanel(&t.grid, panel)
+		if t.diff && t.shadowValid {
+			t.conv.encode(b, &t.grid, &t.shadow, t.colors)
+		} else {
+			b.WriteString("\x1b[H")
+			t.conv.encode(b, &t.grid, nil, t.colors)
+		}
+		if t.diff {
+			copyGrid(&t.shadow, &t.grid)
+			t.shadowValid = true
+		}
+	} else if r.encode != nil {
+		if t.panelShown {
+			// and the picture must clear it away again
+			b.WriteString("\x1b[0m\x1b[2J")
+			t.panelShown = false
+			t.shadowValid = false
+			t.conv.forget()
+		}
+		for _, f := range t.filters {
+			f(img)
+		}
+		fmt.Fprintf(b, "\x1b[%d;%dH", y0+1, x0+1)
+		if t.passthrough != muxNone {
+			t.raw.Reset()
+			r.encode(&t.raw, img, cols, rows)
+			t.passthrough.wrap(b, t.raw.Bytes())
+		} else {
+			r.encode(b, img, cols, rows)
+		}
+		if hud {
+			// the text HUD goes below the image, on a grid of its own
+			t.scene.resize(cols, 1)
+			t.scene.fill(cell{ch: ' '})
+			t.hud.state.line(&t.scene, 0)
+			fmt.Fprintf(b, "\x1b[%d;%dH", y0+rows+1, x0+1)
+			writeGrid(b, &t.scene, t.colors, 0)
+		}
+		if t.stats.on {
+			t.stats.write(b)
+		}
+	} else {
+		rgba := t.scaler.scale(img, cols*r.cellW, rows*r.cellH, t.boxFilter)
+		for _, f := range t.filters {
+			f(rgba)
+		}
+		if t.quality != nil {
+			t.quality.apply(rgba, t.colors)
+		}
+		t.dither.apply(rgba, t.colors)
+		t.scene.resize(cols, rows)
+		t.conv.draw(r, &t.scene, rgba)
+		if amap {
+			bg := t.frame.RGBAAt(0, 0)
+			if t.minimap != nil {
+				t.minimap.capture(img, bg)
+			}
+			if t.automapLines {
+				t.automap.draw(&t.scene, img, bg)
+			}
+		}
+		t.grid.resize(w, h)
+		t.grid.fill(cell{ch: ' '})
+		t.grid.blit(&t.scene, x0, y0)
+		if hud {
+			t.hud.state.line(&t.grid, y0+rows)
+		}
+		if t.minimap != nil && !amap && panel == nil {
+			t.minimap.draw(&t.grid, x0, y0, cols, rows)
+		}
+		if panel != nil {
+			drawPanel(&t.grid, panel)
+		}
+		if t.stats.on {
+			t.stats.draw(&t.grid)
+		}
+		if t.diff && t.shadowValid {
+			t.conv.encode(b, &t.grid, &t.shadow, t.colors)
+		} else {
+			if !t.plain {
+				// move cursor home
+				b.WriteString("\x1b[H")
+			}
+			t.conv.encode(b, &t.grid, nil, t.colors)
+		}
+		if t.diff {
+			copyGrid(&t.shadow, &t.grid)
+			t.shadowValid = true
+		}
+	}
+	if t.sync {
+		b.WriteString("\x1b[?2026l")
+	}
+	if t.screenshot {
+		t.screenshot = false
+		var g *grid
+		if r.encode == nil {
+			g = &t.grid
+		}
+		if name, err := saveScreenshot(t.screenshotDir, raw, g, t.colors, start); err != nil {
+			t.SetTitle("screenshot failed: " + err.Error())
+		} else {
+			t.SetTitle("screenshot saved: " + name)
+		}
+	}
+	conv := time.Since(start)
+	t.stats.frame(time.Now(), conv, b.Len())
+	if t.metrics != nil {
+		t.metrics.frame(conv, t.stats.fps)
+	}
+	t.pace.frame(b.Bytes())
+}
+
+// termSize asks the terminal its size; termdoom serve answers for the
+// browser instead.
+var termSize = func() (w, h int, err error) {
+	return term.GetSize(int(os.Stdout.Fd()))
+}
+
+// size returns the usable terminal size, asking the terminal again only
+// after it was resized (or every frame where resizes can't be watched).
+func (t *termDoom) size() (w, h int) {
+	select {
+	case <-t.resized:
+		t.sizeValid = false
+	default:
+	}
+	if t.sizeValid {
+		return t.w, t.h
+	}
+	w, h, err := termSize()
+	if err != nil {
+		// not a terminal; assume the classic size
 		w, h = 80, 24
 	}
 	// leave one row for safety
 	h--
+	if w != t.w || h != t.h {
+		// a font change shows up as a resize too
+		t.cellAspect = cellAspect(t.aspectSetting)
+	}
+	if t.session != nil {
+		t.session.resize(w, h+1)
+	}
+	t.w, t.h = w, h
+	t.sizeValid = t.watching
+	return w, h
+}
 
-	// terminal cells are taller than wide; using nearest is fast and crisp
-	target := resize.Resize(uint(w), uint(h), img, resize.NearestNeighbor)
-
-	var b bytes.Buffer
-	// move cursor home
-	b.WriteString("\x1b[H")
+// minCols and minRows are the smallest usable terminal, below which
+// frames are replaced by a request to enlarge the window.
+const (
+	minCols = 80
+	minRows = 24
+)
 
-	rgba, _ := ensureRGBA(target)
-	toASCII(&b, rgba)
-	_, _ = os.Stdout.Write(b.Bytes())
+// tooSmall shows the resize banner in place of frames, redrawing it only
+// when the size changes.
+func (t *termDoom) tooSmall(w, h int) {
+	if w == t.lastW && h == t.lastH {
+		return
+	}
+	t.lastW, t.lastH = w, h
+	t.shadowValid = false
+	lines := []string{"terminal too small", fmt.Sprintf("%d×%d, need %d×%d", w, h+1, minCols, minRows)}
+	if t.plain {
+		t.pace.write([]byte(strings.Join(lines, ": ") + "\r\n"))
+		return
+	}
+	b := []byte("\x1b[0m\x1b[2J")
+	for i, l := range lines {
+		x := max((w-utf8.RuneCountInString(l))/2, 0)
+		b = fmt.Appendf(b, "\x1b[%d;%dH%s", h/2+i, x+1, l)
+	}
+	t.pace.write(b)
 }
 
 // SetTitle sets the terminal window title.
 func (t *termDoom) SetTitle(title string) {
+	if t.plain {
+		return
+	}
 	// OSC title
-	fmt.Fprintf(os.Stdout, "\x1b]0;%s\x07", title)
+	var b bytes.Buffer
+	t.mux.wrap(&b, []byte("\x1b]0;"+title+"\x07"))
+	t.pace.write(b.Bytes())
 }
 
-// GetEvent provides keydown/keyup events from stdin without unix/syscalls.
+// GetEvent provides keydown/keyup events from stdin without unix/syscalls,
+// and from the gamepad. The engine asks until it gets no event, every tic.
 func (t *termDoom) GetEvent(ev *gore.DoomEvent) bool {
-	// emit pending key-up after a short delay
-	const upDelay = 60 * time.Millisecond
 	now := time.Now()
+	if t.started.IsZero() {
+		t.started = now
+	}
+	t.batch = t.event(ev, now)
+	if t.batch && t.recorder != nil {
+		t.recorder.record(ev, now.Sub(t.started))
+	}
+	if t.batch && t.metrics != nil {
+		t.metrics.events.Add(1)
+	}
+	return t.batch
+}
+
+func (t *termDoom) event(ev *gore.DoomEvent, now time.Time) bool {
+	first := !t.batch
+	if t.replay != nil && t.replay.due(ev, now.Sub(t.started), first) {
+		return true
+	}
+	// the engine builds mouse events on whatever the previous event in
+	// the round left behind, so turning goes first
+	if first && t.turnEvent(ev, now) {
+		return true
+	}
+	for {
+		ok := t.nextEvent(ev, now)
+		// always run holds the run key down whatever else lets go of it
+		if !ok || !t.alwaysRun || ev.Type != gore.Ev_keyup || ev.Key != keyRun {
+			return ok
+		}
+	}
+}
+
+// setAlwaysRun holds the run key down, or lets go of it.
+func (t *termDoom) setAlwaysRun(on bool) {
+	if on == t.alwaysRun {
+		return
+	}
+	t.alwaysRun = on
+	e := gore.DoomEvent{Type: gore.Ev_keyup, Key: keyRun}
+	if on {
+		e.Type = gore.Ev_keydown
+	}
+	t.pending = append(t.pending, e)
+	t.settings.set("always-run", strconv.FormatBool(on))
+	if on {
+		t.SetTitle("always run on")
+	} else {
+		t.SetTitle("always run off")
+	}
+}
+
+// turnEvent makes a mouse movement out of the turning the mouse and
+// gamepad have done, at most once a tic since the engine only keeps the
+// last one it is given.
+func (t *termDoom) turnEvent(ev *gore.DoomEvent, now time.Time) bool {
+	if now.Sub(t.lastMouse) < time.Second/35 {
+		return false
+	}
+	dx := 0.0
+	if t.mouse != nil {
+		dx += t.mouse.turning()
+	}
+	if t.pad != nil {
+		dx += t.pad.turning(now)
+	}
+	if dx == 0 {
+		return false
+	}
+	t.mouseX += dx
+	t.lastMouse = now
+	*ev = gore.DoomEvent{Type: gore.Ev_mouse}
+	ev.Mouse.XPos = t.mouseX
+	return true
+}
+
+// switchWeapon presses the number key of the next weapon in dir, which
+// the engine has no key of its own for. Nothing tells us when picking up
+// a weapon switches to it, so the count goes on from the last weapon
+// chosen by number or wheel.
+func (t *termDoom) switchWeapon(dir int, now time.Time) {
+	var arms *hudState
+	if t.hud != nil {
+		arms = &t.hud.state
+	}
+	t.weapon = nextWeapon(t.weapon, dir, arms)
+	k := uint8('0' + t.weapon)
+	// held for the key-up delay, since the engine looks for weapon keys
+	// down when it builds each tic
+	t.pending = append(t.pending, gore.DoomEvent{Type: gore.Ev_keydown, Key: k})
+	t.outstandingDown[k] = now
+}
+
+// frontendKey acts on k going down if it is one of the keys we handle
+// rather than pass on, reporting whether it was.
+func (t *termDoom) frontendKey(k uint8, down bool) bool {
+	switch {
+	case !slices.Contains(frontendKeys, k):
+		return false
+	case !down:
+	case k == keyAutorun:
+		t.setAlwaysRun(!t.alwaysRun)
+	case k == keySettings:
+		t.settings.on, t.settings.status = true, ""
+	case k == keyScreenshot && t.screenshotDir == "":
+		t.SetTitle("screenshots are off")
+	case k == keyScreenshot:
+		t.screenshot = true
+	case k == keyOverlay:
+		t.stats.on = !t.stats.on
+	case k == keyMinimap:
+		if t.minimap != nil {
+			t.minimap.on = !t.minimap.on
+		}
+	default:
+		t.quick(k)
+	}
+	return true
+}
+
+// quick types what the engine's menus want for a quicksave or quickload,
+// so either takes the one key. The first quicksave asks for a slot: it
+// gets the one the save menu opens on, named "quicksave". After that
+// quicksaves and quickloads ask to be confirmed, and get a yes.
+func (t *termDoom) quick(k uint8) {
+	keys := []uint8{k}
+	switch {
+	case k == keyQuicksave && !t.quickSaved:
+		keys = append(keys, gore.KEY_ENTER)
+		for range gore.SAVESTRINGSIZE {
+			keys = append(keys, gore.KEY_BACKSPACE3)
+		}
+		keys = append(keys, "quicksave"...)
+		keys = append(keys, gore.KEY_ENTER)
+		t.quickSaved = true
+	case t.quickSaved:
+		keys = append(keys, 'y')
+	}
+	// all at once, since the menus take each key as it comes rather than
+	// once a tic, and without releases, which they have no use for and
+	// which would overflow the engine's queue of 64 events
+	for _, key := range keys {
+		t.pending = append(t.pending, gore.DoomEvent{Type: gore.Ev_keydown, Key: key})
+	}
+}
+
+// press queues the engine key bound to seq going down or up, for keys
+// whose releases we are told about.
+func (t *termDoom) press(seq string, down bool) {
+	if k, ok := t.keymap.lookup([]byte(seq)); ok {
+		if t.frontendKey(k, down) {
+			return
+		}
+		e := gore.DoomEvent{Type: gore.Ev_keyup, Key: k}
+		if down {
+			e.Type = gore.Ev_keydown
+		}
+		t.pending = append(t.pending, e)
+	}
+}
+
+func (t *termDoom) nextEvent(ev *gore.DoomEvent, now time.Time) bool {
+	if t.pad != nil {
+		t.pad.poll(func(c int, down bool) { t.press(padKey(c), down) })
+	}
+	if t.net != nil && len(t.pending) == 0 {
+		select {
+		case cmd := <-t.net:
+			if !t.frontendKey(cmd.key, cmd.down) {
+				t.pending = append(t.pending, cmd.event())
+				if cmd.press {
+					t.outstandingDown[cmd.key] = now
+				}
+			}
+		default:
+		}
+	}
+	if len(t.pending) > 0 {
+		*ev = t.pending[0]
+		t.pending = t.pending[1:]
+		return true
+	}
+	// emit pending key-up once the key has gone quiet for keyupDelay
 	for k, ts := range t.outstandingDown {
-		if now.Sub(ts) >= upDelay {
+		if now.Sub(ts) >= t.keyupDelay {
 			delete(t.outstandingDown, k)
 			ev.Type = gore.Ev_keyup
 			ev.Key = k
@@ -64,50 +626,124 @@ func (t *termDoom) GetEvent(ev *gore.DoomEvent) bool {
 		}
 	}
 
-	// try to read a byte non-blocking
-	select {
-	case b, ok := <-t.keys:
-		if !ok {
-			return false
+	// the next key, escape sequences whole, without blocking
+	seq := t.input.next(t.keys, now)
+	if seq == nil {
+		return false
+	}
+	if s := string(seq); s == focusIn || s == focusOut {
+		// the pause key toggles, so only unpause what we paused
+		if t.focusPause && (s == focusOut) != t.focusPaused {
+			t.focusPaused = !t.focusPaused
+			t.pending = append(t.pending, gore.DoomEvent{Type: gore.Ev_keydown, Key: gore.KEY_PAUSE1})
+			t.outstandingDown[gore.KEY_PAUSE1] = now
 		}
-		seq := []byte{b}
-		if b == 0x1b { // ESC sequence for arrows
-			select {
-			case b2 := <-t.keys:
-				seq = append(seq, b2)
-				select {
-				case b3 := <-t.keys:
-					seq = append(seq, b3)
-				default:
-				}
-			default:
+		return len(t.pending) > 0 && t.nextEvent(ev, now)
+	}
+	if m, ok := parseMouse(seq); ok {
+		if t.mouse != nil {
+			wheel := t.mouse.report(m, func(b int, down bool) { t.press(mouseKey(b), down) })
+			if wheel != 0 {
+				t.switchWeapon(wheel, now)
 			}
 		}
-		if k, ok := mapKey(seq); ok {
-			ev.Type = gore.Ev_keydown
+		return len(t.pending) > 0 && t.nextEvent(ev, now)
+	}
+	mods := 0
+	if t.kittyKeys {
+		// real releases: no key-up timer, and repeats change nothing;
+		// modifiers are keys of their own
+		key, event, _, ok := kittyKey(seq)
+		if !ok || event == keyRepeat {
+			return false
+		}
+		if event == keyRelease {
+			k, ok := t.keymap.lookup(key)
+			if !ok || !t.held[k] {
+				return false
+			}
+			delete(t.held, k)
+			ev.Type = gore.Ev_keyup
 			ev.Key = k
-			t.outstandingDown[k] = now
 			return true
 		}
+		seq = key
+	} else if key, ok := altKey(seq); ok {
+		seq, mods = key, modAlt
+	} else if key, m, ok := otherKey(seq); ok {
+		// modifyOtherKeys, or a cursor or function key with modifiers
+		seq, mods = key, m
+	}
+	if t.settings.on {
+		if k, ok := t.keymap.lookup(seq); ok && k == keySettings {
+			t.settings.on = false
+		} else {
+			t.settings.key(seq, t.applySetting)
+		}
 		return false
-	default:
+	}
+	// with Alt, since the keys themselves are typed into savegame names
+	if mods&modAlt != 0 && t.adjust.key(seq) {
+		t.SetTitle(t.adjust.String())
 		return false
 	}
+	t.pressModifiers(mods, now)
+	// printable characters also reach the engine as themselves, whatever
+	// they are bound to, for cheat codes and savegame names; in the middle
+	// of a cheat code only as themselves, since any other key starts the
+	// engine's reading of it over
+	if c, ok := typedChar(seq); ok {
+		cheat := t.cheat.add(c)
+		if k, _ := t.keymap.lookup(seq); k != c {
+			if _, down := t.outstandingDown[c]; !down {
+				t.pending = append(t.pending, gore.DoomEvent{Type: gore.Ev_keydown, Key: c})
+			}
+			t.outstandingDown[c] = now
+			if cheat {
+				return t.nextEvent(ev, now)
+			}
+		}
+	}
+	if k, ok := t.keymap.lookup(seq); ok {
+		if t.frontendKey(k, true) {
+			return len(t.pending) > 0 && t.nextEvent(ev, now)
+		}
+		if t.kittyKeys {
+			t.held[k] = true
+		} else {
+			// auto-repeat of a key that is still down only keeps it
+			// down, rather than letting go and pressing it again
+			_, down := t.outstandingDown[k]
+			t.outstandingDown[k] = now
+			if down {
+				return len(t.pending) > 0 && t.nextEvent(ev, now)
+			}
+		}
+		if k >= '1' && k <= '7' {
+			t.weapon = int(k - '0')
+		}
+		ev.Type = gore.Ev_keydown
+		ev.Key = k
+		return true
+	}
+	return len(t.pending) > 0 && t.nextEvent(ev, now)
 }
 
-// ensureRGBA guarantees we have *image.RGBA for fast pixel walks.
-func ensureRGBA(img image.Image) (*image.RGBA, bool) {
-	if r, ok := img.(*image.RGBA); ok {
-		return r, true
-	}
-	b := img.Bounds()
-	r := image.NewRGBA(b)
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			r.Set(x, y, img.At(x, y))
+// pressModifiers queues presses of whatever the modifiers in mods are
+// bound to, released by the timer like any other key without the kitty
+// protocol.
+func (t *termDoom) pressModifiers(mods int, now time.Time) {
+	for _, m := range modifierKeys[:3] {
+		if mods&m.mod == 0 {
+			continue
+		}
+		if k, ok := t.keymap.lookup([]byte(string(m.code))); ok {
+			if _, down := t.outstandingDown[k]; !down {
+				t.pending = append(t.pending, gore.DoomEvent{Type: gore.Ev_keydown, Key: k})
+			}
+			t.outstandingDown[k] = now
 		}
 	}
-	return r, false
 }
 
 func clamp8(v int) uint8 {
@@ -120,18 +756,28 @@ func clamp8(v int) uint8 {
 	return uint8(v)
 }
 
-// toASCII writes a full-frame ANSI image using ramp + 24-bit color.
-func toASCII(w io.Writer, img *image.RGBA) {
+// luma returns the same weighted brightness toASCII uses, in 0..255.
+func luma(r, g, b uint8) int {
+	return (int(r)*3 + int(g)*6 + int(b)*1) / 10
+}
+
+// toASCII fills the grid with one ramp character per pixel, colored by it.
+// Double-width ramps use every other pixel and cover two cells per glyph.
+func toASCII(g *grid, img *image.RGBA) {
 	b := img.Bounds()
-	last := color.RGBA{}
+	wide := runeWidth(ramp[0]) == 2
 	for y := b.Min.Y; y < b.Max.Y; y++ {
 		for x := b.Min.X; x < b.Max.X; x++ {
+			if wide && (x-b.Min.X)%2 == 1 {
+				g.set(x-b.Min.X, y-b.Min.Y, cell{})
+				continue
+			}
 			o := (y-b.Min.Y)*img.Stride + (x-b.Min.X)*4
 			r := img.Pix[o+0]
-			g := img.Pix[o+1]
+			gr := img.Pix[o+1]
 			bl := img.Pix[o+2]
 			// luma-ish
-			l := int(r)*3 + int(g)*6 + int(bl)*1
+			l := int(r)*3 + int(gr)*6 + int(bl)*1
 			idx := (l * (len(ramp) - 1)) / (255 * 10)
 			if idx < 0 {
 				idx = 0
@@ -139,60 +785,67 @@ func toASCII(w io.Writer, img *image.RGBA) {
 			if idx >= len(ramp) {
 				idx = len(ramp) - 1
 			}
-			ch := ramp[idx]
+			g.set(x-b.Min.X, y-b.Min.Y, cell{ch: ramp[idx], fg: color.RGBA{r, gr, bl, 255}})
+		}
+	}
+}
 
-			// emit color only if it changed
-			if r != last.R || g != last.G || bl != last.B {
-				fmt.Fprintf(w, "\x1b[38;2;%d;%d;%dm", r, g, bl)
-				last = color.RGBA{r, g, bl, 255}
+// toASCIIDual is toASCII with a background too: each cell covers 2×2
+// pixels, the glyph is picked by their mean brightness as usual, painted in
+// the brightest pixel's color over the average color of all four. Flat
+// areas come out solid and detail shows as bright glyphs, with the same
+// number of cells as the plain renderer. img must be 2g.w×2g.h.
+func toASCIIDual(g *grid, img *image.RGBA) {
+	wide := runeWidth(ramp[0]) == 2
+	for y := 0; y < g.h; y++ {
+		for x := 0; x < g.w; x++ {
+			if wide && x%2 == 1 {
+				g.set(x, y, cell{})
+				continue
+			}
+			var sum [3]int
+			var bright color.RGBA
+			bl := -1
+			for i := 0; i < 4; i++ {
+				o := (y*2+i/2)*img.Stride + (x*2+i%2)*4
+				r, gr, b := img.Pix[o], img.Pix[o+1], img.Pix[o+2]
+				sum[0] += int(r)
+				sum[1] += int(gr)
+				sum[2] += int(b)
+				if l := luma(r, gr, b); l > bl {
+					bl, bright = l, color.RGBA{r, gr, b, 255}
+				}
 			}
-			_, _ = w.Write([]byte{byte(ch)})
+			avg := color.RGBA{uint8(sum[0] / 4), uint8(sum[1] / 4), uint8(sum[2] / 4), 255}
+			idx := min(luma(avg.R, avg.G, avg.B)*(len(ramp)-1)/255, len(ramp)-1)
+			g.set(x, y, cell{ch: ramp[idx], fg: bright, bg: avg})
 		}
-		// reset at EOL
-		_, _ = w.Write([]byte("\x1b[0m\r\n"))
-		last = color.RGBA{}
 	}
 }
 
-func mapKey(seq []byte) (uint8, bool) {
-	s := string(seq)
-	switch s {
-	case "\x1b[A":
-		return gore.KEY_UPARROW1, true
-	case "\x1b[B":
-		return gore.KEY_DOWNARROW1, true
-	case "\x1b[C":
-		return gore.KEY_RIGHTARROW1, true
-	case "\x1b[D":
-		return gore.KEY_LEFTARROW1, true
-	case " ", "\x1bOP":
-		return gore.KEY_USE1, true
-	case "\r", "\n":
-		return gore.KEY_ENTER, true
-	case "\x1b":
-		return gore.KEY_ESCAPE, true
-	case "\t":
-		return gore.KEY_TAB, true
-	case ",":
-		return gore.KEY_FIRE1, true
+// checkRamp makes sure a ramp has enough glyphs and that they all occupy
+// the same number of cells, since toASCII lays them out on a fixed grid.
+func checkRamp(r []rune) error {
+	if len(r) < 2 {
+		return fmt.Errorf("need at least two characters")
 	}
-	// direct digits and y/n
-	if len(seq) == 1 {
-		if seq[0] >= '0' && seq[0] <= '9' {
-			return seq[0], true
-		}
-		if seq[0] == 'y' || seq[0] == 'n' || seq[0] == 'Y' || seq[0] == 'N' {
-			return toLower(seq[0]), true
+	w := runeWidth(r[0])
+	for _, c := range r {
+		switch runeWidth(c) {
+		case 0:
+			return fmt.Errorf("%U is not a printable glyph", c)
+		case w:
+		default:
+			return fmt.Errorf("cannot mix single and double width characters")
 		}
 	}
-	return 0, false
+	return nil
 }
 
-func toLower(b byte) uint8 {
-	if b >= 'A' && b <= 'Z' {
-		return b - 'A' + 'a'
-	}
-	return b
+func reverse(r []rune) []rune {
+	r = slices.Clone(r)
+	slices.Reverse(r)
+	return r
 }
 
 // keyReader returns a non-blocking byte channel backed by a goroutine.
@@ -213,21 +866,379 @@ func keyReader(r io.Reader) <-chan byte {
 }
 
 func main() {
-	// raw mode and initial clear
-	fd := int(os.Stdin.Fd())
-	oldState, err := term.MakeRaw(fd)
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replayCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		benchCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "server" {
+		// no game of its own, but one for everyone connecting
+		opts, args := parseFlags(os.Args[2:])
+		runServers(opts, args, true)
+	}
+	// termdoom serve is the game as usual but for where it is played
+	serving := len(os.Args) > 1 && os.Args[1] == "serve"
+	flagArgs := os.Args[1:]
+	if serving {
+		flagArgs = os.Args[2:]
+	}
+	opts, args := parseFlags(flagArgs)
+	if opts.sshListen != "" || opts.telnetListen != "" {
+		runServers(opts, args, false)
+	}
+	if opts.launcher && term.IsTerminal(int(os.Stdin.Fd())) {
+		opts, args = parseFlags(launch(opts, flagArgs))
+	}
+	if !opts.remote {
+		args = chooseIWAD(args)
+	}
+	if opts.benchRenderers {
+		benchRenderers(opts, args)
+		return
+	}
+	demoFlags, demo, err := demoArgs(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "termdoom: demo:", err)
+		os.Exit(1)
+	}
+	args = append(args, demoFlags...)
+
+	// an engine error is shown once the terminal is put back, and is
+	// seen to last so it is what ends up at the bottom
+	var engineError string
+	defer func() {
+		if engineError == "" {
+			return
+		}
+		if ok, recorded := demoFinished(engineError); ok {
+			if recorded != "" {
+				if err := repairDemo(recorded); err != nil {
+					fmt.Fprintln(os.Stderr, "termdoom: demo:", err)
+					os.Exit(1)
+				}
+			}
+			fmt.Println(engineError)
+			return
+		}
+		fmt.Fprintln(os.Stderr, "termdoom:", engineError)
+		os.Exit(1)
+	}()
+	// profiles cover the whole game, and are written before any exit
+	// for an engine error
+	defer startProfiling(opts)()
+	// the summary too is shown once the terminal is put back, and goes to
+	// our standard output even when the game's is elsewhere
+	var summary *sessionStats
+	stdout := os.Stdout
+	defer func() {
+		if summary != nil && summary.frames > 0 {
+			summary.finish(stdout, opts.statsJSON)
+		}
+	}()
+
+	// ffmpeg is seen to first, so it is waited for and any complaint it
+	// has is shown after the terminal is put back
+	var video *videoExporter
+	if opts.exportVideo != "" {
+		v, err := newVideoExporter(opts.exportVideo, 320, 200, opts.videoFPS)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "termdoom: video export unavailable: %v\n", err)
+		} else {
+			video = v
+			defer func() {
+				if err := v.close(); err != nil {
+					fmt.Fprintf(os.Stderr, "termdoom: video export: %v\n", err)
+				}
+			}()
+		}
+	}
+
+	var web *webTerminal
+	if serving {
+		if web, err = serveWeb(opts.listen); err != nil {
+			fmt.Fprintln(os.Stderr, "termdoom: serve:", err)
+			os.Exit(1)
+		}
+		defer web.close()
+	}
+
+	// the Windows console only takes escape sequences once asked to
+	restoreVT, err := enableVT(os.Stdout)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "terminal raw mode:", err)
+		fmt.Fprintln(os.Stderr, "termdoom: this console can't show escape sequences; it needs Windows 10 or later, or Windows Terminal:", err)
 		return
 	}
-	defer term.Restore(fd, oldState)
-	// clear screen, move home, hide cursor
-	fmt.Print("\x1b[2J\x1b[H\x1b[?25l")
-	defer fmt.Print("\x1b[0m\x1b[2J\x1b[H\x1b[?25h")
+	defer restoreVT()
+
+	// raw mode and initial clear; a browser's terminal has no modes
+	if web == nil {
+		fd := int(os.Stdin.Fd())
+		oldState, err := term.MakeRaw(fd)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "terminal raw mode:", err)
+			return
+		}
+		defer term.Restore(fd, oldState)
+	}
+	keys, console := consoleInput(os.Stdin, opts.mouse)
+	if !console {
+		keys = keyReader(os.Stdin)
+	}
+	caps := termCaps{da2: -1}
+	if !opts.plain {
+		caps = probeTerminal(keys, os.Stdout)
+	}
+	lightBackground = resolveBackground(opts.background, caps) == "light"
+	ramp = []rune(opts.ramp)
+	if opts.invert || lightBackground {
+		ramp = reverse(ramp)
+	}
+	if opts.renderer == "auto" {
+		opts.renderer = autoRenderer(caps)
+	}
 
+	if opts.notice != "" {
+		fmt.Fprintf(os.Stderr, "termdoom: %s\r\n", opts.notice)
+	}
+	if !opts.plain {
+		// alternate screen so the shell's scrollback survives, save the
+		// window title, clear screen, move home, hide cursor; all undone
+		// in reverse on the way out
+		fmt.Print("\x1b[?1049h\x1b[22;0t\x1b[2J\x1b[H\x1b[?25l")
+		defer fmt.Print("\x1b[0m\x1b[2J\x1b[H\x1b[?25h\x1b[23;0t\x1b[?1049l")
+		if opts.background == "black" {
+			fmt.Print("\x1b]11;rgb:0000/0000/0000\x1b\\")
+			defer fmt.Print(restoreBackground(caps))
+		}
+	}
+	var out io.Writer = os.Stdout
+	var session sessionRecorder
+	if opts.record != "" {
+		w, h, err := termSize()
+		if err != nil {
+			w, h = 80, 24
+		}
+		if session, err = recordFormats[opts.recordFormat](opts.record, w, h); err != nil {
+			fmt.Fprintf(os.Stderr, "termdoom: session recording unavailable: %v\r\n", err)
+		} else {
+			out = io.MultiWriter(os.Stdout, session)
+			defer session.close()
+		}
+	}
+	var watchers *spectators
+	if opts.spectateListen != "" {
+		if watchers, err = listenSpectators(opts.spectateListen); err != nil {
+			fmt.Fprintf(os.Stderr, "termdoom: spectating unavailable: %v\r\n", err)
+		} else {
+			out = io.MultiWriter(out, watchers)
+			defer watchers.close()
+		}
+	}
+	var frames *frameServer
+	if opts.frameListen != "" {
+		if frames, err = listenFrames(opts.frameListen); err != nil {
+			fmt.Fprintf(os.Stderr, "termdoom: frame server unavailable: %v\r\n", err)
+		}
+	}
+	var counters *metrics
+	if opts.metricsListen != "" {
+		if counters, err = listenMetrics(opts.metricsListen); err != nil {
+			fmt.Fprintf(os.Stderr, "termdoom: metrics unavailable: %v\r\n", err)
+		} else {
+			out = io.MultiWriter(out, &counters.written)
+		}
+	}
+	// the IWAD and PWADs the game is drawn from, read once for all that
+	// reads the frame for text
+	gameWAD, wadErr := openGameWADs(args)
+	summary = newSessionStats(gameWAD)
+	out = io.MultiWriter(out, &summary.written)
+	var dump *frameDumper
+	if opts.dumpFrames != "" {
+		if dump, err = newFrameDumper(opts.dumpFrames, opts.dumpEvery); err != nil {
+			fmt.Fprintf(os.Stderr, "termdoom: frame dump unavailable: %v\r\n", err)
+		}
+	}
+	var gifs *gifRecorder
+	if opts.exportGIF != "" {
+		if gifs, err = newGIFRecorder(opts.exportGIF, opts.gifFPS, opts.gifDuration); err != nil {
+			fmt.Fprintf(os.Stderr, "termdoom: GIF export unavailable: %v\r\n", err)
+		} else {
+			defer gifs.close()
+		}
+	}
 	td := &termDoom{
-		keys:            keyReader(os.Stdin),
+		keys:            keys,
 		outstandingDown: make(map[uint8]time.Time),
+		keyupDelay:      opts.keyupDelay,
+		weapon:          2,
+		renderer:        renderers[opts.renderer],
+		colors:          colorModes[opts.colors],
+		dither:          ditherer{kind: opts.dither},
+		adjust:          adjust{gamma: opts.gamma, brightness: opts.brightness, contrast: opts.contrast},
+		cellAspect:      cellAspect(opts.cellAspect),
+		aspectSetting:   opts.cellAspect,
+		resized:         make(chan os.Signal, 1),
+		scale:           opts.scale,
+		remap:           opts.remap,
+		diff:            opts.diff && !opts.plain,
+		boxFilter:       opts.scaler == "box",
+		conv:            converter{workers: opts.workers, tol: opts.colorTolerance, rows: !opts.plain},
+		sync:            opts.sync && !opts.plain,
+		plain:           opts.plain,
+		pace:            newPacer(out, opts.fps, opts.vsync),
+		session:         session,
+		spectators:      watchers,
+		frames:          frames,
+		metrics:         counters,
+		summary:         summary,
+		gif:             gifs,
+		video:           video,
+		screenshotDir:   opts.screenshotDir,
+		dump:            dump,
+		mux:             caps.mux,
+		caps:            caps,
+		keymap:          opts.keymap,
+		held:            make(map[uint8]bool),
+		settings:        newSettingsMenu(opts, caps),
+		replay:          opts.replay,
+	}
+	// whichever renderer is in use by the end cleans up after itself
+	defer func() {
+		if td.renderer.cleanup != nil {
+			td.renderer.cleanup(muxWriter{os.Stdout, caps.mux})
+		}
+	}()
+	if opts.adaptive {
+		td.quality = newQuality(opts.fps)
+		td.pace.observe = td.quality.observe
+	}
+	defer td.pace.wait()
+	if td.renderer.encode != nil && !(opts.renderer == "sixel" && caps.sixel) {
+		// graphics only get through a multiplexer wrapped, except sixel
+		// where it said it speaks that itself
+		td.passthrough = caps.mux
+	}
+	// a browser's size is only asked after
+	td.watching = web == nil && notifyResize(td.resized)
+	if console {
+		// the Windows console tells us about releases itself, and its
+		// keys come as the kitty protocol's
+		td.kittyKeys = true
+	} else if caps.kittyKeys && opts.kittyKeys {
+		// push our keyboard mode, popped again on the way out
+		fmt.Printf("\x1b[>%du", kittyKeyFlags)
+		defer fmt.Print("\x1b[<u")
+		td.kittyKeys = true
+	} else if caps.otherKeys && opts.otherKeys {
+		// otherwise xterm's modifyOtherKeys, for keys with modifiers
+		fmt.Print("\x1b[>4;2m")
+		defer fmt.Print("\x1b[>4;0m")
+	}
+	if !opts.plain {
+		fmt.Print(pasteOn)
+		defer fmt.Print(pasteOff)
+	}
+	if opts.inputListen != "" {
+		if td.net, err = listenInput(opts.inputListen); err != nil {
+			fmt.Fprintf(os.Stderr, "termdoom: network input unavailable: %v\r\n", err)
+		}
+	}
+	if opts.recordInput != "" {
+		r, err := newInputRecorder(opts.recordInput)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "termdoom: input recording unavailable: %v\r\n", err)
+		} else {
+			td.recorder = r
+			defer r.close()
+		}
+	}
+	td.setAlwaysRun(opts.alwaysRun)
+	if opts.focusPause && !opts.plain {
+		fmt.Print(focusOn)
+		defer fmt.Print(focusOff)
+		td.focusPause = true
+	}
+	if opts.mouse && !opts.plain {
+		fmt.Print(mouseOn)
+		defer fmt.Print(mouseOff)
+		td.mouse = &mouse{sensitivity: opts.mouseSensitivity}
+	}
+	if opts.gamepad != "" {
+		if input, err := openGamepad(opts.gamepad); err != nil {
+			fmt.Fprintf(os.Stderr, "termdoom: gamepad unavailable: %v\r\n", err)
+		} else {
+			td.pad = newGamepad(input, opts.gamepadDeadzone)
+		}
+	}
+	if opts.textHUD || opts.bell || td.mouse != nil {
+		err := wadErr
+		if err == nil {
+			td.hud, err = newHUDReader(gameWAD)
+		}
+		if err != nil && opts.textHUD {
+			fmt.Fprintf(os.Stderr, "termdoom: text HUD unavailable: %v\r\n", err)
+		}
+		if err != nil && opts.bell {
+			fmt.Fprintf(os.Stderr, "termdoom: bell unavailable: %v\r\n", err)
+		}
+		td.textHUD = td.hud != nil && opts.textHUD
+		if td.hud != nil && opts.bell {
+			td.bell = &bell{}
+		}
+	}
+	if opts.textScreens {
+		err := wadErr
+		if err == nil {
+			td.screens, err = newTextScreens(gameWAD)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "termdoom: text screens unavailable: %v\r\n", err)
+		}
+	}
+	if opts.automap == "lines" || opts.minimap != "" {
+		err := wadErr
+		if err == nil {
+			td.automap, err = newAutomapView(gameWAD)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "termdoom: automap tracing unavailable: %v\r\n", err)
+		} else {
+			td.automapLines = opts.automap == "lines"
+			if opts.minimap != "" {
+				mw, mh, _ := parseMinimapSize(opts.minimap)
+				td.minimap = newMinimap(td.automap, mw, mh, opts.minimapCorner)
+			}
+		}
+	}
+	if opts.reduceFlashing {
+		td.flash = &flashGuard{}
+	}
+	td.filters, _ = parseFilters(opts.filter)
+	td.adjust.update()
+	if opts.colors == "auto" {
+		td.colors = detectColorMode()
+	}
+	// the engine runs on its own so that an error it would otherwise hang
+	// on can end the game here instead
+	engineArgs, files, err := engineFiles(args)
+	if err != nil {
+		engineError = err.Error()
+		return
+	}
+	fatal, restoreStderr := watchEngineErrors()
+	defer restoreStderr()
+	gore.SetVirtualFileSystem(hostFS{files: files, demo: demo})
+	done := make(chan struct{})
+	go func() {
+		gore.Run(td, engineArgs)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case engineError = <-fatal:
 	}
-	gore.Run(td, os.Args[1:])
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// terminfo holds the few capabilities we care about from a compiled
+// terminfo entry.
+type terminfo struct {
+	colors int             // max_colors, 0 if absent
+	ext    map[string]bool // extended boolean caps such as RGB and Tc
+}
+
+// terminfoDirs lists where entries are looked up, in ncurses' order.
+func terminfoDirs() []string {
+	var dirs []string
+	if d := os.Getenv("TERMINFO"); d != "" {
+		dirs = append(dirs, d)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".terminfo"))
+	}
+	if ds := os.Getenv("TERMINFO_DIRS"); ds != "" {
+		dirs = append(dirs, filepath.SplitList(ds)...)
+	}
+	return append(dirs, "/etc/terminfo", "/lib/terminfo", "/usr/share/terminfo", "/usr/lib/terminfo")
+}
+
+// loadTerminfo finds and parses the entry for name.
+func loadTerminfo(name string) (*terminfo, error) {
+	if name == "" {
+		return nil, fmt.Errorf("TERM not set")
+	}
+	for _, d := range terminfoDirs() {
+		// entries sit under their first letter, or its hex code on macOS
+		for _, sub := range []string{name[:1], fmt.Sprintf("%x", name[0])} {
+			if data, err := os.ReadFile(filepath.Join(d, sub, name)); err == nil {
+				return parseTerminfo(data)
+			}
+		}
+	}
+	return nil, fmt.Errorf("no terminfo entry for %q", name)
+}
+
+// maxColorsCap is the index of max_colors among the standard numbers.
+const maxColorsCap = 13
+
+// parseTerminfo reads the compiled format described in term(5): a header,
+// the standard booleans, numbers and strings, then optionally the same
+// again for extended capabilities, which carry their names along.
+func parseTerminfo(data []byte) (*terminfo, error) {
+	bad := fmt.Errorf("malformed terminfo entry")
+	le := binary.LittleEndian
+	short := func(o int) int {
+		if o+2 > len(data) {
+			return -1
+		}
+		return int(int16(le.Uint16(data[o:])))
+	}
+	numSize := 2
+	switch short(0) {
+	case 0432:
+	case 01036:
+		numSize = 4
+	default:
+		return nil, bad
+	}
+	number := func(o int) int {
+		if numSize == 2 {
+			return short(o)
+		}
+		if o+4 > len(data) {
+			return -1
+		}
+		return int(int32(le.Uint32(data[o:])))
+	}
+	even := func(o int) int { return (o + 1) &^ 1 }
+
+	names, bools, nums, strs, table := short(2), short(4), short(6), short(8), short(10)
+	if names < 0 || bools < 0 || nums < 0 || strs < 0 || table < 0 {
+		return nil, bad
+	}
+	ti := &terminfo{ext: make(map[string]bool)}
+	o := even(12 + names + bools)
+	if nums > maxColorsCap {
+		ti.colors = max(number(o+maxColorsCap*numSize), 0)
+	}
+	o = even(o + nums*numSize + strs*2 + table)
+
+	// extended section
+	eb, en, es, _, etable := short(o), short(o+2), short(o+4), short(o+6), short(o+8)
+	if eb < 0 || en < 0 || es < 0 || etable < 0 {
+		return ti, nil
+	}
+	o += 10
+	boolAt := o
+	o = even(o + eb)
+	o += en * numSize
+	strOffs := o
+	nameOffs := strOffs + es*2
+	tab := nameOffs + (eb+en+es)*2
+	if tab+etable > len(data) {
+		return nil, bad
+	}
+	strTab := data[tab : tab+etable]
+	// names follow the string values in the table
+	base := 0
+	for i := 0; i < es; i++ {
+		if v := short(strOffs + i*2); v >= 0 && v < len(strTab) {
+			end := v
+			for end < len(strTab) && strTab[end] != 0 {
+				end++
+			}
+			base = max(base, end+1)
+		}
+	}
+	for i := 0; i < eb; i++ {
+		off := short(nameOffs + i*2)
+		if off < 0 || base+off >= len(strTab) {
+			continue
+		}
+		end := base + off
+		for end < len(strTab) && strTab[end] != 0 {
+			end++
+		}
+		ti.ext[string(strTab[base+off:end])] = data[boolAt+i] == 1
+	}
+	return ti, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+	"sync"
+	"time"
+)
+
+// ttyrecWriter records what is drawn, written through it, in ttyrec's
+// format for ttyplay and the like: each write after a header of its time,
+// seconds and microseconds, and length, little-endian 32-bit numbers.
+// ttyrec has no way to say the terminal was resized.
+type ttyrecWriter struct {
+	mu sync.Mutex // frames may be written from the pacer's goroutine
+	f  *os.File
+	w  *bufio.Writer
+}
+
+func newTtyrecWriter(path string) (*ttyrecWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &ttyrecWriter{f: f, w: bufio.NewWriter(f)}
+	// the cursor is hidden before the first frame, where the recording
+	// starts
+	r.Write([]byte("\x1b[?25l"))
+	return r, nil
+}
+
+func (r *ttyrecWriter) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var h [12]byte
+	binary.LittleEndian.PutUint32(h[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(h[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(h[8:], uint32(len(b)))
+	r.w.Write(h[:])
+	r.w.Write(b)
+	return len(b), nil
+}
+
+func (r *ttyrecWriter) resize(cols, rows int) {}
+
+func (r *ttyrecWriter) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.w.Flush(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// videoQueue is how many frames may wait for ffmpeg before they are
+// dropped rather than holding up the game.
+const videoQueue = 70
+
+// videoExporter pipes the engine's frames, raw RGBA, to an ffmpeg
+// process for --export-video. Frames go out at a steady rate, the last
+// one repeated while the engine draws nothing new, as with the game paused
+// or in a menu, so the video keeps to the time played.
+type videoExporter struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stderr   bytes.Buffer
+	frames   chan []byte
+	done     chan error
+	interval time.Duration
+	next     time.Time
+	size     image.Point
+	last     []byte
+	dropped  int
+}
+
+// newVideoExporter starts ffmpeg writing path, its format going by the
+// name, from w×h frames at fps.
+func newVideoExporter(path string, w, h, fps int) (*videoExporter, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, err
+	}
+	// scaled up three times, sharply, since players shrink small videos
+	// smoothly; yuv420p for players that take nothing else
+	cmd := exec.Command(ffmpeg, "-loglevel", "error", "-y",
+		"-f", "rawvideo", "-pix_fmt", "rgba", "-s", fmt.Sprintf("%dx%d", w, h), "-r", strconv.Itoa(fps), "-i", "-",
+		"-vf", "scale=iw*3:ih*3:flags=neighbor", "-pix_fmt", "yuv420p", path)
+	v := &videoExporter{
+		cmd:      cmd,
+		frames:   make(chan []byte, videoQueue),
+		done:     make(chan error, 1),
+		interval: time.Second / time.Duration(fps),
+		size:     image.Pt(w, h),
+	}
+	if v.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	cmd.Stderr = &v.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		var err error
+		for f := range v.frames {
+			if err == nil {
+				_, err = v.stdin.Write(f)
+			}
+		}
+		v.done <- err
+	}()
+	return v, nil
+}
+
+// frame hands img on, repeating the last frame for any beats since it that
+// the engine drew nothing for.
+func (v *videoExporter) frame(img *image.RGBA, now time.Time) {
+	if img.Rect.Size() != v.size || now.Before(v.next) {
+		return
+	}
+	if v.next.IsZero() {
+		v.next = now
+	}
+	for v.last != nil && now.Sub(v.next) >= v.interval {
+		v.send(v.last)
+		v.next = v.next.Add(v.interval)
+	}
+	v.next = v.next.Add(v.interval)
+	v.last = append([]byte(nil), img.Pix...)
+	v.send(v.last)
+}
+
+func (v *videoExporter) send(f []byte) {
+	select {
+	case v.frames <- f:
+	default:
+		v.dropped++
+	}
+}
+
+// close lets ffmpeg finish the file and waits for it.
+func (v *videoExporter) close() error {
+	close(v.frames)
+	werr := <-v.done
+	v.stdin.Close()
+	if err := v.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(v.stderr.String()); msg != "" {
+			return fmt.Errorf("ffmpeg: %s", msg)
+		}
+		return err
+	}
+	if werr != nil {
+		return werr
+	}
+	if v.dropped > 0 {
+		return fmt.Errorf("dropped %d frames ffmpeg could not keep up with", v.dropped)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// wad gives read access to the lumps of the game's WAD files, for the
+// features that need DOOM's own graphics to make sense of frames.
+type wad struct {
+	lumps map[string][]byte
+}
+
+// iwadNames are tried in the current directory when -iwad isn't given,
+// in the engine's order.
+var iwadNames = []string{
+	"doom2.wad", "plutonia.wad", "tnt.wad", "doom.wad", "doom1.wad",
+	"chex.wad", "hacx.wad", "freedm.wad", "freedoom2.wad", "freedoom1.wad",
+}
+
+// openGameWADs loads the IWAD and any -file PWADs named in the engine's
+// arguments, later lumps replacing earlier ones as in the engine.
+func openGameWADs(args []string) (*wad, error) {
+	var files []string
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "-iwad":
+			if i+1 < len(args) {
+				files = append([]string{args[i+1]}, files...)
+				i++
+			}
+		case "-file":
+			for i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				i++
+				if strings.EqualFold(filepath.Ext(args[i]), ".wad") {
+					files = append(files, args[i])
+				}
+			}
+		}
+	}
+	if len(files) == 0 || !strings.EqualFold(filepath.Ext(files[0]), ".wad") {
+		for _, n := range iwadNames {
+			if _, err := os.Stat(n); err == nil {
+				files = append([]string{n}, files...)
+				break
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no IWAD found")
+	}
+	w := &wad{lumps: make(map[string][]byte)}
+	for _, f := range files {
+		if err := w.load(f); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// load reads every lump of one WAD file.
+func (w *wad) load(path string) error {
+	lumps, err := readWAD(path)
+	if err != nil {
+		return err
+	}
+	for _, l := range lumps {
+		w.lumps[strings.ToUpper(l.name)] = l.data
+	}
+	return nil
+}
+
+// wadLump is a lump of a WAD file.
+type wadLump struct {
+	name string
+	data []byte
+}
+
+// readWAD reads the lumps of a WAD file, in its order.
+func readWAD(path string) ([]wadLump, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	if len(data) < 12 || (string(data[:4]) != "IWAD" && string(data[:4]) != "PWAD") {
+		return nil, fmt.Errorf("%s: not a WAD file", path)
+	}
+	n, dir := int(le.Uint32(data[4:])), int(le.Uint32(data[8:]))
+	if dir < 0 || n < 0 || dir+n*16 > len(data) {
+		return nil, fmt.Errorf("%s: bad lump directory", path)
+	}
+	var lumps []wadLump
+	for i := 0; i < n; i++ {
+		e := data[dir+i*16:]
+		pos, size := int(le.Uint32(e)), int(le.Uint32(e[4:]))
+		if pos < 0 || size < 0 || pos+size > len(data) {
+			continue
+		}
+		lumps = append(lumps, wadLump{strings.TrimRight(string(e[8:16]), "\x00"), data[pos : pos+size]})
+	}
+	return lumps, nil
+}
+
+// readLumpFile reads a file given to -file: a WAD's lumps, or anything
+// else as one lump named after the file, as the engine takes them.
+func readLumpFile(path string) ([]wadLump, error) {
+	if strings.EqualFold(filepath.Ext(path), ".wad") {
+		return readWAD(path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToUpper(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	if len(name) > 8 {
+		name = name[:8]
+	}
+	return []wadLump{{name, data}}, nil
+}
+
+// palettes returns PLAYPAL: the normal palette, then the red, gold and
+// green tinted ones the engine switches to for damage, pickups and the
+// radiation suit.
+func (w *wad) palettes() ([][256]color.RGBA, error) {
+	data := w.lumps["PLAYPAL"]
+	if len(data) < 768 {
+		return nil, fmt.Errorf("no PLAYPAL")
+	}
+	pals := make([][256]color.RGBA, len(data)/768)
+	for p := range pals {
+		for i := range 256 {
+			o := p*768 + i*3
+			pals[p][i] = color.RGBA{data[o], data[o+1], data[o+2], 255}
+		}
+	}
+	return pals, nil
+}
+
+// patch is a decoded picture lump: palette indices, -1 where transparent.
+type patch struct {
+	w, h      int
+	left, top int // offsets the engine subtracts when drawing
+	pix       []int16
+}
+
+// patch decodes the named picture lump, DOOM's column-of-posts format.
+func (w *wad) patch(name string) (*patch, error) {
+	data := w.lumps[name]
+	le := binary.LittleEndian
+	if len(data) < 8 {
+		return nil, fmt.Errorf("no patch %s", name)
+	}
+	p := &patch{
+		w:    int(int16(le.Uint16(data))),
+		h:    int(int16(le.Uint16(data[2:]))),
+		left: int(int16(le.Uint16(data[4:]))),
+		top:  int(int16(le.Uint16(data[6:]))),
+	}
+	if p.w <= 0 || p.h <= 0 || len(data) < 8+p.w*4 {
+		return nil, fmt.Errorf("bad patch %s", name)
+	}
+	p.pix = make([]int16, p.w*p.h)
+	for i := range p.pix {
+		p.pix[i] = -1
+	}
+	for x := 0; x < p.w; x++ {
+		o := int(le.Uint32(data[8+x*4:]))
+		for o < len(data) && data[o] != 0xff {
+			if o+3 > len(data) {
+				break
+			}
+			top, n := int(data[o]), int(data[o+1])
+			for i := 0; i < n && o+3+i < len(data); i++ {
+				if y := top + i; y < p.h {
+					p.pix[y*p.w+x] = int16(data[o+3+i])
+				}
+			}
+			o += n + 4
+		}
+	}
+	return p, nil
+}
+
+// withLumps is an IWAD with lumps added on the end, where the engine finds
+// them ahead of its own lumps of the same names.
+type withLumps struct {
+	*os.File
+	header [12]byte
+	size   int64  // of the file
+	tail   []byte // the lumps, then the new lump directory
+}
+
+// addLumps gives f, if it is an IWAD, with lumps added to it.
+func addLumps(f *os.File, lumps []wadLump) (fs.File, error) {
+	w := &withLumps{File: f}
+	if _, err := f.ReadAt(w.header[:], 0); err != nil || string(w.header[:4]) != "IWAD" {
+		return f, nil
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	w.size = fi.Size()
+	le := binary.LittleEndian
+	n, dir := le.Uint32(w.header[4:]), le.Uint32(w.header[8:])
+	entries := make([]byte, n*16)
+	if _, err := f.ReadAt(entries, int64(dir)); err != nil {
+		return nil, err
+	}
+	var data []byte
+	for _, l := range lumps {
+		var e [16]byte
+		le.PutUint32(e[0:], uint32(w.size)+uint32(len(data)))
+		le.PutUint32(e[4:], uint32(len(l.data)))
+		copy(e[8:], l.name)
+		entries = append(entries, e[:]...)
+		data = append(data, l.data...)
+	}
+	w.tail = append(data, entries...)
+	le.PutUint32(w.header[4:], n+uint32(len(lumps)))
+	le.PutUint32(w.header[8:], uint32(w.size)+uint32(len(data)))
+	return w, nil
+}
+
+func (w *withLumps) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		o := off + int64(n)
+		switch {
+		case o < int64(len(w.header)):
+			n += copy(p[n:], w.header[o:])
+		case o < w.size:
+			m, err := w.File.ReadAt(p[n:n+int(min(int64(len(p)-n), w.size-o))], o)
+			n += m
+			if err != nil {
+				return n, err
+			}
+		case o-w.size < int64(len(w.tail)):
+			n += copy(p[n:], w.tail[o-w.size:])
+		default:
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
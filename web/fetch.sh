@@ -0,0 +1,10 @@
+#!/bin/sh
+# Vendors xterm.js and its fit addon, which the browser player loads, into
+# web/ for go:embed. Run by go generate; commit what it fetches.
+set -e
+cdn=https://cdn.jsdelivr.net/npm
+cd web
+curl -fsSL -o xterm.min.css $cdn/@xterm/xterm@5.5.0/css/xterm.min.css
+curl -fsSL -o xterm.min.js $cdn/@xterm/xterm@5.5.0/lib/xterm.min.js
+curl -fsSL -o addon-fit.min.js $cdn/@xterm/addon-fit@0.10.0/lib/addon-fit.min.js
+curl -fsSL -o LICENSE.xterm $cdn/@xterm/xterm@5.5.0/LICENSE
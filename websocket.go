@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// WebSocket opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// wsMaxMessage is the longest message taken from a browser, which only
+// sends keys and sizes.
+const wsMaxMessage = 1 << 20
+
+// wsConn is the server end of a WebSocket, as much of RFC 6455 as a page
+// sending keys and receiving frames needs: no extensions or subprotocols.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	mu   sync.Mutex // serializes writes
+}
+
+// upgradeWebSocket takes over an HTTP request asking for a WebSocket.
+// Pages from elsewhere are turned away, so another site open in the
+// browser can't reach a game on localhost.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket", http.StatusBadRequest)
+		return nil, errors.New("not a WebSocket request")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "cross-origin WebSocket", http.StatusForbidden)
+			return nil, errors.New("cross-origin request from " + origin)
+		}
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't take over the connection", http.StatusInternalServerError)
+		return nil, errors.New("connection can't be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+// read returns the next text or binary message, answering pings on the way.
+// A close from the other end is io.EOF.
+func (c *wsConn) read() (op byte, msg []byte, err error) {
+	for {
+		fin, frameOp, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOp {
+		case wsPing:
+			c.write(wsPong, data)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.write(wsClose, nil)
+			return 0, nil, io.EOF
+		case wsContinuation:
+			if op == 0 {
+				return 0, nil, errors.New("websocket: continuation of nothing")
+			}
+		default:
+			op = frameOp
+		}
+		if len(msg)+len(data) > wsMaxMessage {
+			return 0, nil, errors.New("websocket: message too long")
+		}
+		msg = append(msg, data...)
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, data []byte, err error) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.r, h[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op = h[0]&0x80 != 0, h[0]&0x0f
+	if h[1]&0x80 == 0 {
+		return false, 0, nil, errors.New("websocket: unmasked frame from the client")
+	}
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.r, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if n > wsMaxMessage {
+		return false, 0, nil, errors.New("websocket: frame too long")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	data = make([]byte, n)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+	return fin, op, data, nil
+}
+
+// write sends one unfragmented message.
+func (c *wsConn) write(op byte, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := []byte{0x80 | op}
+	switch n := len(data); {
+	case n < 126:
+		h = append(h, byte(n))
+	case n <= 0xffff:
+		h = append(h, 126)
+		h = binary.BigEndian.AppendUint16(h, uint16(n))
+	default:
+		h = append(h, 127)
+		h = binary.BigEndian.AppendUint64(h, uint64(n))
+	}
+	if _, err := c.conn.Write(h); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// close says goodbye and closes the connection.
+func (c *wsConn) close() {
+	c.write(wsClose, nil)
+	c.conn.Close()
+}
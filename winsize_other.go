@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func cellPixels() (w, h int, ok bool) {
+	return 0, 0, false
+}
+
+// notifyResize can't watch for resizes here; the size is polled instead.
+func notifyResize(ch chan<- os.Signal) bool {
+	return false
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+
+	"golang.org/x/sys/unix"
+)
+
+// cellPixels reports the size of one terminal cell in pixels, if the
+// terminal fills in the pixel fields of its window size.
+func cellPixels() (w, h int, ok bool) {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 || ws.Row == 0 || ws.Xpixel == 0 || ws.Ypixel == 0 {
+		return 0, 0, false
+	}
+	return int(ws.Xpixel / ws.Col), int(ws.Ypixel / ws.Row), true
+}
+
+// notifyResize sends on ch whenever the terminal is resized, reporting
+// whether it can.
+func notifyResize(ch chan<- os.Signal) bool {
+	signal.Notify(ch, unix.SIGWINCH)
+	return true
+}